@@ -5,6 +5,7 @@ import (
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/engine/standard"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Apns struct {
@@ -28,5 +29,8 @@ func httpStart() {
 	// 启动配置热更新
 	e.GET("/reload", reload)
 
+	// prometheus metrics, the go runtime and process metrics of the default registry
+	e.GET("/metrics", standard.WrapHandler(promhttp.Handler()))
+
 	e.Run(standard.New(":8908"))
 }
@@ -1,9 +1,18 @@
 package service
 
-import "github.com/labstack/echo"
+import (
+	"github.com/labstack/echo"
+	"github.com/uber-go/zap"
+)
 
 func reload(c echo.Context) error {
 	loadConfig(false)
 
+	// 用户文件有误时继续使用原来的用户
+	if err := gAuth.load(Conf.Auth.File); err != nil {
+		Logger.Warn("reload auth error", zap.Error(err))
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	mqtt "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/proto"
+	"github.com/uber-go/zap"
+)
+
+// authServer 为gateway的center鉴权提供Center.Auth,
+// 用户文件每行为"username:bcrypt hash", 与gateway的file鉴权格式相同
+type authServer struct {
+	sync.RWMutex
+	users map[string][]byte
+}
+
+var gAuth = &authServer{users: make(map[string][]byte)}
+
+var (
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+// dummy 用户不存在时也比较一次bcrypt, 响应时间不会暴露哪些用户存在
+func dummy() []byte {
+	dummyOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gomqtt"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// load 重新读取用户文件, 读取失败时保留原来的用户
+func (as *authServer) load(path string) error {
+	users := make(map[string][]byte)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			i := strings.IndexByte(line, ':')
+			if i <= 0 {
+				return fmt.Errorf("auth file %s line %d: invalid format", path, n)
+			}
+			users[line[:i]] = []byte(line[i+1:])
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	as.Lock()
+	as.users = users
+	as.Unlock()
+	return nil
+}
+
+// Auth 不认识的用户交给gateway的下一个鉴权方式
+func (as *authServer) Auth(ctx context.Context, am *proto.AuthMsg) (*proto.AuthReply, error) {
+	as.RLock()
+	hash, ok := as.users[am.Un]
+	as.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummy(), am.Pw)
+		return &proto.AuthReply{Ignore: true}, nil
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, am.Pw) != nil {
+		Logger.Info("Auth failed", zap.String("un", am.Un), zap.String("cid", am.Cid), zap.String("ip", am.Ip))
+		return &proto.AuthReply{Code: int32(mqtt.ErrBadUsernameOrPassword)}, nil
+	}
+
+	return &proto.AuthReply{}, nil
+}
+
+func grpcStart() {
+	if err := gAuth.load(Conf.Auth.File); err != nil {
+		Logger.Panic("Auth", zap.Error(err))
+	}
+
+	l, err := net.Listen("tcp", Conf.Grpc.Addr)
+	if err != nil {
+		Logger.Panic("Init", zap.Error(err))
+	}
+
+	gs := grpc.NewServer()
+	proto.RegisterCenterServer(gs, gAuth)
+	go gs.Serve(l)
+}
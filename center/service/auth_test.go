@@ -0,0 +1,54 @@
+package service
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+	context "golang.org/x/net/context"
+
+	mqtt "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/proto"
+	"github.com/uber-go/zap"
+)
+
+func Test_authServer_Auth(t *testing.T) {
+	Logger = zap.New(zap.NewJSONEncoder(), zap.DiscardOutput)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	path := filepath.Join(t.TempDir(), "users")
+	if err := ioutil.WriteFile(path, []byte("# users\nalice:"+string(hash)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	as := &authServer{}
+	if err := as.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		msg  *proto.AuthMsg
+		want proto.AuthReply
+	}{
+		{"accepted", &proto.AuthMsg{Un: "alice", Pw: []byte("secret")}, proto.AuthReply{}},
+		{"bad password", &proto.AuthMsg{Un: "alice", Pw: []byte("wrong")}, proto.AuthReply{Code: int32(mqtt.ErrBadUsernameOrPassword)}},
+		{"unknown user", &proto.AuthMsg{Un: "bob"}, proto.AuthReply{Ignore: true}},
+	}
+	for _, tt := range tests {
+		reply, err := as.Auth(context.Background(), tt.msg)
+		if err != nil || *reply != tt.want {
+			t.Errorf("%s: Auth() = %+v, %v, want %+v", tt.name, reply, err, tt.want)
+		}
+	}
+
+	// a broken file keeps the users
+	ioutil.WriteFile(path, []byte("broken\n"), 0644)
+	if err := as.load(path); err == nil {
+		t.Error("load() of a broken file succeeded")
+	}
+	if reply, _ := as.Auth(context.Background(), &proto.AuthMsg{Un: "alice", Pw: []byte("secret")}); reply.Code != 0 || reply.Ignore {
+		t.Errorf("Auth() after a broken reload = %+v, want accepted", reply)
+	}
+}
@@ -17,6 +17,16 @@ type Config struct {
 		LogLevel string
 		LogPath  string
 	}
+
+	Grpc struct {
+		// gateway通过这个地址调用Center.Auth
+		Addr string
+	}
+
+	Auth struct {
+		// 用户文件, 每行为"username:bcrypt hash", 为空时所有用户都交给gateway的下一个鉴权方式
+		File string
+	}
 }
 
 var Conf = &Config{}
@@ -15,8 +15,10 @@
 package cmd
 
 import (
-	"net/http"
+	"fmt"
+	"os"
 
+	"github.com/aiyun/gomqtt/gateway/gate"
 	"github.com/spf13/cobra"
 )
 
@@ -40,9 +42,20 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// reloadCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-
+	reloadCmd.Flags().BoolP("static_config", "c", false, "using static config")
 }
 
 func reload(cmd *cobra.Command, args []string) {
-	http.Get("http://localhost:8907/reload")
+	// admin的地址和token从配置文件读取
+	configFile := gate.DefaultConfigFile
+	if isStatic, _ := cmd.Flags().GetBool("static_config"); isStatic {
+		configFile = gate.StaticConfigFile
+	}
+
+	body, err := gate.RequestReload(configFile)
+	if err != nil {
+		fmt.Println("reload error:", err)
+		os.Exit(-1)
+	}
+	fmt.Println(body)
 }
@@ -15,12 +15,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/aiyun/gomqtt/gateway/dispatch"
 	"github.com/aiyun/gomqtt/gateway/gate"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -70,15 +70,38 @@ func initConfig() {
 }
 
 func start(cmd *cobra.Command, args []string) {
-	isStatic, _ := cmd.Flags().GetBool("static_config")
-	g := gate.New()
-	g.Start(isStatic)
+	opts := gate.Options{ConfigFile: gate.DefaultConfigFile}
+	if isStatic, _ := cmd.Flags().GetBool("static_config"); isStatic {
+		//静态配置
+		opts.ConfigFile = gate.StaticConfigFile
+	}
+
+	g, err := gate.New(opts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	d := dispatch.New()
-	go d.Start()
+	if err := g.Start(ctx); err != nil {
+		fmt.Println(err)
+		g.Shutdown(ctx)
+		os.Exit(-1)
+	}
 
 	// 等待服务器停止信号
-	chSig := make(chan os.Signal)
+	chSig := make(chan os.Signal, 1)
 	signal.Notify(chSig, syscall.SIGINT, syscall.SIGTERM)
 	<-chSig
+
+	// close the connections gradually, the clients reconnect to the other rooms, a second signal stops waiting
+	go func() {
+		<-chSig
+		cancel()
+	}()
+	if err := g.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+	}
 }
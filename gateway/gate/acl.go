@@ -0,0 +1,186 @@
+package gate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/naoina/toml"
+)
+
+// access types
+const (
+	aclPub = 1 << iota
+	aclSub
+)
+
+// aclRule is one [[rule]] in the acl file, a rule applies to the clients matching any of
+// user, client and cn, or to everyone if all of them are empty.
+// %u and %c in the topics are replaced by the username and client id.
+type aclRule struct {
+	User   string
+	Client string
+	Cn     string
+
+	// pub, sub or pubsub
+	Access string
+	// allow or deny
+	Action string
+	Topics []string
+
+	access int
+	allow  bool
+}
+
+type aclFile struct {
+	Rule []*aclRule
+}
+
+// aclRules is replaced as a whole on reload
+type aclRules struct {
+	rules []*aclRule
+	// the result when no rule matches
+	allow bool
+}
+
+func loadAcl(path string, def string) (*aclRules, error) {
+	rs := &aclRules{}
+
+	switch strings.ToLower(def) {
+	case "", "allow":
+		rs.allow = true
+	case "deny":
+		rs.allow = false
+	default:
+		return nil, fmt.Errorf("invalid acl default: %s", def)
+	}
+
+	if path == "" {
+		return rs, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	af := &aclFile{}
+	if err := toml.Unmarshal(contents, af); err != nil {
+		return nil, err
+	}
+
+	for i, r := range af.Rule {
+		switch strings.ToLower(r.Access) {
+		case "pub":
+			r.access = aclPub
+		case "sub":
+			r.access = aclSub
+		case "pubsub", "":
+			r.access = aclPub | aclSub
+		default:
+			return nil, fmt.Errorf("acl rule %d: invalid access %s", i, r.Access)
+		}
+
+		switch strings.ToLower(r.Action) {
+		case "allow":
+			r.allow = true
+		case "deny":
+			r.allow = false
+		default:
+			return nil, fmt.Errorf("acl rule %d: invalid action %s", i, r.Action)
+		}
+	}
+	rs.rules = af.Rule
+
+	return rs, nil
+}
+
+// aclCheck reports whether the client is allowed to publish to a topic or subscribe to a filter
+func (g *Gate) aclCheck(cred *Credential, access int, topic string) bool {
+	rs := g.acls.Load().(*aclRules)
+	if cred == nil {
+		cred = &Credential{}
+	}
+
+	// only the gateway publishes to $SYS
+	sys := isSysTopic(topic)
+	if sys && access == aclPub {
+		return false
+	}
+
+	// $share is a subscription prefix, not a topic
+	if access == aclPub && strings.HasPrefix(topic, sharePrefix) {
+		return false
+	}
+
+	for _, r := range rs.rules {
+		if r.access&access == 0 || !r.appliesTo(cred) {
+			continue
+		}
+
+		for _, t := range r.Topics {
+			pattern, ok := expandTopic(t, cred)
+			if !ok {
+				continue
+			}
+
+			if r.matches(access, pattern, topic) {
+				return r.allow
+			}
+		}
+	}
+
+	// the default doesn't apply to $SYS, it must be allowed by a rule
+	if sys {
+		return false
+	}
+
+	return rs.allow
+}
+
+func (r *aclRule) appliesTo(cred *Credential) bool {
+	if r.User == "" && r.Client == "" && r.Cn == "" {
+		return true
+	}
+
+	return (r.User != "" && r.User == cred.Username) ||
+		(r.Client != "" && r.Client == cred.ClientID) ||
+		(r.Cn != "" && r.Cn == cred.CertCN)
+}
+
+func (r *aclRule) matches(access int, pattern, topic string) bool {
+	if access == aclPub {
+		return proto.TopicMatch(pattern, topic)
+	}
+
+	// subscribing: an allow rule must cover the whole filter, while a deny rule
+	// works as long as the filter may receive any denied topic
+	if r.allow {
+		return filterCovers(pattern, topic)
+	}
+	return filterOverlaps(pattern, topic)
+}
+
+// expandTopic replaces the placeholders, false is returned if the value can't be used in a topic
+func expandTopic(t string, cred *Credential) (string, bool) {
+	if strings.Contains(t, "%u") {
+		if !validTopicLevel(cred.Username) {
+			return "", false
+		}
+		t = strings.Replace(t, "%u", cred.Username, -1)
+	}
+
+	if strings.Contains(t, "%c") {
+		if !validTopicLevel(cred.ClientID) {
+			return "", false
+		}
+		t = strings.Replace(t, "%c", cred.ClientID, -1)
+	}
+
+	return t, true
+}
+
+func validTopicLevel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "/+#")
+}
@@ -0,0 +1,126 @@
+package gate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testAcl = `
+[[rule]]
+action = "deny"
+topics = ["$SYS/#", "secret/#"]
+
+[[rule]]
+user = "admin"
+action = "allow"
+topics = ["#"]
+
+[[rule]]
+action = "allow"
+topics = ["users/%u/#"]
+
+[[rule]]
+access = "sub"
+action = "allow"
+topics = ["broadcast/#"]
+`
+
+func Test_aclCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "acl.toml")
+	if err := ioutil.WriteFile(path, []byte(testAcl), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := loadAcl(path, "deny")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := testGate(t, nil)
+	g.acls.Store(rs)
+
+	admin := &Credential{Username: "admin"}
+	bob := &Credential{Username: "bob"}
+	bad := &Credential{Username: "a/b"}
+
+	tests := []struct {
+		name   string
+		cred   *Credential
+		access int
+		topic  string
+		want   bool
+	}{
+		{"admin pub", admin, aclPub, "any/topic", true},
+		{"admin sub", admin, aclSub, "devices/#", true},
+		{"admin sub all overlaps secret", admin, aclSub, "#", false},
+		{"admin secret", admin, aclPub, "secret/a", false},
+		{"admin sub overlaps secret", admin, aclSub, "+/a", false},
+		{"own topic", bob, aclPub, "users/bob/x", true},
+		{"own filter", bob, aclSub, "users/bob/#", true},
+		{"other's topic", bob, aclPub, "users/alice/x", false},
+		{"filter wider than allowed", bob, aclSub, "users/+/x", false},
+		{"sub only", bob, aclSub, "broadcast/news", true},
+		{"pub to sub only", bob, aclPub, "broadcast/news", false},
+		{"invalid placeholder value", bad, aclPub, "users/a/b/x", false},
+		{"no credential", nil, aclPub, "users//x", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.aclCheck(tt.cred, tt.access, tt.topic); got != tt.want {
+				t.Errorf("aclCheck() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_loadAcl(t *testing.T) {
+	rs, err := loadAcl("", "")
+	if err != nil || !rs.allow || len(rs.rules) != 0 {
+		t.Errorf("loadAcl() = %v, %v, want allow without rules", rs, err)
+	}
+
+	if _, err := loadAcl("", "maybe"); err == nil {
+		t.Errorf("loadAcl() accepted an invalid default")
+	}
+}
+
+func Test_aclCheck_sys(t *testing.T) {
+	g := testGate(t, nil)
+	g.acls.Store(&aclRules{
+		rules: []*aclRule{{User: "monitor", Topics: []string{"$SYS/#"}, access: aclSub, allow: true}},
+		allow: true,
+	})
+
+	monitor := &Credential{Username: "monitor"}
+	bob := &Credential{Username: "bob"}
+
+	tests := []struct {
+		name   string
+		cred   *Credential
+		access int
+		topic  string
+		want   bool
+	}{
+		{"allowed by rule", monitor, aclSub, "$SYS/#", true},
+		{"default doesn't apply", bob, aclSub, "$SYS/room1/uptime", false},
+		{"nobody publishes", monitor, aclPub, "$SYS/room1/uptime", false},
+		{"will to $SYS", nil, aclPub, "$SYS/fake", false},
+		{"all topics", bob, aclSub, "#", true},
+		{"publish to $share", bob, aclPub, "$share/g1/a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := g.aclCheck(tt.cred, tt.access, tt.topic); got != tt.want {
+				t.Errorf("aclCheck() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
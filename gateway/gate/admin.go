@@ -1,23 +1,122 @@
 package gate
 
 import (
+	"net"
+	"net/http"
+
 	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/uber-go/zap"
 )
 
-func adminStart() {
-	e := echo.New()
+// the admin server by default
+const defaultAdminAddr = ":8907"
 
-	// configuration hot update
-	e.GET("/reload", reload)
+func (g *Gate) adminStart() error {
+	addr := g.Config().Admin.Addr
+	if addr == "" {
+		addr = defaultAdminAddr
+	}
 
-	err := e.Start(":8907")
+	srv, err := g.serveHTTP(addr, g.newAdmin())
 	if err != nil {
-		e.Logger.Fatal(err.Error())
+		return err
 	}
+	g.admin = srv
+
+	return nil
 }
 
-func reload(c echo.Context) error {
-	loadConfig(false)
+// serveHTTP listens on addr and serves h in the background
+func (g *Gate) serveHTTP(addr string, h http.Handler) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	srv := &http.Server{Handler: h}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			g.logger.Error("http serve error", zap.Error(err), zap.String("addr", addr))
+		}
+	}()
+
+	return srv, nil
+}
+
+func (g *Gate) newAdmin() *echo.Echo {
+	e := echo.New()
+
+	// configuration hot update, it swaps the auth, acls, hooks and rules, so it needs the token
+	e.POST("/reload", g.reload, g.adminAuth)
+
+	// stats of the listeners
+	e.GET("/listeners", g.listenersInfo)
+
+	// stats of the event webhooks
+	e.GET("/webhooks", g.webhooksInfo)
+
+	// prometheus metrics
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+
+	// live connections, by conn id or client id
+	conns := e.Group("/conns", g.adminAuth)
+	conns.GET("", g.connsList)
+	conns.GET("/:id", g.connGet)
+	conns.DELETE("/:id", g.connKick)
+	conns.POST("/:id/publish", g.connPublish)
+
+	clients := e.Group("/clients", g.adminAuth)
+	clients.GET("/:client", g.connGet)
+	clients.DELETE("/:client", g.connKick)
+	clients.POST("/:client/publish", g.connPublish)
+
+	// packet traces of the selected clients
+	tr := e.Group("/traces", g.adminAuth)
+	tr.GET("", g.tracesList)
+	tr.POST("", g.traceStart)
+	tr.DELETE("/:id", g.traceStop)
+	tr.GET("/:id/stream", g.traceStream)
+
+	return e
+}
+
+// reload reports the changed settings, 409 if some of them need a restart,
+// 400 if the config is invalid, nothing is applied in both cases
+func (g *Gate) reload(c echo.Context) error {
+	changed, err := g.reloadConfig()
+	res := &reloadResult{Changed: changed}
+	if res.Changed == nil {
+		res.Changed = []string{}
+	}
+	if err == nil {
+		return c.JSON(http.StatusOK, res)
+	}
+
+	g.logger.Warn("reload config error", zap.Error(err))
+	res.Error = err.Error()
+	if re, ok := err.(*restartError); ok {
+		res.Restart = re.fields
+		return c.JSON(http.StatusConflict, res)
+	}
+	return c.JSON(http.StatusBadRequest, res)
+}
+
+func (g *Gate) listenersInfo(c echo.Context) error {
+	infos := make([]listenerInfo, 0, len(g.listeners))
+	for _, l := range g.listeners {
+		infos = append(infos, l.info())
+	}
+
+	return c.JSON(http.StatusOK, infos)
+}
+
+func (g *Gate) webhooksInfo(c echo.Context) error {
+	whs := g.getWebhooks()
+	infos := make([]webhookInfo, 0, len(whs))
+	for _, wh := range whs {
+		infos = append(infos, wh.info())
+	}
+
+	return c.JSON(http.StatusOK, infos)
 }
@@ -0,0 +1,268 @@
+package gate
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/labstack/echo"
+	"github.com/uber-go/zap"
+)
+
+// the default number of connections returned by the list api
+const defaultListLimit = 1000
+
+var errInvalidQos = errors.New("qos must be 0 or 1")
+
+type connSummary struct {
+	ID        int       `json:"id"`
+	ClientID  string    `json:"client_id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Listener  string    `json:"listener"`
+	Connected time.Time `json:"connected"`
+}
+
+type connDetail struct {
+	connSummary
+	Addr       string          `json:"addr"`
+	CertCN     string          `json:"cert_cn,omitempty"`
+	Keepalive  uint16          `json:"keepalive"`
+	InCount    int64           `json:"in_count"`
+	OutCount   int64           `json:"out_count"`
+	Subs       map[string]byte `json:"subs"`
+	LimitClass string          `json:"limit_class,omitempty"`
+}
+
+type publishReq struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+	// the payload is base64 encoded
+	Base64 bool `json:"base64"`
+	Qos    byte `json:"qos"`
+	Retain bool `json:"retain"`
+}
+
+// adminAuth checks the bearer token in [admin] token
+func (g *Gate) adminAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := g.Config().Admin.Token
+		if token == "" {
+			return echo.NewHTTPError(http.StatusForbidden, "admin token is not configured")
+		}
+
+		auth := c.Request().Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[7:]), []byte(token)) != 1 {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+		}
+
+		return next(c)
+	}
+}
+
+func (ci *connInfo) summary() connSummary {
+	s := connSummary{
+		ID:        ci.id,
+		IP:        ci.ip,
+		Connected: ci.connected,
+	}
+	if ci.cp != nil {
+		s.ClientID = string(ci.cp.ClientId())
+	}
+	if ci.cred != nil {
+		s.Username = ci.cred.Username
+	}
+	if ci.l != nil {
+		s.Listener = ci.l.name
+	}
+
+	return s
+}
+
+func (ci *connInfo) detail() connDetail {
+	d := connDetail{
+		connSummary: ci.summary(),
+		InCount:     ci.inCount.Load(),
+		OutCount:    ci.outCount.Load(),
+		Subs:        make(map[string]byte),
+	}
+	if ci.addr != nil {
+		d.Addr = ci.addr.String()
+	}
+	if ci.cred != nil {
+		d.CertCN = ci.cred.CertCN
+	}
+	if ci.cp != nil {
+		d.Keepalive = ci.cp.KeepAlive()
+	}
+	if ci.limits != nil {
+		d.LimitClass = ci.limits.class.Name
+	}
+
+	ci.slock.RLock()
+	for t, qos := range ci.subs {
+		d.Subs[t] = qos
+	}
+	ci.slock.RUnlock()
+
+	return d
+}
+
+// connsList lists the connections matching all the given filters: username, client_id, ip and listener
+func (g *Gate) connsList(c echo.Context) error {
+	limit := defaultListLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = n
+	}
+
+	filters := map[string]string{}
+	for _, k := range []string{"username", "client_id", "ip", "listener"} {
+		if v := c.QueryParam(k); v != "" {
+			filters[k] = v
+		}
+	}
+
+	cis := g.conns.list()
+	list := make([]connSummary, 0, len(cis))
+	for _, ci := range cis {
+		s := ci.summary()
+		if s.match(filters) {
+			list = append(list, s)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	if len(list) > limit {
+		list = list[:limit]
+	}
+
+	return c.JSON(http.StatusOK, list)
+}
+
+func (s connSummary) match(filters map[string]string) bool {
+	for k, v := range filters {
+		var got string
+		switch k {
+		case "username":
+			got = s.Username
+		case "client_id":
+			got = s.ClientID
+		case "ip":
+			got = s.IP
+		case "listener":
+			got = s.Listener
+		}
+
+		if got != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findConn returns the connection of the :id or :client parameter
+func (g *Gate) findConn(c echo.Context) (*connInfo, error) {
+	var ci *connInfo
+	if client := c.Param("client"); client != "" {
+		ci = g.conns.byClient(client)
+	} else {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid conn id")
+		}
+		ci = g.getCI(id)
+	}
+
+	if ci == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "connection not found")
+	}
+
+	return ci, nil
+}
+
+func (g *Gate) connGet(c echo.Context) error {
+	ci, err := g.findConn(c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, ci.detail())
+}
+
+func (g *Gate) connKick(c echo.Context) error {
+	ci, err := g.findConn(c)
+	if err != nil {
+		return err
+	}
+
+	g.logger.Info("connection kicked", zap.Int("cid", ci.id), zap.String("ip", ci.ip), zap.String("from", c.RealIP()))
+	ci.close(closeKicked)
+
+	return c.JSON(http.StatusOK, ci.summary())
+}
+
+// connPublish sends a message to the client directly, the acl and the subscriptions are not checked
+func (g *Gate) connPublish(c echo.Context) error {
+	ci, err := g.findConn(c)
+	if err != nil {
+		return err
+	}
+
+	req := &publishReq{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	p, err := req.packet(ci)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := ci.write(p); err != nil {
+		g.logger.Info("admin publish error", zap.Error(err), zap.Int("cid", ci.id))
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"id": ci.id, "packet_id": p.PacketID()})
+}
+
+func (req *publishReq) packet(ci *connInfo) (*proto.PublishPacket, error) {
+	payload := []byte(req.Payload)
+	if req.Base64 {
+		b, err := base64.StdEncoding.DecodeString(req.Payload)
+		if err != nil {
+			return nil, err
+		}
+		payload = b
+	}
+
+	p := proto.NewPublishPacket()
+	if err := p.SetTopic([]byte(req.Topic)); err != nil {
+		return nil, err
+	}
+
+	// qos 2 isn't supported by the gateway
+	if req.Qos > 1 {
+		return nil, errInvalidQos
+	}
+	p.SetQoS(req.Qos)
+	if req.Qos > 0 {
+		p.SetPacketID(ci.packetID())
+	}
+
+	p.SetRetain(req.Retain)
+	p.SetPayload(payload)
+
+	return p, nil
+}
@@ -0,0 +1,121 @@
+package gate
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+)
+
+func adminRequest(t *testing.T, g *Gate, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	g.newAdmin().ServeHTTP(rec, req)
+	return rec
+}
+
+func testConn(g *Gate, clientID, username, ip string) (*connInfo, net.Conn) {
+	cp := proto.NewConnectPacket()
+	cp.SetClientId([]byte(clientID))
+	cp.SetKeepAlive(60)
+
+	sc, cc := net.Pipe()
+	ci := &connInfo{g: g, id: newCID(), c: sc, cp: cp, ip: ip, cred: &Credential{Username: username}, subs: map[string]byte{"a/#": 1}}
+	g.saveCI(ci)
+
+	return ci, cc
+}
+
+func Test_adminAuth(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+
+	if rec := adminRequest(t, g, "GET", "/conns", "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("no token configured: code = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	conf.Admin.Token = "secret"
+	if rec := adminRequest(t, g, "GET", "/conns", "wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := adminRequest(t, g, "GET", "/conns", "secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("right token: code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func Test_adminConns(t *testing.T) {
+	conf := &Config{}
+	conf.Admin.Token = "secret"
+	g := testGate(t, conf)
+
+	ci1, cc1 := testConn(g, "adminc1", "alice", "1.1.1.1")
+	defer cc1.Close()
+	ci2, cc2 := testConn(g, "adminc2", "bob", "2.2.2.2")
+	defer cc2.Close()
+
+	// list with filters
+	rec := adminRequest(t, g, "GET", "/conns?username=bob", "secret", "")
+	var list []connSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ClientID != "adminc2" {
+		t.Errorf("list = %+v, want adminc2 only", list)
+	}
+
+	// details by client id
+	rec = adminRequest(t, g, "GET", "/clients/adminc1", "secret", "")
+	var d connDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != ci1.id || d.Keepalive != 60 || d.Subs["a/#"] != 1 {
+		t.Errorf("detail = %+v", d)
+	}
+
+	if rec := adminRequest(t, g, "GET", "/conns/0", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown conn: code = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// publish to the client
+	go func() {
+		rec := adminRequest(t, g, "POST", "/clients/adminc1/publish", "secret", `{"topic":"a/b","payload":"aGk=","base64":true,"qos":1}`)
+		if rec.Code != http.StatusOK {
+			t.Errorf("publish: code = %d, body %s", rec.Code, rec.Body)
+		}
+	}()
+	pt, _, _, err := service.ReadPacket(cc1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := pt.(*proto.PublishPacket)
+	if !ok || string(p.Topic()) != "a/b" || string(p.Payload()) != "hi" || p.QoS() != 1 || p.PacketID() == 0 {
+		t.Errorf("published = %v", pt)
+	}
+
+	if rec := adminRequest(t, g, "POST", "/clients/adminc1/publish", "secret", `{"topic":"a/#"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("wildcard topic: code = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// kick
+	if rec := adminRequest(t, g, "DELETE", "/conns/"+strconv.Itoa(ci2.id), "secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("kick: code = %d", rec.Code)
+	}
+	if _, err := cc2.Read(make([]byte, 1)); err == nil {
+		t.Errorf("kicked connection is still open")
+	}
+	if ci2.closeReason != closeKicked {
+		t.Errorf("closeReason = %q, want %q", ci2.closeReason, closeKicked)
+	}
+}
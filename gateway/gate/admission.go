@@ -0,0 +1,283 @@
+package gate
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+	"github.com/uber-go/atomic"
+	"github.com/uber-go/zap"
+)
+
+// the reasons of rejecting a new connection
+const (
+	rejectRate     = "accept rate"
+	rejectGlobal   = "max conns"
+	rejectListener = "listener max conns"
+	rejectIP       = "max conns per ip"
+	rejectDraining = "draining"
+)
+
+const (
+	// the largest CONNECT read before authentication, it's enough for a jwt password and a will message
+	maxConnectRead = 16 << 10
+	// the largest CONNECT read from a rejected client, a larger one is closed without reply
+	maxRejectRead = 4 << 10
+	// rejected connections waiting for CONNECT at once, the others are closed without reply
+	maxRejecting = 1024
+)
+
+var (
+	errNotConnect      = errors.New("the first packet isn't connect")
+	errConnectTooLarge = errors.New("connect packet too large")
+)
+
+// admission limits the new connections, so a reconnecting storm can't take the gateway down
+type admission struct {
+	sync.Mutex
+	conns int
+	perIP map[string]int
+
+	bucket tokenBucket
+
+	// rejected connections being replied
+	rejecting atomic.Int64
+}
+
+func newAdmission() *admission {
+	return &admission{perIP: make(map[string]int)}
+}
+
+// acquire checks the limits in [limit] and of the listener, the reason is returned if the connection is rejected
+func (a *admission) acquire(l *listener, ip string) string {
+	a.Lock()
+	defer a.Unlock()
+
+	if l.g.draining.Load() {
+		return rejectDraining
+	}
+
+	limit := l.g.Config().Limit
+	if !a.bucket.take(limit.AcceptRate, limit.AcceptBurst, time.Now()) {
+		return rejectRate
+	}
+
+	if max := limit.MaxConns; max > 0 && a.conns >= max {
+		return rejectGlobal
+	}
+
+	if max := limit.MaxConnsPerIp; max > 0 && ip != "" && a.perIP[ip] >= max {
+		return rejectIP
+	}
+
+	if !l.acquire() {
+		return rejectListener
+	}
+
+	a.conns++
+	if ip != "" {
+		a.perIP[ip]++
+	}
+
+	// added under the lock, so Shutdown can't miss a connection admitted while draining starts
+	l.g.serving.Add(1)
+
+	return ""
+}
+
+func (a *admission) release(l *listener, ip string) {
+	l.release()
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.conns--
+	if ip == "" {
+		return
+	}
+
+	if a.perIP[ip] <= 1 {
+		delete(a.perIP, ip)
+	} else {
+		a.perIP[ip]--
+	}
+}
+
+// limitIP returns the ip used by the per-ip limit, unix sockets are not limited
+func limitIP(addr net.Addr) string {
+	if _, ok := addr.(*net.TCPAddr); !ok {
+		return ""
+	}
+
+	return addrIP(addr)
+}
+
+// tokenBucket allows rate tokens per second, at most burst tokens can be saved.
+// The rate and burst are passed in each call, so they can be changed by reloading.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (tb *tokenBucket) take(rate float64, burst int, now time.Time) bool {
+	return tb.takeN(rate, float64(burst), 1, now)
+}
+
+// takeN takes n tokens, a request larger than the burst passes when the bucket is full
+func (tb *tokenBucket) takeN(rate, burst, n float64, now time.Time) bool {
+	// no limit
+	if rate <= 0 {
+		return true
+	}
+
+	burst = bucketSize(burst)
+	tb.refill(rate, burst, now)
+
+	if tb.tokens < n && tb.tokens < burst {
+		return false
+	}
+
+	tb.tokens -= n
+	return true
+}
+
+// reserve takes n tokens anyway, the caller should wait for the returned duration before going on
+func (tb *tokenBucket) reserve(rate, burst, n float64, now time.Time) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+
+	tb.refill(rate, bucketSize(burst), now)
+	tb.tokens -= n
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / rate * float64(time.Second))
+}
+
+// bucketSize is the burst holding at least one token
+func bucketSize(burst float64) float64 {
+	if burst < 1 {
+		return 1
+	}
+	return burst
+}
+
+// refill is called with the burst of bucketSize
+func (tb *tokenBucket) refill(rate, burst float64, now time.Time) {
+	if tb.last.IsZero() {
+		tb.tokens = burst
+	} else {
+		tb.tokens += now.Sub(tb.last).Seconds() * rate
+		if tb.tokens > burst {
+			tb.tokens = burst
+		}
+	}
+	tb.last = now
+}
+
+// rejectConn tells the client the server is unavailable. CONNECT is read before replying,
+// closing a socket with unread data may reset the connection and lose the CONNACK.
+// The rejected clients are bounded by maxRejecting and maxRejectRead, so a storm of them costs little.
+func rejectConn(l *listener, c net.Conn, reason string) {
+	defer c.Close()
+
+	switch reason {
+	case rejectRate:
+		stats.rejectedRate.Inc()
+	case rejectGlobal:
+		stats.rejectedGlobal.Inc()
+	case rejectIP:
+		stats.rejectedIP.Inc()
+	case rejectDraining:
+		stats.rejectedDraining.Inc()
+	}
+
+	l.g.logger.Info("connection rejected", zap.String("listener", l.name), zap.String("ip", c.RemoteAddr().String()), zap.String("reason", reason))
+
+	a := l.g.admit
+	if a.rejecting.Inc() > maxRejecting {
+		a.rejecting.Dec()
+		return
+	}
+	defer a.rejecting.Dec()
+
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+	if !skipConnect(c) {
+		return
+	}
+
+	reply := proto.NewConnackPacket()
+	reply.SetReturnCode(proto.ErrServerUnavailable)
+	service.WritePacket(c, reply)
+}
+
+// skipConnect reads a CONNECT of at most maxRejectRead bytes without decoding it
+func skipConnect(c net.Conn) bool {
+	h, remLen, err := readConnectHeader(c, maxRejectRead)
+	if err != nil || h == nil {
+		return false
+	}
+
+	_, err = io.CopyN(ioutil.Discard, c, int64(remLen))
+	return err == nil
+}
+
+// readConnect reads the first packet of a client, a CONNECT of at most maxConnectRead bytes,
+// so an unauthenticated client can't make the gateway allocate a large buffer
+func readConnect(c net.Conn) (*proto.ConnectPacket, int, error) {
+	h, remLen, err := readConnectHeader(c, maxConnectRead)
+	if err != nil {
+		return nil, 0, err
+	}
+	if h == nil {
+		return nil, 0, errNotConnect
+	}
+
+	buf := make([]byte, len(h)+int(remLen))
+	copy(buf, h)
+	if _, err := io.ReadFull(c, buf[len(h):]); err != nil {
+		return nil, 0, err
+	}
+
+	cp := proto.NewConnectPacket()
+	n, err := cp.Decode(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cp, n, nil
+}
+
+// readConnectHeader reads the fixed header of the first packet, nil header is returned if it isn't a CONNECT
+func readConnectHeader(c net.Conn, max uint64) ([]byte, uint64, error) {
+	b := make([]byte, 5)
+	n := 0
+	for {
+		if _, err := io.ReadFull(c, b[n:n+1]); err != nil {
+			return nil, 0, err
+		}
+		if n >= 1 && b[n] < 0x80 {
+			break
+		}
+		if n++; n == len(b) {
+			return nil, 0, errConnectTooLarge
+		}
+	}
+
+	if proto.PacketType(b[0]>>4) != proto.CONNECT {
+		return nil, 0, nil
+	}
+
+	remLen, _ := binary.Uvarint(b[1 : n+1])
+	if remLen > max {
+		return nil, 0, errConnectTooLarge
+	}
+	return b[:n+1], remLen, nil
+}
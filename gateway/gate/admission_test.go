@@ -0,0 +1,196 @@
+package gate
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+	"github.com/naoina/toml"
+)
+
+func Test_tokenBucket_take(t *testing.T) {
+	tb := &tokenBucket{}
+	now := time.Now()
+
+	// the burst is available at once
+	for i := 0; i < 3; i++ {
+		if !tb.take(1, 3, now) {
+			t.Fatalf("take() %d failed within the burst", i)
+		}
+	}
+	if tb.take(1, 3, now) {
+		t.Errorf("take() succeeded over the burst")
+	}
+
+	// one token per second
+	if !tb.take(1, 3, now.Add(time.Second)) {
+		t.Errorf("take() failed after refilling")
+	}
+	if tb.take(1, 3, now.Add(time.Second)) {
+		t.Errorf("take() succeeded with an empty bucket")
+	}
+
+	// no limit
+	if !(&tokenBucket{}).take(0, 0, now) {
+		t.Errorf("take() failed without limit")
+	}
+}
+
+func Test_tokenBucket_smallBurst(t *testing.T) {
+	now := time.Now()
+
+	// burst 0 holds one token, the rate still applies
+	tb := &tokenBucket{}
+	if !tb.take(2, 0, now) {
+		t.Fatalf("take() failed with a full bucket")
+	}
+	if tb.take(2, 0, now.Add(250*time.Millisecond)) {
+		t.Errorf("take() succeeded with half a token")
+	}
+	if !tb.take(2, 0, now.Add(500*time.Millisecond)) {
+		t.Errorf("take() failed after refilling")
+	}
+
+	// n over the burst passes only with a full bucket, and the debt is paid before the next one
+	tb = &tokenBucket{}
+	if !tb.takeN(1, 2, 5, now) {
+		t.Fatalf("takeN() over the burst failed with a full bucket")
+	}
+	if tb.takeN(1, 2, 1, now.Add(3*time.Second)) {
+		t.Errorf("takeN() succeeded before the debt is paid, tokens = %v", tb.tokens)
+	}
+	if !tb.takeN(1, 2, 1, now.Add(4*time.Second)) {
+		t.Errorf("takeN() failed after the debt is paid, tokens = %v", tb.tokens)
+	}
+}
+
+func Test_admission_acquire(t *testing.T) {
+	conf := &Config{}
+	err := toml.Unmarshal([]byte(`
+[limit]
+max_conns = 3
+max_conns_per_ip = 2
+`), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newListener(testGate(t, conf), &ListenerConf{Protocol: "tcp", Addr: ":1883"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &admission{perIP: make(map[string]int)}
+
+	steps := []struct {
+		ip   string
+		want string
+	}{
+		{"1.1.1.1", ""},
+		{"1.1.1.1", ""},
+		{"1.1.1.1", rejectIP},
+		{"2.2.2.2", ""},
+		{"3.3.3.3", rejectGlobal},
+	}
+	for i, s := range steps {
+		if got := a.acquire(l, s.ip); got != s.want {
+			t.Fatalf("acquire() step %d = %q, want %q", i, got, s.want)
+		}
+	}
+
+	a.release(l, "1.1.1.1")
+	if got := a.acquire(l, "1.1.1.1"); got != "" {
+		t.Errorf("acquire() after release = %q, want accepted", got)
+	}
+
+	l.conf.MaxConns = 1
+	a.release(l, "2.2.2.2")
+	if got := a.acquire(l, "3.3.3.3"); got != rejectListener {
+		t.Errorf("acquire() = %q, want %q", got, rejectListener)
+	}
+}
+
+func Test_rejectConn(t *testing.T) {
+	l, err := newListener(testGate(t, nil), &ListenerConf{Protocol: "tcp", Addr: ":1883"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go rejectConn(l, sc, rejectRate)
+
+	cp := proto.NewConnectPacket()
+	cp.SetVersion(4)
+	cp.SetClientId([]byte("c1"))
+	if err := service.WritePacket(cc, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	pt, _, _, err := service.ReadPacket(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack, ok := pt.(*proto.ConnackPacket)
+	if !ok || ack.ReturnCode() != proto.ErrServerUnavailable {
+		t.Errorf("reply = %v, want CONNACK server unavailable", pt)
+	}
+}
+
+func Test_rejectConn_large(t *testing.T) {
+	l, err := newListener(testGate(t, nil), &ListenerConf{Protocol: "tcp", Addr: ":1883"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	go rejectConn(l, sc, rejectDraining)
+
+	// a CONNECT declaring 256MB isn't read, the connection is closed without reply
+	if _, err := cc.Write([]byte{0x10, 0xff, 0xff, 0xff, 0x7f}); err != nil {
+		t.Fatal(err)
+	}
+	cc.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, _, err := service.ReadPacket(cc); err != io.EOF {
+		t.Errorf("ReadPacket() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func Test_readConnect(t *testing.T) {
+	cp := proto.NewConnectPacket()
+	cp.SetVersion(4)
+	cp.SetClientId([]byte("c1"))
+
+	tests := []struct {
+		name    string
+		write   func(c net.Conn)
+		wantErr error
+	}{
+		{"connect", func(c net.Conn) { service.WritePacket(c, cp) }, nil},
+		{"not connect", func(c net.Conn) { service.WritePacket(c, proto.NewPingreqPacket()) }, errNotConnect},
+		// the body isn't read, nothing is allocated for it
+		{"too large", func(c net.Conn) { c.Write([]byte{0x10, 0xff, 0xff, 0xff, 0x7f}) }, errConnectTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, cc := net.Pipe()
+			defer sc.Close()
+			defer cc.Close()
+			go tt.write(cc)
+
+			sc.SetReadDeadline(time.Now().Add(time.Second))
+			got, _, err := readConnect(sc)
+			if err != tt.wantErr {
+				t.Fatalf("readConnect() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(got.ClientId()) != "c1" {
+				t.Errorf("readConnect() client id = %q, want %q", got.ClientId(), "c1")
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package gate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/zap"
+)
+
+// Credential is what the client presents in CONNECT
+type Credential struct {
+	ClientID string
+	Username string
+	Password []byte
+	IP       string
+
+	// common name of the client certificate, empty without mutual tls
+	CertCN string
+	// the client presented a verified certificate, which may have no common name
+	CertVerified bool
+
+	// set by the authenticator accepting it when the accept ends, e.g. the exp of the jwt,
+	// it isn't cached after that
+	expires time.Time
+}
+
+// Authenticator checks the credential of a connecting client.
+// Authenticate returns nil if the client is accepted, ErrAuthIgnored if the authenticator can't
+// make a decision, a proto.ConnackCode if the client is rejected, or other errors when the
+// authenticator itself fails.
+type Authenticator interface {
+	Authenticate(cred *Credential) error
+}
+
+// ErrAuthIgnored passes the credential to the next authenticator in the chain
+var ErrAuthIgnored = errors.New("auth: ignored")
+
+type namedAuth struct {
+	name string
+	Authenticator
+}
+
+// authChain calls the authenticators in order, the first answer wins
+type authChain struct {
+	auths  []namedAuth
+	cache  *authCache
+	logger zap.Logger
+}
+
+func (g *Gate) getAuths() *authChain {
+	return g.auths.Load().(*authChain)
+}
+
+// newAuthChain builds the named authenticators with the settings in conf
+func (g *Gate) newAuthChain(conf *Config, names []string) (*authChain, error) {
+	ac := &authChain{logger: g.logger}
+	for _, name := range names {
+		a, err := newAuthenticator(conf, name)
+		if err != nil {
+			return nil, err
+		}
+		ac.auths = append(ac.auths, namedAuth{name, a})
+	}
+
+	if conf.Auth.CacheTTL > 0 {
+		ac.cache = newAuthCache(time.Duration(conf.Auth.CacheTTL)*time.Second, conf.Auth.CacheSize)
+	}
+
+	return ac, nil
+}
+
+func newAuthenticator(conf *Config, name string) (Authenticator, error) {
+	switch name {
+	case "cert":
+		return certAuth{}, nil
+	case "file":
+		return newFileAuth(conf.Auth.File)
+	case "jwt":
+		return newJwtAuth(conf.Auth.JwtSecret, conf.Auth.JwtPubKey, conf.Auth.JwtAudience)
+	case "http":
+		return newHttpAuth(conf.Auth.HttpUrl, time.Duration(conf.Auth.HttpTimeout)*time.Second), nil
+	case "center":
+		return newCenterAuth(conf.Auth.CenterAddr, time.Duration(conf.Auth.CenterTimeout)*time.Second)
+	}
+
+	return nil, errors.New("invalid authenticator: " + name)
+}
+
+// Authenticate returns the CONNACK code for the credential
+func (ac *authChain) Authenticate(cred *Credential) proto.ConnackCode {
+	// nothing configured, everyone is welcome
+	if len(ac.auths) == 0 {
+		return proto.ConnectionAccepted
+	}
+
+	var key string
+	if ac.cache != nil {
+		key = cred.cacheKey()
+		if code, ok := ac.cache.get(key); ok {
+			return code
+		}
+	}
+
+	failed := false
+	for _, a := range ac.auths {
+		err := a.Authenticate(cred)
+		switch e := err.(type) {
+		case nil:
+			ac.cache.set(key, proto.ConnectionAccepted, cred.expires)
+			return proto.ConnectionAccepted
+		case proto.ConnackCode:
+			ac.cache.set(key, e, time.Time{})
+			return e
+		}
+
+		if err != ErrAuthIgnored {
+			// the backend is broken, let the next one try
+			failed = true
+			ac.logger.Warn("authenticate error", zap.String("auth", a.name), zap.Error(err), zap.String("user", cred.Username))
+		}
+	}
+
+	// nobody knows the client, the result isn't cached if any backend failed
+	if failed {
+		return proto.ErrServerUnavailable
+	}
+
+	ac.cache.set(key, proto.ErrNotAuthorized, time.Time{})
+	return proto.ErrNotAuthorized
+}
+
+// the password is hashed, so no plaintext credential stays in memory.
+// The ip is in it, the answers of the http backend may depend on it.
+func (cred *Credential) cacheKey() string {
+	h := sha256.New()
+	h.Write([]byte(cred.ClientID))
+	h.Write([]byte{0})
+	h.Write([]byte(cred.Username))
+	h.Write([]byte{0})
+	h.Write(cred.Password)
+	h.Write([]byte{0})
+	h.Write([]byte(cred.CertCN))
+	h.Write([]byte{0})
+	if cred.CertVerified {
+		h.Write([]byte{1})
+	}
+	h.Write([]byte{0})
+	h.Write([]byte(cred.IP))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// redact hides the credential in logs
+func redact(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return "******"
+}
+
+type authCacheItem struct {
+	code   proto.ConnackCode
+	expire time.Time
+}
+
+// authCache caches the authentication results for a while
+type authCache struct {
+	sync.Mutex
+	ttl   time.Duration
+	size  int
+	items map[string]authCacheItem
+}
+
+func newAuthCache(ttl time.Duration, size int) *authCache {
+	if size <= 0 {
+		size = 10000
+	}
+
+	return &authCache{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[string]authCacheItem),
+	}
+}
+
+func (c *authCache) get(key string) (proto.ConnackCode, bool) {
+	if c == nil {
+		return 0, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	item, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+
+	if time.Now().After(item.expire) {
+		delete(c.items, key)
+		return 0, false
+	}
+
+	return item.code, true
+}
+
+// set caches the code for the ttl, or until the expires if it's earlier
+func (c *authCache) set(key string, code proto.ConnackCode, expires time.Time) {
+	if c == nil {
+		return
+	}
+
+	now := time.Now()
+	expire := now.Add(c.ttl)
+	if !expires.IsZero() && expires.Before(expire) {
+		expire = expires
+	}
+	if !expire.After(now) {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if len(c.items) >= c.size {
+		for k, item := range c.items {
+			if now.After(item.expire) {
+				delete(c.items, k)
+			}
+		}
+
+		// still full, start over
+		if len(c.items) >= c.size {
+			c.items = make(map[string]authCacheItem)
+		}
+	}
+
+	c.items[key] = authCacheItem{code, expire}
+}
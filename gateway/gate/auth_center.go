@@ -0,0 +1,66 @@
+package gate
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	rpc "github.com/aiyun/gomqtt/proto"
+)
+
+// centerAuth asks the center service through grpc
+type centerAuth struct {
+	conn    *grpc.ClientConn
+	client  rpc.CenterClient
+	timeout time.Duration
+}
+
+func newCenterAuth(addr string, timeout time.Duration) (*centerAuth, error) {
+	// the dial is non-blocking, the connection will be made on the first call
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+
+	return &centerAuth{
+		conn:    conn,
+		client:  rpc.NewCenterClient(conn),
+		timeout: timeout,
+	}, nil
+}
+
+func (ca *centerAuth) Authenticate(cred *Credential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ca.timeout)
+	defer cancel()
+
+	reply, err := ca.client.Auth(ctx, &rpc.AuthMsg{
+		Cid: cred.ClientID,
+		Un:  cred.Username,
+		Pw:  cred.Password,
+		Ip:  cred.IP,
+	})
+	if err != nil {
+		return err
+	}
+
+	if reply.Ignore {
+		return ErrAuthIgnored
+	}
+
+	code := proto.ConnackCode(reply.Code)
+	if code == proto.ConnectionAccepted {
+		return nil
+	}
+
+	if !code.Valid() {
+		return proto.ErrNotAuthorized
+	}
+
+	return code
+}
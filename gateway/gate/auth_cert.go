@@ -0,0 +1,12 @@
+package gate
+
+// certAuth accepts the clients presenting a verified certificate, with or without a common name
+type certAuth struct{}
+
+func (certAuth) Authenticate(cred *Credential) error {
+	if !cred.CertVerified {
+		return ErrAuthIgnored
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package gate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fileAuth checks the password against the bcrypt hashes in a static file,
+// each line of the file is "username:hash", lines starting with '#' are comments.
+type fileAuth struct {
+	users map[string][]byte
+}
+
+var (
+	dummyOnce sync.Once
+	dummyHash []byte
+)
+
+// dummy is compared with the password of an unknown user, so the time taken doesn't tell which users exist
+func dummy() []byte {
+	dummyOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gomqtt"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+func newFileAuth(path string) (*fileAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fa := &fileAuth{
+		users: make(map[string][]byte),
+	}
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("auth file %s line %d: invalid format", path, n)
+		}
+		fa.users[line[:i]] = []byte(line[i+1:])
+	}
+
+	return fa, sc.Err()
+}
+
+func (fa *fileAuth) Authenticate(cred *Credential) error {
+	hash, ok := fa.users[cred.Username]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummy(), cred.Password)
+		return ErrAuthIgnored
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, cred.Password) != nil {
+		return proto.ErrBadUsernameOrPassword
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package gate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+// httpAuth posts the credential to a webhook, the status code tells the result:
+// 200 accepted, 401 bad username or password, 403 not authorized, 404 ignored
+type httpAuth struct {
+	url    string
+	client *http.Client
+}
+
+type httpAuthReq struct {
+	ClientID string `json:"clientid"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	IP       string `json:"ip"`
+}
+
+func newHttpAuth(url string, timeout time.Duration) *httpAuth {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	return &httpAuth{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (ha *httpAuth) Authenticate(cred *Credential) error {
+	body, err := json.Marshal(httpAuthReq{
+		ClientID: cred.ClientID,
+		Username: cred.Username,
+		Password: string(cred.Password),
+		IP:       cred.IP,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := ha.client.Post(ha.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return proto.ErrBadUsernameOrPassword
+	case http.StatusForbidden:
+		return proto.ErrNotAuthorized
+	case http.StatusNotFound:
+		return ErrAuthIgnored
+	}
+
+	return fmt.Errorf("http auth: unexpected status %d", resp.StatusCode)
+}
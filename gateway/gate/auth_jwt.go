@@ -0,0 +1,124 @@
+package gate
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// jwtAuth treats the password as a jwt token signed by HS or RS keys.
+// The token must carry exp, aud is checked when configured, and sub must be the username if it's set.
+type jwtAuth struct {
+	hsKey    []byte
+	rsKey    *rsa.PublicKey
+	audience string
+}
+
+func newJwtAuth(secret, pubKey, audience string) (*jwtAuth, error) {
+	ja := &jwtAuth{audience: audience}
+
+	if secret != "" {
+		ja.hsKey = []byte(secret)
+	}
+
+	if pubKey != "" {
+		pem, err := ioutil.ReadFile(pubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		ja.rsKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ja.hsKey == nil && ja.rsKey == nil {
+		return nil, errors.New("jwt auth: neither secret nor public key is configured")
+	}
+
+	return ja, nil
+}
+
+func (ja *jwtAuth) Authenticate(cred *Credential) error {
+	// not a token at all
+	if bytes.Count(cred.Password, []byte{'.'}) != 2 {
+		return ErrAuthIgnored
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(string(cred.Password), claims, ja.key)
+	if err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+			return proto.ErrNotAuthorized
+		}
+		return proto.ErrBadUsernameOrPassword
+	}
+
+	exp, ok := claimTime(claims["exp"])
+	if !ok {
+		return proto.ErrNotAuthorized
+	}
+
+	if ja.audience != "" && !hasAudience(claims["aud"], ja.audience) {
+		return proto.ErrNotAuthorized
+	}
+
+	if sub, ok := claims["sub"].(string); ok && sub != cred.Username {
+		return proto.ErrNotAuthorized
+	}
+
+	// the cached accept ends with the token
+	cred.expires = exp
+	return nil
+}
+
+// claimTime parses a NumericDate claim
+func claimTime(v interface{}) (time.Time, bool) {
+	switch n := v.(type) {
+	case float64:
+		return time.Unix(int64(n), 0), true
+	case json.Number:
+		i, err := n.Int64()
+		return time.Unix(i, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+func (ja *jwtAuth) key(t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if ja.hsKey != nil {
+			return ja.hsKey, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if ja.rsKey != nil {
+			return ja.rsKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+}
+
+// aud can be a string or an array of strings
+func hasAudience(aud interface{}, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
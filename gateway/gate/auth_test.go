@@ -0,0 +1,226 @@
+package gate
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	jwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeAuth returns err and counts the calls, an accept ends at expires if it's set
+type fakeAuth struct {
+	err     error
+	calls   int
+	expires time.Time
+}
+
+func (fa *fakeAuth) Authenticate(cred *Credential) error {
+	fa.calls++
+	cred.expires = fa.expires
+	return fa.err
+}
+
+func Test_authChain_Authenticate(t *testing.T) {
+	tests := []struct {
+		name string
+		errs []error
+		want proto.ConnackCode
+	}{
+		{"empty chain", nil, proto.ConnectionAccepted},
+		{"ignored then accepted", []error{ErrAuthIgnored, nil}, proto.ConnectionAccepted},
+		{"rejected first", []error{proto.ErrBadUsernameOrPassword, nil}, proto.ErrBadUsernameOrPassword},
+		{"all ignored", []error{ErrAuthIgnored, ErrAuthIgnored}, proto.ErrNotAuthorized},
+		{"backend failed", []error{errors.New("down"), ErrAuthIgnored}, proto.ErrServerUnavailable},
+		{"failed then accepted", []error{errors.New("down"), nil}, proto.ConnectionAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac := &authChain{logger: testLogger}
+			for _, err := range tt.errs {
+				ac.auths = append(ac.auths, namedAuth{"fake", &fakeAuth{err: err}})
+			}
+
+			if got := ac.Authenticate(&Credential{Username: "u"}); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authChain_cache(t *testing.T) {
+	fa := &fakeAuth{}
+	ac := &authChain{
+		logger: testLogger,
+		auths:  []namedAuth{{"fake", fa}},
+		cache:  newAuthCache(time.Minute, 10),
+	}
+
+	cred := &Credential{ClientID: "c", Username: "u", Password: []byte("p")}
+	ac.Authenticate(cred)
+	ac.Authenticate(cred)
+	if fa.calls != 1 {
+		t.Errorf("authenticator called %d times, want 1", fa.calls)
+	}
+
+	// another password must not hit the cache
+	ac.Authenticate(&Credential{ClientID: "c", Username: "u", Password: []byte("q")})
+	if fa.calls != 2 {
+		t.Errorf("authenticator called %d times, want 2", fa.calls)
+	}
+
+	// nor another ip
+	ac.Authenticate(&Credential{ClientID: "c", Username: "u", Password: []byte("p"), IP: "1.1.1.1"})
+	if fa.calls != 3 {
+		t.Errorf("authenticator called %d times, want 3", fa.calls)
+	}
+
+	// the accept is cached until it expires, not for the whole ttl
+	fa.expires = time.Now().Add(50 * time.Millisecond)
+	cred = &Credential{ClientID: "c", Username: "u", Password: []byte("token")}
+	ac.Authenticate(cred)
+	ac.Authenticate(cred)
+	time.Sleep(100 * time.Millisecond)
+	ac.Authenticate(cred)
+	if fa.calls != 5 {
+		t.Errorf("authenticator called %d times, want 5", fa.calls)
+	}
+}
+
+func Test_fileAuth_Authenticate(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	f, err := ioutil.TempFile("", "passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# users\nalice:" + string(hash) + "\n")
+	f.Close()
+
+	fa, err := newFileAuth(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cred *Credential
+		want error
+	}{
+		{"accepted", &Credential{Username: "alice", Password: []byte("secret")}, nil},
+		{"bad password", &Credential{Username: "alice", Password: []byte("guess")}, proto.ErrBadUsernameOrPassword},
+		{"unknown user", &Credential{Username: "bob", Password: []byte("secret")}, ErrAuthIgnored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fa.Authenticate(tt.cred); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_certAuth_Authenticate(t *testing.T) {
+	tests := []struct {
+		name string
+		cred *Credential
+		want error
+	}{
+		{"cn", &Credential{CertCN: "device1", CertVerified: true}, nil},
+		// the san only certificates are mapped by tls_identity = "san"
+		{"san only", &Credential{Username: "device1.example.com", CertVerified: true}, nil},
+		{"no certificate", &Credential{Username: "device1"}, ErrAuthIgnored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (certAuth{}).Authenticate(tt.cred); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jwtAuth_Authenticate(t *testing.T) {
+	ja, err := newJwtAuth("key", "", "gomqtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(claims jwt.MapClaims, key string) []byte {
+		s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+		return []byte(s)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name string
+		pw   []byte
+		want error
+	}{
+		{"accepted", sign(jwt.MapClaims{"exp": exp, "aud": "gomqtt", "sub": "alice"}, "key"), nil},
+		{"audience list", sign(jwt.MapClaims{"exp": exp, "aud": []string{"other", "gomqtt"}}, "key"), nil},
+		{"not a token", []byte("secret"), ErrAuthIgnored},
+		{"bad signature", sign(jwt.MapClaims{"exp": exp, "aud": "gomqtt"}, "guess"), proto.ErrBadUsernameOrPassword},
+		{"expired", sign(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix(), "aud": "gomqtt"}, "key"), proto.ErrNotAuthorized},
+		{"no exp", sign(jwt.MapClaims{"aud": "gomqtt"}, "key"), proto.ErrNotAuthorized},
+		{"wrong audience", sign(jwt.MapClaims{"exp": exp, "aud": "other"}, "key"), proto.ErrNotAuthorized},
+		{"wrong subject", sign(jwt.MapClaims{"exp": exp, "aud": "gomqtt", "sub": "bob"}, "key"), proto.ErrNotAuthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := &Credential{Username: "alice", Password: tt.pw}
+			if got := ja.Authenticate(cred); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+			// the cached accept ends with the token
+			if tt.want == nil && cred.expires.Unix() != exp {
+				t.Errorf("expires = %v, want the exp %v", cred.expires, time.Unix(exp, 0))
+			}
+		})
+	}
+}
+
+func Test_httpAuth_Authenticate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("status") {
+		case "ok":
+			w.WriteHeader(http.StatusOK)
+		case "unauthorized":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "unknown":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		status  string
+		want    error
+		wantErr bool
+	}{
+		{"ok", nil, false},
+		{"unauthorized", proto.ErrBadUsernameOrPassword, true},
+		{"forbidden", proto.ErrNotAuthorized, true},
+		{"unknown", ErrAuthIgnored, true},
+		{"broken", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			ha := newHttpAuth(srv.URL+"?status="+tt.status, time.Second)
+			err := ha.Authenticate(&Credential{Username: "alice"})
+			if (err != nil) != tt.wantErr || (tt.want != nil && err != tt.want) {
+				t.Errorf("Authenticate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package gate
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// breaker states
+const (
+	breakerClosed = iota
+	breakerOpen
+	// one call is let through to probe the stream
+	breakerHalfOpen
+)
+
+// breaker fails the calls fast after a stream keeps failing, so the connections don't pile up on the deadlines
+type breaker struct {
+	sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// consecutive failures opening the breaker, and how long it stays open
+	maxFailures int
+	timeout     time.Duration
+}
+
+func newBreaker(maxFailures int, timeout time.Duration) *breaker {
+	return &breaker{maxFailures: maxFailures, timeout: timeout}
+}
+
+// setLimits is called on reload, the state is kept
+func (b *breaker) setLimits(maxFailures int, timeout time.Duration) {
+	b.Lock()
+	b.maxFailures = maxFailures
+	b.timeout = timeout
+	b.Unlock()
+}
+
+// allow returns errBreakerOpen if the call should fail fast
+func (b *breaker) allow(now time.Time) error {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.timeout {
+			return errBreakerOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errBreakerOpen
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+func (b *breaker) success() {
+	b.Lock()
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+	b.Unlock()
+}
+
+func (b *breaker) failure(now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || b.failures >= b.maxFailures {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+// trip opens the breaker at once, used when the health check fails
+func (b *breaker) trip(now time.Time) {
+	b.Lock()
+	if b.state != breakerOpen {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+	b.probing = false
+	b.Unlock()
+}
+
+func (b *breaker) isOpen() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.state == breakerOpen
+}
@@ -0,0 +1,50 @@
+package gate
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_breaker(t *testing.T) {
+	b := newBreaker(2, time.Second)
+	now := time.Now()
+
+	b.failure(now)
+	if err := b.allow(now); err != nil {
+		t.Fatalf("allow() after 1 failure = %v, want nil", err)
+	}
+
+	b.failure(now)
+	if err := b.allow(now); err != errBreakerOpen {
+		t.Fatalf("allow() after 2 failures = %v, want %v", err, errBreakerOpen)
+	}
+
+	// half open, only one probe
+	later := now.Add(2 * time.Second)
+	if err := b.allow(later); err != nil {
+		t.Fatalf("allow() after the timeout = %v, want nil", err)
+	}
+	if err := b.allow(later); err != errBreakerOpen {
+		t.Errorf("second probe allow() = %v, want %v", err, errBreakerOpen)
+	}
+
+	// the probe failed
+	b.failure(later)
+	if err := b.allow(later); err != errBreakerOpen {
+		t.Errorf("allow() after a failed probe = %v, want %v", err, errBreakerOpen)
+	}
+
+	// the probe succeeded
+	if err := b.allow(later.Add(2 * time.Second)); err != nil {
+		t.Fatalf("allow() = %v, want nil", err)
+	}
+	b.success()
+	if err := b.allow(later.Add(2 * time.Second)); err != nil || b.isOpen() {
+		t.Errorf("allow() after success = %v, want nil", err)
+	}
+
+	b.trip(now)
+	if !b.isOpen() {
+		t.Errorf("trip() didn't open the breaker")
+	}
+}
@@ -0,0 +1,167 @@
+package gate
+
+import (
+	"path"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/zap"
+)
+
+// actions when a client breaks the limits
+const (
+	// slow down reading from the client, only works for the rate limits,
+	// it's the same as drop for the others
+	limitThrottle = "throttle"
+	// drop the message or reject the subscription
+	limitDrop = "drop"
+	// close the connection
+	limitDisconnect = "disconnect"
+)
+
+// the longest PUBLISH header: topic length, topic and packet id
+const maxPublishHeader = 2 + 65535 + 2
+
+// clientLimiter enforces the limits of a LimitClass on one connection
+type clientLimiter struct {
+	class *LimitClass
+
+	msgs  tokenBucket
+	bytes tokenBucket
+}
+
+// limitClass returns the first class matching the username, nil if there is none
+func limitClass(classes []*LimitClass, username string) *LimitClass {
+	for _, lc := range classes {
+		if len(lc.Users) == 0 {
+			return lc
+		}
+
+		for _, u := range lc.Users {
+			if ok, _ := path.Match(u, username); ok {
+				return lc
+			}
+		}
+	}
+
+	return nil
+}
+
+// newClientLimiter returns nil if no class matches, and the nil limiter allows everything
+func newClientLimiter(classes []*LimitClass, username string) *clientLimiter {
+	lc := limitClass(classes, username)
+	if lc == nil {
+		return nil
+	}
+
+	return &clientLimiter{class: lc}
+}
+
+// maxPayload is the payload limit of the class, def is used by the clients without one
+func (cl *clientLimiter) maxPayload(def int) int {
+	if cl == nil || cl.class.MaxPayload <= 0 {
+		return def
+	}
+
+	return cl.class.MaxPayload
+}
+
+// maxRead is the limit passed to service.ReadPacketLimit, the exact payload size is checked in checkPublish
+func (cl *clientLimiter) maxRead(def int) int {
+	return cl.maxPayload(def) + maxPublishHeader
+}
+
+// payloadAction is drop for the clients without a class
+func (cl *clientLimiter) payloadAction() string {
+	if cl == nil {
+		return limitDrop
+	}
+
+	return dropAction(cl.class.PayloadAction)
+}
+
+// checkPublish returns the action and the reason if the message breaks the limits, and
+// sleeps for a while if the action is throttle. def is the payload limit of the clients without one
+func (cl *clientLimiter) checkPublish(p *proto.PublishPacket, def int) (string, string) {
+	if len(p.Payload()) > cl.maxPayload(def) {
+		return cl.payloadAction(), "payload too large"
+	}
+
+	if cl == nil {
+		return "", ""
+	}
+
+	c := cl.class
+
+	now := time.Now()
+	if c.MsgRate > 0 {
+		if act, ok := cl.take(&cl.msgs, c.MsgRate, c.MsgRate, 1, c.MsgRateAction, now); !ok {
+			return act, "message rate exceeded"
+		}
+	}
+
+	if c.ByteRate > 0 {
+		if act, ok := cl.take(&cl.bytes, c.ByteRate, c.ByteRate, float64(len(p.Payload())), c.ByteRateAction, now); !ok {
+			return act, "byte rate exceeded"
+		}
+	}
+
+	return "", ""
+}
+
+// take throttles by waiting for the tokens, or reports the action when there are not enough tokens
+func (cl *clientLimiter) take(tb *tokenBucket, rate, burst, n float64, act string, now time.Time) (string, bool) {
+	act = action(act)
+	if act != limitThrottle {
+		return act, tb.takeN(rate, burst, n, now)
+	}
+
+	if wait := tb.reserve(rate, burst, n, now); wait > 0 {
+		stats.limitThrottled.Inc()
+		time.Sleep(wait)
+	}
+
+	return "", true
+}
+
+// checkSubscribe returns the action if the client can't have more subscriptions
+func (cl *clientLimiter) checkSubscribe(subs int) (string, string) {
+	if cl == nil || cl.class.MaxSubs <= 0 || subs < cl.class.MaxSubs {
+		return "", ""
+	}
+
+	return dropAction(cl.class.SubsAction), "too many subscriptions"
+}
+
+// action is drop by default
+func action(act string) string {
+	switch act {
+	case limitThrottle, limitDisconnect:
+		return act
+	}
+
+	return limitDrop
+}
+
+// dropAction is for the limits can't be throttled
+func dropAction(act string) string {
+	if act == limitDisconnect {
+		return act
+	}
+
+	return limitDrop
+}
+
+// onLimit logs and counts the violation, true is returned if the connection should be closed
+func onLimit(ci *connInfo, act, reason string) bool {
+	if act == limitDisconnect {
+		stats.limitDisconnected.Inc()
+		ci.g.logger.Info("client limit exceeded, disconnect", zap.Int("cid", ci.id), zap.String("user", ci.cred.Username), zap.String("ip", ci.ip), zap.String("reason", reason))
+		ci.closing(reason)
+		return true
+	}
+
+	stats.limitDropped.Inc()
+	ci.g.logger.Debug("client limit exceeded, drop", zap.Int("cid", ci.id), zap.String("reason", reason))
+	return false
+}
@@ -0,0 +1,140 @@
+package gate
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+)
+
+func Test_limitClass(t *testing.T) {
+	sensors := &LimitClass{Name: "sensors", Users: []string{"sensor-*"}}
+	def := &LimitClass{Name: "default"}
+	classes := []*LimitClass{sensors, def}
+
+	if got := limitClass(classes, "sensor-1"); got != sensors {
+		t.Errorf("limitClass(sensor-1) = %v, want sensors", got)
+	}
+	if got := limitClass(classes, "admin"); got != def {
+		t.Errorf("limitClass(admin) = %v, want default", got)
+	}
+
+	if got := newClientLimiter([]*LimitClass{sensors}, "admin"); got != nil {
+		t.Errorf("newClientLimiter(admin) = %v, want nil", got)
+	}
+}
+
+func newTestPublish(payload []byte) *proto.PublishPacket {
+	p := proto.NewPublishPacket()
+	p.SetTopic([]byte("a/b"))
+	p.SetPayload(payload)
+	return p
+}
+
+func Test_clientLimiter_checkPublish(t *testing.T) {
+	var nilLimiter *clientLimiter
+	if act, _ := nilLimiter.checkPublish(newTestPublish([]byte("1234")), 4); act != "" {
+		t.Errorf("nil limiter checkPublish() = %q, want allowed", act)
+	}
+	// the clients without a class still have the default payload limit
+	if act, _ := nilLimiter.checkPublish(newTestPublish([]byte("12345")), 4); act != limitDrop {
+		t.Errorf("nil limiter checkPublish() large payload = %q, want %q", act, limitDrop)
+	}
+
+	cl := &clientLimiter{class: &LimitClass{MaxPayload: 4, PayloadAction: limitDisconnect, MsgRate: 2}}
+	if act, _ := cl.checkPublish(newTestPublish([]byte("12345")), 1<<20); act != limitDisconnect {
+		t.Errorf("checkPublish() large payload = %q, want %q", act, limitDisconnect)
+	}
+
+	// the burst is 2 messages
+	for i := 0; i < 2; i++ {
+		if act, _ := cl.checkPublish(newTestPublish([]byte("1")), 1<<20); act != "" {
+			t.Fatalf("checkPublish() %d = %q, want allowed", i, act)
+		}
+	}
+	if act, _ := cl.checkPublish(newTestPublish([]byte("1")), 1<<20); act != limitDrop {
+		t.Errorf("checkPublish() over rate = %q, want %q", act, limitDrop)
+	}
+
+	// throttling waits instead of dropping
+	cl = &clientLimiter{class: &LimitClass{ByteRate: 100, ByteRateAction: limitThrottle}}
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if act, _ := cl.checkPublish(newTestPublish(make([]byte, 60)), 1<<20); act != "" {
+			t.Fatalf("checkPublish() throttled = %q, want allowed", act)
+		}
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf("checkPublish() didn't throttle")
+	}
+}
+
+func Test_clientLimiter_checkSubscribe(t *testing.T) {
+	cl := &clientLimiter{class: &LimitClass{MaxSubs: 2, SubsAction: limitThrottle}}
+	if act, _ := cl.checkSubscribe(1); act != "" {
+		t.Errorf("checkSubscribe(1) = %q, want allowed", act)
+	}
+	// subscriptions can't be throttled
+	if act, _ := cl.checkSubscribe(2); act != limitDrop {
+		t.Errorf("checkSubscribe(2) = %q, want %q", act, limitDrop)
+	}
+}
+
+func Test_ReadPacketLimit(t *testing.T) {
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	go func() {
+		large := newTestPublish(bytes.Repeat([]byte("x"), 1000))
+		large.SetQoS(1)
+		large.SetPacketID(7)
+		service.WritePacket(cc, large)
+		service.WritePacket(cc, newTestPublish([]byte("small")))
+	}()
+
+	// the header of the large one is read, so it can be acked
+	pt, _, _, err := service.ReadPacketLimit(sc, 100)
+	if err != service.ErrPacketTooLarge {
+		t.Fatalf("ReadPacketLimit() error = %v, want %v", err, service.ErrPacketTooLarge)
+	}
+	if p, ok := pt.(*proto.PublishPacket); !ok || p.QoS() != 1 || p.PacketID() != 7 || string(p.Topic()) != "a/b" || len(p.Payload()) != 0 {
+		t.Errorf("ReadPacketLimit() = %v, want the header of the large publish", pt)
+	}
+
+	// the large payload is skipped, the next packet is still readable
+	pt, _, _, err = service.ReadPacketLimit(sc, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := pt.(*proto.PublishPacket); !ok || string(p.Payload()) != "small" {
+		t.Errorf("ReadPacketLimit() = %v, want the small publish", pt)
+	}
+}
+
+func Test_recvPacket_payloadTooLarge(t *testing.T) {
+	g := testGate(t, nil)
+	ci, cc := testConn(g, "largec1", "alice", "1.1.1.1")
+	defer cc.Close()
+	ci.stopped = make(chan struct{})
+	ci.limits = &clientLimiter{class: &LimitClass{MaxPayload: 10, PayloadAction: limitDrop}}
+	go recvPacket(ci)
+
+	p := newTestPublish(bytes.Repeat([]byte("x"), 1000))
+	p.SetQoS(1)
+	p.SetPacketID(9)
+	go service.WritePacket(cc, p)
+
+	// the dropped qos 1 message is still acked, or the client resends it forever
+	cc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	pt, _, _, err := service.ReadPacket(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack, ok := pt.(*proto.PubackPacket); !ok || ack.PacketID() != 9 {
+		t.Errorf("got %v, want the PUBACK of 9", pt)
+	}
+}
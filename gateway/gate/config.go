@@ -2,8 +2,6 @@ package gate
 
 import (
 	"context"
-	"io/ioutil"
-	"log"
 	"time"
 
 	"stathat.com/c/consistent"
@@ -11,10 +9,9 @@ import (
 	"fmt"
 
 	"os"
+	"sync"
 
-	"github.com/corego/tools"
-	"github.com/coreos/etcd/clientv3"
-	"github.com/naoina/toml"
+	"github.com/aiyun/gomqtt/registry"
 	"github.com/uber-go/zap"
 )
 
@@ -26,155 +23,430 @@ type Config struct {
 		LogPath  string
 	}
 
-	Provider struct {
-		Invoked   []string
-		TcpAddr   string
-		EnableTls bool
-		TlsCert   string
-		TlsKey    string
+	// each [[provider]] is a listener
+	Provider []*ListenerConf
+
+	Auth struct {
+		// authenticators are called in order, the first one giving an answer wins
+		Chain     []string
+		CacheTTL  int
+		CacheSize int
+
+		// static file, each line is "username:bcrypt hash"
+		File string
+
+		// jwt password
+		JwtSecret   string
+		JwtPubKey   string
+		JwtAudience string
+
+		// http webhook
+		HttpUrl     string
+		HttpTimeout int
+
+		// center service
+		CenterAddr    string
+		CenterTimeout int
 	}
 
+	Acl struct {
+		// rules file, see configs/acl.toml
+		File string
+		// allow or deny, used when no rule matches
+		Default string
+		// drop or disconnect, what to do with a denied publish
+		PubDeny string
+	}
+
+	// the registry of the streams and the rooms
 	Etcd struct {
-		Addrs   []string
+		Addrs []string
+		// a json file shared by the services on one node, used when Addrs is empty
+		File    string
 		Streams string
 		Rooms   string
+		// seconds the room stays registered after the gateway dies, 30 by default
+		TTL int
 	}
 
 	Mqtt struct {
 		QosMax       byte
 		MaxKeepalive uint16
+		// seconds between publishing the $SYS statistics, 0 disables it
+		SysInterval int
+		// seconds a write to a client can take, the connection is closed if it's exceeded, 0 means 10
+		WriteTimeout int
+		// payload size of the messages of the clients without max_payload in their limit class, 0 means 1MB
+		MaxPayload int
 	}
 
+	// classes of the client limits, the first one matching the username is used
+	ClientLimit []*LimitClass
+
+	// admission control of the new connections, 0 means no limit
+	Limit struct {
+		// concurrent connections of the gateway
+		MaxConns int
+		// concurrent connections from one ip
+		MaxConnsPerIp int
+		// new connections per second, and how many can be accepted at once
+		AcceptRate  float64
+		AcceptBurst int
+	}
+
+	// graceful shutdown, see drain.go
+	Drain struct {
+		// seconds to close all the connections, each one is closed at a random moment in the window
+		Window int
+		// seconds to wait for the in-flight messages after the connections are closed
+		FlushTimeout int
+	}
+
+	// gives the clients a room, disabled when Addr is empty
 	Dispatch struct {
 		Addr string
 	}
 
-	StreamAddrs map[string]string
-	RoomAddrs   map[string]string
-}
+	// the grpc clients of the streams, 0 means the default
+	Stream struct {
+		// milliseconds of each call
+		CallTimeout int
+		// retries of the idempotent calls, -1 disables retrying
+		Retries int
+		// milliseconds before the first retry, doubled on each one
+		Backoff int
+		// consecutive failures opening the circuit breaker of a stream, and seconds it stays open
+		BreakerFailures int
+		BreakerTimeout  int
+		// seconds between the grpc health checks
+		HealthInterval int
+	}
 
-var Conf = &Config{}
+	// the grpc service the streams deliver messages through
+	Deliver struct {
+		Addr string
+		// outbound queue of each connection
+		QueueSize int
+	}
 
-var Consist *consistent.Consistent
+	// server side plugins, see hook.go
+	Hook struct {
+		// hooks are called in order
+		Chain []string
+		// log_payload: the messages of the topic filters are logged, all topics if empty,
+		// and the bytes logged of each payload
+		LogTopics   []string
+		LogMaxBytes int
+		// topic_rewrite: the first matching rule is used
+		Rewrite []*RewriteRule
+	}
 
-func loadConfig(staticConf bool) {
-	var contents []byte
-	var err error
+	// http endpoints the events are posted to
+	Webhook []*WebhookConf
 
-	if staticConf {
-		//静态配置
-		contents, err = ioutil.ReadFile("configs/gateway.toml")
-	} else {
-		contents, err = ioutil.ReadFile("/etc/gomqtt/gateway.toml")
-	}
+	// rules applied to the published messages in order
+	Rule []*RuleConf
 
-	if err != nil {
-		log.Fatal("load config error", zap.Error(err))
+	Admin struct {
+		// bearer token of the connection api, the api is disabled when it's empty
+		Token string
+		// the dir of the trace files, the traces can only be streamed when it's empty
+		TraceDir string
+		// the admin api, :8907 by default
+		Addr string
 	}
+}
 
-	tbl, err := toml.Parse(contents)
-	if err != nil {
-		log.Fatal("parse config error", zap.Error(err))
+// ListenerConf is the settings of one listener
+type ListenerConf struct {
+	// unique name used in the logs and stats, protocol@addr by default
+	Name string
+	// tcp, tls, ws, wss or unix
+	Protocol string
+	// host:port, or the socket path of unix
+	Addr string
+	// 0 means no limit
+	MaxConns int
+	// replaces the chain in [auth] when set
+	AuthChain []string
+	// the connections start with a PROXY protocol v1 or v2 header
+	ProxyProtocol bool
+	// CIDRs of the balancers allowed to send the header, required by proxy_protocol except on unix sockets
+	ProxyTrusted []string
+
+	// tls and wss
+	TlsCert string
+	TlsKey  string
+	// mutual tls: none, optional or required
+	TlsClientAuth string
+	// ca bundles verifying the client certificates
+	TlsCas []string
+	// crl files of the cas, read again after their next update, the client certs are rejected while they are outdated
+	TlsCrls []string
+	// der ocsp response of the server certificate, stapled in the handshake
+	TlsOcsp string
+	// cn or san, the identity of the client certificate is used as the username
+	TlsIdentity string
+	// more certificates selected by SNI, TlsCert and TlsKey is the default one
+	TlsSni []struct {
+		Cert string
+		Key  string
 	}
+	// seconds between the checks of the certificate files, 0 means only reloading by /reload
+	TlsWatch int
+
+	// ws and wss
+	WsPath    string
+	WsOrigins []string
+	// take the client ip from X-Forwarded-For, only enable it behind a trusted proxy
+	WsTrustXff bool
+	// trusted proxies in front of the gateway, the ip appended by the outermost one is used, 0 means 1
+	WsXffHops int
+}
+
+// LimitClass is the limits of a class of clients, 0 means no limit.
+// The actions are throttle, drop(default) or disconnect.
+type LimitClass struct {
+	Name string
+	// glob patterns of the usernames, empty matches everyone
+	Users []string
+
+	// PUBLISH packets per second
+	MsgRate       float64
+	MsgRateAction string
+	// payload bytes per second
+	ByteRate       float64
+	ByteRateAction string
+	// payload size of one message, 0 means [mqtt] max_payload
+	MaxPayload    int
+	PayloadAction string
+	// subscriptions of one client
+	MaxSubs    int
+	SubsAction string
+}
 
-	toml.UnmarshalTable(tbl, Conf)
+// WebhookConf is an endpoint receiving the events as json arrays, 0 means the default
+type WebhookConf struct {
+	Name string
+	Url  string
+	// connected, disconnected, subscribed or published, empty means all
+	Events []string
+	// topic filters of the subscribed and published events, empty matches all
+	Topics []string
+	// the body is signed by hmac-sha256 in X-Gomqtt-Signature when it's set
+	Secret string
+
+	// events in one POST, and milliseconds a batch waits to be filled
+	BatchSize int
+	BatchWait int
+	// events waiting to be posted, the new ones are dropped when it's full
+	QueueSize int
+	// retries of a failed POST, -1 disables retrying, and milliseconds before the first retry, doubled on each one
+	Retries int
+	Backoff int
+	// seconds of each POST
+	Timeout int
+}
 
-	// 初始化Logger
-	InitLogger(Conf.Common.LogPath, Conf.Common.LogLevel, Conf.Common.IsDebug)
+// RuleConf selects the published messages by the topic filter and the expression over the json payload,
+// and runs the actions in order
+type RuleConf struct {
+	Name  string
+	Topic string
+	// e.g. temp > 80 && status == "on", empty matches all, see rule_expr.go
+	Where   string
+	Actions []*RuleAction
+}
 
-	// stream hot update
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   Conf.Etcd.Addrs,
-		DialTimeout: 5 * time.Second,
-	})
-	if err != nil {
-		Logger.Fatal("can't connect to etcd", zap.Error(err))
+// RuleAction is republish, webhook, file or drop
+type RuleAction struct {
+	Type string
+	// republish: the new topic, {1}, {2}... are the levels matched by the wildcards of the rule topic
+	Topic  string
+	Qos    byte
+	Retain bool
+	// webhook: name of a [[webhook]]
+	Webhook string
+	// file: the messages are appended as json lines in the background
+	Path string
+}
+
+// registryStart watches the streams and the rooms and registers the room,
+// it's skipped without [etcd] addrs or file
+func (g *Gate) registryStart(ctx context.Context) error {
+	conf := g.Config()
+
+	reg := g.opts.Registry
+	if reg == nil {
+		ttl := time.Duration(conf.Etcd.TTL) * time.Second
+		switch {
+		case len(conf.Etcd.Addrs) > 0:
+			r, err := registry.NewEtcd(conf.Etcd.Addrs, 5*time.Second, ttl, g.logger)
+			if err != nil {
+				return fmt.Errorf("can't connect to etcd: %v", err)
+			}
+			reg = r
+		case conf.Etcd.File != "":
+			reg = registry.NewFile(conf.Etcd.File, ttl)
+		default:
+			g.logger.Warn("etcd addrs and file are empty, the streams and the rooms are not watched")
+			return nil
+		}
+		g.ownRegistry = true
 	}
+	g.registry = reg
 
-	watchEtcd(cli)
-	uploadEtcd(cli)
+	if err := g.watchRegistry(ctx); err != nil {
+		return err
+	}
 
-	fmt.Println(Conf)
+	return g.registerRoom(ctx)
 }
 
-// update the stream addrs
-func watchEtcd(cli *clientv3.Client) {
+// watchRegistry updates the streams and the rooms until ctx is done
+func (g *Gate) watchRegistry(ctx context.Context) error {
+	conf := g.Config()
 
-	// update the stream addrs
-	go func() {
-		Conf.StreamAddrs = make(map[string]string)
-		rch := cli.Watch(context.TODO(), Conf.Etcd.Streams, clientv3.WithPrefix())
+	streams, err := g.registry.Watch(ctx, conf.Etcd.Streams)
+	if err != nil {
+		return fmt.Errorf("watch streams: %v", err)
+	}
 
-		for wresp := range rch {
-			for _, ev := range wresp.Events {
-				if ev.Type == 0 { // PUT
-					Conf.StreamAddrs[string(ev.Kv.Key)] = string(ev.Kv.Value)
-				} else {
-					delete(Conf.StreamAddrs, string(ev.Kv.Key))
-				}
-			}
+	rooms, err := g.registry.Watch(ctx, conf.Etcd.Rooms)
+	if err != nil {
+		return fmt.Errorf("watch rooms: %v", err)
+	}
 
-			// Logger.Debug("get new stream addrs", zap.Object("addrs", Conf.StreamAddrs))
-		}
-	}()
+	// update the stream addrs, the subscriptions moved to other streams are re-resolved
+	go watchAddrs(streams, func(addrs map[string]string) {
+		g.streams.update(streamAddrs(addrs))
+	})
 
 	// update the room addrs
-	go func() {
-		Conf.RoomAddrs = make(map[string]string)
-		rch := cli.Watch(context.TODO(), Conf.Etcd.Rooms, clientv3.WithPrefix())
-
-		for wresp := range rch {
-			for _, ev := range wresp.Events {
-				v := string(ev.Kv.Value)
-				if ev.Type == 0 { // PUT
-					Conf.RoomAddrs[string(ev.Kv.Key)] = v
-				} else {
-					delete(Conf.RoomAddrs, string(ev.Kv.Key))
-				}
-			}
+	go watchAddrs(rooms, func(addrs map[string]string) {
+		c := consistent.New()
+		for _, v := range addrs {
+			c.Add(v)
+		}
+		g.rooms.set(c)
+	})
+
+	return nil
+}
 
-			Consist = consistent.New()
-			for _, v := range Conf.RoomAddrs {
-				Consist.Add(v)
+// watchAddrs keeps the addrs of the instances by key, update is called once for the events sent together
+func watchAddrs(ch <-chan registry.Event, update func(addrs map[string]string)) {
+	addrs := make(map[string]string)
+	for ev := range ch {
+		for more := true; more; {
+			if ev.Type == registry.EventAdd {
+				addrs[ev.Key] = ev.Addr
+			} else {
+				delete(addrs, ev.Key)
 			}
 
-			// Logger.Debug("get new room addrs", zap.Object("addrs", Conf.RoomAddrs), zap.Object("consist_addrs", Consist.Members()))
+			select {
+			case ev, more = <-ch:
+			default:
+				more = false
+			}
 		}
-	}()
+
+		update(addrs)
+	}
 }
 
-func uploadEtcd(cli *clientv3.Client) {
-	key := Conf.Etcd.Rooms + "/" + getHost()
-	ip := tools.LocalIP()
-	Logger.Debug("local ip", zap.String("ip", ip))
+// roomRing hashes the accounts to the registered rooms, for dispatch
+type roomRing struct {
+	sync.RWMutex
+	ring *consistent.Consistent
+}
 
-	go func() {
-		for {
-			// upload self ip
-			Grant, err := cli.Grant(context.TODO(), 120)
-			if err != nil {
-				Logger.Warn("etcd grant error", zap.Error(err))
-			}
+func (r *roomRing) set(c *consistent.Consistent) {
+	r.Lock()
+	r.ring = c
+	r.Unlock()
+}
 
-			_, err = cli.Put(context.TODO(), key, ip, clientv3.WithLease(Grant.ID))
-			if err != nil {
-				Logger.Warn("etcd put error", zap.Error(err))
-			}
+// get returns the room of the account
+func (r *roomRing) get(acc string) (string, error) {
+	r.RLock()
+	defer r.RUnlock()
 
-			time.Sleep(30 * time.Second)
-		}
-	}()
+	if r.ring == nil {
+		return "", consistent.ErrEmptyCircle
+	}
+	return r.ring.Get(acc)
+}
+
+func (r *roomRing) members() []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.ring == nil {
+		return nil
+	}
+	return r.ring.Members()
+}
+
+// roomReg is the room registered for dispatch, removed when draining
+type roomReg struct {
+	sync.Mutex
+	svc     *registry.Service
+	removed bool
+}
+
+// registerRoom registers the room, its lease is kept alive by the registry until deregisterRoom
+func (g *Gate) registerRoom(ctx context.Context) error {
+	g.logger.Debug("local ip", zap.String("ip", localIP))
+	svc := &registry.Service{Name: g.Config().Etcd.Rooms, Key: g.host(), Addr: localIP}
+
+	g.room.Lock()
+	defer g.room.Unlock()
+
+	if g.room.removed {
+		return nil
+	}
+	if err := g.registry.Register(ctx, svc); err != nil {
+		return fmt.Errorf("register room: %v", err)
+	}
+	g.room.svc = svc
+
+	return nil
+}
+
+// deregisterRoom removes the room from the registry, so dispatch stops sending new clients here
+func (g *Gate) deregisterRoom() {
+	g.room.Lock()
+	defer g.room.Unlock()
+
+	svc := g.room.svc
+	g.room.removed = true
+	if svc == nil {
+		return
+	}
+	g.room.svc = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := g.registry.Deregister(ctx, svc); err != nil {
+		g.logger.Warn("deregister room error", zap.Error(err), zap.String("room", svc.Key))
+		return
+	}
 
+	g.logger.Info("room deregistered", zap.String("room", svc.Key))
 }
 
-func getHost() string {
+// host is the name of the room
+func (g *Gate) host() string {
 	host, err := os.Hostname()
 	if err != nil {
-		Logger.Fatal("get hostname error", zap.Error(err))
+		g.logger.Warn("get hostname error", zap.Error(err))
+		host = localIP
 	}
 
 	// in debug enviroment,we need to start several nodes in one machine,so pid is needed
-	if Conf.Common.IsDebug {
+	if g.Config().Common.IsDebug {
 		return fmt.Sprintf("%s-%d", host, os.Getpid())
 	}
 
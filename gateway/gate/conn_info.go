@@ -3,8 +3,11 @@ package gate
 import (
 	"net"
 	"sync"
+	"time"
 
 	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+	"github.com/uber-go/atomic"
 )
 
 type connInfo struct {
@@ -12,33 +15,196 @@ type connInfo struct {
 	c  net.Conn
 	cp *proto.ConnectPacket
 
-	inCount  int
-	outCount int
+	// the gateway serving this connection
+	g *Gate
+
+	// the listener accepting this connection
+	l *listener
+
+	// the original client address, taken from the PROXY protocol header when it's enabled
+	addr net.Addr
+	ip   string
+
+	// packets read and written after CONNECT, they are read by the admin api
+	inCount  atomic.Int64
+	outCount atomic.Int64
+
+	// when the session is accepted
+	connected time.Time
+
+	// the packets can be written by the admin api and the connection at the same time
+	wlock sync.Mutex
+	// last packet id of the messages sent by the gateway
+	pid atomic.Uint32
+
+	// outbound queue of the deliveries from the streams, and the qos 1 ones waiting for PUBACK,
+	// outLock guards them and outClosed
+	outLock   sync.Mutex
+	out       chan *outMsg
+	outClosed bool
+	pending   map[uint16]*outMsg
 
 	stopped chan struct{}
 
 	relogin bool
+
+	// the identity of the client, kept after authentication for the acl checks
+	cred *Credential
+
+	// limits of the client, nil means no limit
+	limits *clientLimiter
+	// topic filter -> granted qos, only changed by the connection, slock guards reading from the others
+	slock sync.RWMutex
+	subs  map[string]byte
+
+	// will message of this session, nil if the client didn't set one or
+	// the client has disconnected gracefully
+	will *proto.PublishPacket
+
+	// why the connection is going to be closed, only the first reason is kept
+	closeOnce   sync.Once
+	closeReason string
+}
+
+// closing records the reason why the connection ends.
+func (ci *connInfo) closing(reason string) {
+	ci.closeOnce.Do(func() {
+		ci.closeReason = reason
+	})
+}
+
+// close closes the connection from another goroutine, the will message is handled by serve
+func (ci *connInfo) close(reason string) {
+	ci.closing(reason)
+	ci.c.Close()
+}
+
+// write sends a packet to the client. A client not reading is disconnected after the write timeout,
+// or it would block the others writing to it forever
+func (ci *connInfo) write(p proto.Packet) error {
+	writeQueue.Inc()
+	ci.wlock.Lock()
+	writeQueue.Dec()
+	defer ci.wlock.Unlock()
+
+	ci.c.SetWriteDeadline(time.Now().Add(ci.g.writeTimeout()))
+	if err := service.WritePacket(ci.c, p); err != nil {
+		// the packet may be written partly, the connection can't be used anymore
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			stats.writeTimeouts.Inc()
+			ci.close(closeWriteTimeout)
+		}
+		return err
+	}
+
+	ci.outCount.Inc()
+	countOut(p)
+	ci.g.tracePacket(ci, traceOut, p)
+	return nil
+}
+
+func (g *Gate) writeTimeout() time.Duration {
+	if t := g.Config().Mqtt.WriteTimeout; t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// maxPayload is the payload limit of the clients without their own one
+func (g *Gate) maxPayload() int {
+	if m := g.Config().Mqtt.MaxPayload; m > 0 {
+		return m
+	}
+	return 1 << 20
+}
+
+// packetID returns the next non-zero packet id
+func (ci *connInfo) packetID() uint16 {
+	for {
+		if id := uint16(ci.pid.Inc()); id != 0 {
+			return id
+		}
+	}
 }
 
 type connInfos struct {
 	sync.RWMutex
 	infos map[int]*connInfo
+
+	// client id -> conn id, one client id can only have one online session
+	clients map[string]int
+}
+
+func newConnInfos() *connInfos {
+	return &connInfos{
+		infos:   make(map[int]*connInfo),
+		clients: make(map[string]int),
+	}
+}
+
+// list returns a snapshot of the online connections
+func (cs *connInfos) list() []*connInfo {
+	cs.RLock()
+	defer cs.RUnlock()
+
+	list := make([]*connInfo, 0, len(cs.infos))
+	for _, ci := range cs.infos {
+		list = append(list, ci)
+	}
+	return list
+}
+
+func (cs *connInfos) len() int {
+	cs.RLock()
+	defer cs.RUnlock()
+
+	return len(cs.infos)
 }
 
-var cons = &connInfos{
-	infos: make(map[int]*connInfo),
+// byClient returns the online connection of the client id
+func (cs *connInfos) byClient(clientID string) *connInfo {
+	cs.RLock()
+	defer cs.RUnlock()
+
+	if id, ok := cs.clients[clientID]; ok {
+		return cs.infos[id]
+	}
+	return nil
 }
 
-func saveCI(ci *connInfo) {
-	cons.Lock()
-	cons.infos[ci.id] = ci
-	cons.Unlock()
+// conn id generator
+var cidGen = atomic.NewInt64(0)
+
+func newCID() int {
+	return int(cidGen.Inc())
 }
 
-func getCI(id int) *connInfo {
-	cons.RLock()
-	c, ok := cons.infos[id]
-	cons.RUnlock()
+// saveCI saves the ci, if there is another online connection using the same client id,
+// that connection will be returned and the caller should take it over.
+func (g *Gate) saveCI(ci *connInfo) *connInfo {
+	g.conns.Lock()
+	defer g.conns.Unlock()
+
+	g.conns.infos[ci.id] = ci
+
+	if ci.cp == nil || len(ci.cp.ClientId()) == 0 {
+		return nil
+	}
+
+	clientID := string(ci.cp.ClientId())
+	oid, ok := g.conns.clients[clientID]
+	g.conns.clients[clientID] = ci.id
+	if !ok || oid == ci.id {
+		return nil
+	}
+
+	return g.conns.infos[oid]
+}
+
+func (g *Gate) getCI(id int) *connInfo {
+	g.conns.RLock()
+	c, ok := g.conns.infos[id]
+	g.conns.RUnlock()
 
 	if ok {
 		return c
@@ -47,8 +213,14 @@ func getCI(id int) *connInfo {
 	return nil
 }
 
-func delCI(id int) {
-	cons.Lock()
-	delete(cons.infos, id)
-	cons.Unlock()
+func (g *Gate) delCI(id int) {
+	g.conns.Lock()
+	if ci, ok := g.conns.infos[id]; ok && ci.cp != nil {
+		clientID := string(ci.cp.ClientId())
+		if g.conns.clients[clientID] == id {
+			delete(g.conns.clients, clientID)
+		}
+	}
+	delete(g.conns.infos, id)
+	g.conns.Unlock()
 }
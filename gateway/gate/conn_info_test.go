@@ -3,6 +3,9 @@ package gate
 import (
 	"reflect"
 	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
 )
 
 func Test_saveCI(t *testing.T) {
@@ -15,9 +18,10 @@ func Test_saveCI(t *testing.T) {
 	}{
 	// TODO: Add test cases.
 	}
+	g := testGate(t, nil)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			saveCI(tt.args.ci)
+			g.saveCI(tt.args.ci)
 		})
 	}
 }
@@ -33,9 +37,10 @@ func Test_getCI(t *testing.T) {
 	}{
 	// TODO: Add test cases.
 	}
+	g := testGate(t, nil)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := getCI(tt.args.id); !reflect.DeepEqual(got, tt.want) {
+			if got := g.getCI(tt.args.id); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("getCI() = %v, want %v", got, tt.want)
 			}
 		})
@@ -52,9 +57,53 @@ func Test_delCI(t *testing.T) {
 	}{
 	// TODO: Add test cases.
 	}
+	g := testGate(t, nil)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			delCI(tt.args.id)
+			g.delCI(tt.args.id)
 		})
 	}
 }
+
+func Test_saveCI_takeover(t *testing.T) {
+	cp := proto.NewConnectPacket()
+	cp.SetClientId([]byte("device1"))
+
+	g := testGate(t, nil)
+	old := &connInfo{g: g, id: newCID(), cp: cp}
+	ci := &connInfo{g: g, id: newCID(), cp: cp}
+
+	if got := g.saveCI(old); got != nil {
+		t.Fatalf("saveCI() = %v, want nil", got)
+	}
+	if got := g.saveCI(ci); got != old {
+		t.Fatalf("saveCI() = %v, want the old connection", got)
+	}
+
+	// the old connection exits after the new one has been registered
+	g.delCI(old.id)
+	if g.conns.clients["device1"] != ci.id {
+		t.Errorf("client id is bound to %d, want %d", g.conns.clients["device1"], ci.id)
+	}
+}
+
+func Test_connInfo_write_timeout(t *testing.T) {
+	conf := &Config{}
+	conf.Mqtt.WriteTimeout = 1
+	g := testGate(t, conf)
+
+	// the client never reads
+	ci, cc := testConn(g, "writec1", "alice", "1.1.1.1")
+	defer cc.Close()
+
+	start := time.Now()
+	if err := ci.write(proto.NewPingrespPacket()); err == nil {
+		t.Fatal("write() to a client not reading succeeded")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("write() blocked %v, want about the write timeout", d)
+	}
+	if ci.closeReason != closeWriteTimeout {
+		t.Errorf("closeReason = %q, want %q", ci.closeReason, closeWriteTimeout)
+	}
+}
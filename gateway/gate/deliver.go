@@ -0,0 +1,328 @@
+package gate
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	rpc "github.com/aiyun/gomqtt/proto"
+	"github.com/uber-go/zap"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// codes of DeliverAck
+const (
+	ackDelivered   = 0
+	ackNotFound    = 1
+	ackQueueFull   = 2
+	ackInvalid     = 3
+	ackUndelivered = 4
+)
+
+// default size of the outbound queue of a connection
+const defaultOutQueue = 1000
+
+// outMsg is a delivery waiting in the outbound queue, ack is called once with the result
+type outMsg struct {
+	p   *proto.PublishPacket
+	mid uint64
+	ack func(mid uint64, code int32)
+}
+
+func (m *outMsg) done(code int32) {
+	if m.ack != nil {
+		m.ack(m.mid, code)
+	}
+}
+
+// openOut creates the outbound queue, the deliveries are accepted from now on
+func (ci *connInfo) openOut(size int) {
+	if size <= 0 {
+		size = defaultOutQueue
+	}
+
+	ci.outLock.Lock()
+	ci.out = make(chan *outMsg, size)
+	ci.pending = make(map[uint16]*outMsg)
+	ci.outLock.Unlock()
+}
+
+// enqueue returns ackDelivered if the message is queued, or the code reporting why not
+func (ci *connInfo) enqueue(m *outMsg) int32 {
+	ci.outLock.Lock()
+	defer ci.outLock.Unlock()
+
+	if ci.out == nil || ci.outClosed {
+		return ackNotFound
+	}
+
+	select {
+	case ci.out <- m:
+		writeQueue.Inc()
+		return ackDelivered
+	default:
+		return ackQueueFull
+	}
+}
+
+// writeLoop writes the queued deliveries until the connection stops
+func (ci *connInfo) writeLoop() {
+	for {
+		select {
+		case <-ci.stopped:
+			return
+		case m := <-ci.out:
+			writeQueue.Dec()
+			ci.writeOut(m)
+		}
+	}
+}
+
+func (ci *connInfo) writeOut(m *outMsg) {
+	// qos 1 is acked when the client sends PUBACK
+	if m.p.QoS() > 0 {
+		pid := ci.packetID()
+		m.p.SetPacketID(pid)
+
+		ci.outLock.Lock()
+		if ci.outClosed {
+			ci.outLock.Unlock()
+			m.done(ackUndelivered)
+			return
+		}
+		ci.pending[pid] = m
+		ci.outLock.Unlock()
+
+		if err := ci.write(m.p); err != nil {
+			ci.g.logger.Debug("deliver error", zap.Error(err), zap.Int("cid", ci.id))
+
+			// it may have been acked by closeOut
+			ci.outLock.Lock()
+			_, ok := ci.pending[pid]
+			delete(ci.pending, pid)
+			ci.outLock.Unlock()
+			if ok {
+				m.done(ackUndelivered)
+			}
+		}
+		return
+	}
+
+	if err := ci.write(m.p); err != nil {
+		ci.g.logger.Debug("deliver error", zap.Error(err), zap.Int("cid", ci.id))
+		m.done(ackUndelivered)
+		return
+	}
+	m.done(ackDelivered)
+}
+
+// acked is called on PUBACK
+func (ci *connInfo) acked(pid uint16) {
+	ci.outLock.Lock()
+	m, ok := ci.pending[pid]
+	delete(ci.pending, pid)
+	ci.outLock.Unlock()
+
+	if ok {
+		m.done(ackDelivered)
+	}
+}
+
+// closeOut stops accepting deliveries, the queued and unacked ones are reported undelivered,
+// so the stream can keep them for the next session
+func (ci *connInfo) closeOut() {
+	ci.outLock.Lock()
+	if ci.out == nil || ci.outClosed {
+		ci.outLock.Unlock()
+		return
+	}
+	ci.outClosed = true
+
+	var left []*outMsg
+	for _, m := range ci.pending {
+		left = append(left, m)
+	}
+	ci.pending = nil
+
+	for more := true; more; {
+		select {
+		case m := <-ci.out:
+			writeQueue.Dec()
+			left = append(left, m)
+		default:
+			more = false
+		}
+	}
+	ci.outLock.Unlock()
+
+	for _, m := range left {
+		m.done(ackUndelivered)
+	}
+}
+
+// findDelivery returns the connection a delivery is addressed to
+func (g *Gate) findDelivery(d *rpc.Delivery) *connInfo {
+	if d.ConId != 0 {
+		return g.getCI(int(d.ConId))
+	}
+
+	return g.conns.byClient(d.Cid)
+}
+
+// deliver queues a message to the connection, ack is called at once if it can't be queued
+func (g *Gate) deliver(d *rpc.Delivery, ack func(uint64, int32)) {
+	ci := g.findDelivery(d)
+	if ci == nil {
+		ack(d.Mid, ackNotFound)
+		return
+	}
+
+	// the payload may be empty, e.g. clearing a retained message
+	p := proto.NewPublishPacket()
+	if err := p.SetTopic(d.Tp); err != nil {
+		ack(d.Mid, ackInvalid)
+		return
+	}
+
+	// qos 2 isn't supported by the gateway
+	qos := byte(d.Qos)
+	if qos > 1 {
+		qos = 1
+	}
+	p.SetQoS(qos)
+	p.SetRetain(d.Retain)
+	p.SetPayload(d.Pl)
+
+	p, err := g.getHooks().onDeliver(ci, p)
+	switch {
+	case err == ErrHookDrop:
+		ack(d.Mid, ackDelivered)
+		return
+	case err != nil:
+		ack(d.Mid, ackUndelivered)
+		return
+	}
+
+	if code := ci.enqueue(&outMsg{p: p, mid: d.Mid, ack: ack}); code != ackDelivered {
+		ack(d.Mid, code)
+	}
+}
+
+// deliverServer is the grpc service the streams push messages through
+type deliverServer struct {
+	g *Gate
+}
+
+// ackQueue keeps the acks of a stream until they're sent. push never blocks, the acks are pushed
+// by the connection goroutines, which mustn't wait for a slow stream. It's bounded by the messages
+// the stream has delivered and not got acks of.
+type ackQueue struct {
+	sync.Mutex
+	acks []*rpc.DeliverAck
+	// signaled when acks becomes non-empty
+	ready chan struct{}
+}
+
+func newAckQueue() *ackQueue {
+	return &ackQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *ackQueue) push(mid uint64, code int32) {
+	q.Lock()
+	q.acks = append(q.acks, &rpc.DeliverAck{Mid: mid, Code: code})
+	q.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the queued acks
+func (q *ackQueue) take() []*rpc.DeliverAck {
+	q.Lock()
+	defer q.Unlock()
+
+	acks := q.acks
+	q.acks = nil
+	return acks
+}
+
+// sendAcks sends the queued acks until ctx is done, grpc doesn't allow sending from several goroutines
+func sendAcks(ctx context.Context, cancel context.CancelFunc, s rpc.Gateway_DeliverServer, q *ackQueue) {
+	for {
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			// the stream is gone, it redelivers the unacked messages
+			return
+		}
+
+		for _, a := range q.take() {
+			if err := s.Send(a); err != nil {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (ds *deliverServer) Deliver(s rpc.Gateway_DeliverServer) error {
+	ctx, cancel := context.WithCancel(s.Context())
+	defer cancel()
+
+	q := newAckQueue()
+	ack := q.push
+	go sendAcks(ctx, cancel, s, q)
+
+	for {
+		batch, err := s.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, d := range batch.Msgs {
+			ds.g.deliver(d, ack)
+		}
+	}
+}
+
+// deliverStart serves the deliveries on [deliver] addr, the streams find it by AccMsg.Gip
+func (g *Gate) deliverStart() error {
+	addr := g.Config().Deliver.Addr
+	if addr == "" {
+		g.logger.Warn("deliver addr isn't set, the streams can't push messages to this gateway")
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("deliver listen: %v", err)
+	}
+
+	g.deliverSrv = grpc.NewServer()
+	rpc.RegisterGatewayServer(g.deliverSrv, &deliverServer{g: g})
+	go func() {
+		if err := g.deliverSrv.Serve(ln); err != nil {
+			g.logger.Warn("deliver serve", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// gatewayAddr is the delivery address reported to the streams
+func (g *Gate) gatewayAddr() string {
+	_, port, err := net.SplitHostPort(g.Config().Deliver.Addr)
+	if err != nil || port == "" {
+		return localIP
+	}
+
+	return net.JoinHostPort(localIP, port)
+}
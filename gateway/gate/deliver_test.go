@@ -0,0 +1,207 @@
+package gate
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+	rpc "github.com/aiyun/gomqtt/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+type ackRecorder chan *rpc.DeliverAck
+
+func (ar ackRecorder) ack(mid uint64, code int32) {
+	ar <- &rpc.DeliverAck{Mid: mid, Code: code}
+}
+
+func (ar ackRecorder) expect(t *testing.T, mid uint64, code int32) {
+	select {
+	case a := <-ar:
+		if a.Mid != mid || a.Code != code {
+			t.Errorf("ack = %d/%d, want %d/%d", a.Mid, a.Code, mid, code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("no ack of %d", mid)
+	}
+}
+
+// deliverConn is an online session reading its deliveries from cc
+func deliverConn(t *testing.T, g *Gate, queue int, writer bool) (*connInfo, net.Conn) {
+	sc, cc := net.Pipe()
+	t.Cleanup(func() { cc.Close() })
+
+	ci := &connInfo{g: g, id: newCID(), c: sc, stopped: make(chan struct{})}
+	ci.openOut(queue)
+	g.saveCI(ci)
+	t.Cleanup(func() {
+		g.delCI(ci.id)
+		close(ci.stopped)
+	})
+
+	if writer {
+		go ci.writeLoop()
+	}
+	return ci, cc
+}
+
+func readPublish(t *testing.T, c net.Conn) *proto.PublishPacket {
+	pt, _, _, err := service.ReadPacket(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := pt.(*proto.PublishPacket)
+	if !ok {
+		t.Fatalf("read %v, want PUBLISH", pt)
+	}
+	return p
+}
+
+func Test_deliver(t *testing.T) {
+	g := testGate(t, nil)
+	ci, cc := deliverConn(t, g, 10, true)
+	acks := make(ackRecorder, 10)
+
+	// qos 0 is acked once it's written
+	g.deliver(&rpc.Delivery{Mid: 1, ConId: int64(ci.id), Tp: []byte("a/b"), Pl: []byte("hi")}, acks.ack)
+	if p := readPublish(t, cc); string(p.Topic()) != "a/b" || string(p.Payload()) != "hi" {
+		t.Errorf("delivered %v", p)
+	}
+	acks.expect(t, 1, ackDelivered)
+
+	// qos 1 is acked by PUBACK
+	g.deliver(&rpc.Delivery{Mid: 2, ConId: int64(ci.id), Tp: []byte("a/b"), Pl: []byte("hi"), Qos: 1}, acks.ack)
+	p := readPublish(t, cc)
+	if p.QoS() != 1 || p.PacketID() == 0 {
+		t.Fatalf("delivered %v, want qos 1 with packet id", p)
+	}
+	select {
+	case a := <-acks:
+		t.Fatalf("acked %v before PUBACK", a)
+	case <-time.After(20 * time.Millisecond):
+	}
+	ci.acked(p.PacketID())
+	acks.expect(t, 2, ackDelivered)
+
+	g.deliver(&rpc.Delivery{Mid: 3, Cid: "nobody"}, acks.ack)
+	acks.expect(t, 3, ackNotFound)
+
+	g.deliver(&rpc.Delivery{Mid: 4, ConId: int64(ci.id), Tp: []byte("a/#"), Pl: []byte("hi")}, acks.ack)
+	acks.expect(t, 4, ackInvalid)
+
+	// an empty retained message clears the retained one of the topic
+	g.deliver(&rpc.Delivery{Mid: 5, ConId: int64(ci.id), Tp: []byte("a/b"), Retain: true}, acks.ack)
+	if p := readPublish(t, cc); !p.Retain() || len(p.Payload()) != 0 {
+		t.Errorf("delivered %v, want empty retained message", p)
+	}
+	acks.expect(t, 5, ackDelivered)
+}
+
+func Test_closeOut(t *testing.T) {
+	g := testGate(t, nil)
+	ci, _ := deliverConn(t, g, 1, false)
+	acks := make(ackRecorder, 10)
+
+	g.deliver(&rpc.Delivery{Mid: 1, ConId: int64(ci.id), Tp: []byte("a"), Pl: []byte("x")}, acks.ack)
+	g.deliver(&rpc.Delivery{Mid: 2, ConId: int64(ci.id), Tp: []byte("a"), Pl: []byte("x")}, acks.ack)
+	acks.expect(t, 2, ackQueueFull)
+
+	// a qos 1 message waiting for PUBACK
+	m := &outMsg{p: proto.NewPublishPacket(), mid: 3, ack: acks.ack}
+	ci.pending[1] = m
+
+	ci.closeOut()
+	got := map[uint64]int32{}
+	for i := 0; i < 2; i++ {
+		a := <-acks
+		got[a.Mid] = a.Code
+	}
+	if got[1] != ackUndelivered || got[3] != ackUndelivered {
+		t.Errorf("acks after closeOut = %v, want 1 and 3 undelivered", got)
+	}
+
+	g.deliver(&rpc.Delivery{Mid: 4, ConId: int64(ci.id), Tp: []byte("a"), Pl: []byte("x")}, acks.ack)
+	acks.expect(t, 4, ackNotFound)
+}
+
+func Test_deliverServer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := testGate(t, nil)
+	srv := grpc.NewServer()
+	rpc.RegisterGatewayServer(srv, &deliverServer{g: g})
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ci, cc := deliverConn(t, g, 10, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, err := rpc.NewGatewayClient(conn).Deliver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Send(&rpc.DeliverBatch{Msgs: []*rpc.Delivery{
+		{Mid: 1, ConId: int64(ci.id), Tp: []byte("a/b"), Pl: []byte("1")},
+		{Mid: 2, Cid: "nobody", Tp: []byte("a/b"), Pl: []byte("2")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p := readPublish(t, cc); string(p.Payload()) != "1" {
+		t.Errorf("delivered %v", p)
+	}
+
+	got := map[uint64]int32{}
+	for i := 0; i < 2; i++ {
+		a, err := s.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[a.Mid] = a.Code
+	}
+	if got[1] != ackDelivered || got[2] != ackNotFound {
+		t.Errorf("acks = %v", got)
+	}
+}
+
+func Test_ackQueue(t *testing.T) {
+	q := newAckQueue()
+
+	// no sender, push never blocks the connections
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5000; i++ {
+			q.push(uint64(i), ackDelivered)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("push() blocked")
+	}
+
+	<-q.ready
+	acks := q.take()
+	if len(acks) != 5000 || acks[0].Mid != 0 || acks[4999].Mid != 4999 {
+		t.Errorf("take() = %d acks, want 5000 in order", len(acks))
+	}
+	if acks := q.take(); len(acks) != 0 {
+		t.Errorf("take() again = %v, want none", acks)
+	}
+}
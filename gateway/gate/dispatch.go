@@ -0,0 +1,71 @@
+package gate
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/uber-go/zap"
+)
+
+/* Dispatch a room ip to the client which is requesting */
+
+type dispatchResp struct {
+	Result string `json:"result"`
+	Data   string `json:"data"`
+}
+
+// dispatchStart serves /dispatch on [dispatch] addr, it's disabled when the addr is empty
+func (g *Gate) dispatchStart() error {
+	addr := g.Config().Dispatch.Addr
+	if addr == "" {
+		return nil
+	}
+
+	e := echo.New()
+	e.Any("/dispatch", g.dispatch)
+
+	srv, err := g.serveHTTP(addr, e)
+	if err != nil {
+		return err
+	}
+	g.dispatchSrv = srv
+
+	return nil
+}
+
+// dispatch hashes the account to one of the registered rooms
+func (g *Gate) dispatch(c echo.Context) error {
+	var acc string
+
+	switch c.Request().Method {
+	case "GET":
+		acc = c.QueryParam("account")
+
+	case "POST":
+		acc = c.FormValue("account")
+
+	default:
+		g.logger.Info("invalid request method", zap.String("method", c.Request().Method))
+	}
+
+	if acc == "" {
+		return c.JSON(http.StatusOK, dispatchResp{
+			Result: "error",
+			Data:   "invalid params",
+		})
+	}
+
+	ip, err := g.rooms.get(acc)
+	if err != nil {
+		g.logger.Info("get consist ip error", zap.Error(err), zap.Object("consist", g.rooms.members()))
+		return c.JSON(http.StatusOK, dispatchResp{
+			Result: "error",
+			Data:   "no room available",
+		})
+	}
+
+	return c.JSON(http.StatusOK, dispatchResp{
+		Result: "success",
+		Data:   ip,
+	})
+}
@@ -0,0 +1,163 @@
+package gate
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// default drain settings, in seconds
+const (
+	defaultDrainWindow  = 30
+	defaultFlushTimeout = 10
+)
+
+var errFlushTimeout = errors.New("flush timeout, some messages may be lost")
+
+// Shutdown drains the room: the room is deregistered so dispatch stops sending clients here,
+// the listeners are closed, then the connections are closed gradually over [drain] window,
+// so the clients don't reconnect to the other rooms all at once.
+// It returns after the in-flight messages are routed to stream, or [drain] flush_timeout passed,
+// the window and the flushing end early when ctx is done.
+func (g *Gate) Shutdown(ctx context.Context) error {
+	g.admit.Lock()
+	g.draining.Store(true)
+	g.admit.Unlock()
+
+	conf := g.Config()
+	g.logger.Info("gateway is draining")
+
+	g.deregisterRoom()
+
+	for _, l := range g.listeners {
+		if err := l.p.Close(); err != nil {
+			g.logger.Warn("close listener error", zap.Error(err), zap.String("listener", l.name))
+		}
+		if l.certs != nil {
+			l.certs.stop()
+		}
+	}
+
+	window := time.Duration(conf.Drain.Window) * time.Second
+	if conf.Drain.Window <= 0 {
+		window = defaultDrainWindow * time.Second
+	}
+	n := g.drainConns(ctx, window)
+	g.logger.Info("connections drained", zap.Int("conns", n))
+
+	// the connections still in handshake when the window began
+	g.drainConns(ctx, 0)
+
+	timeout := time.Duration(conf.Drain.FlushTimeout) * time.Second
+	if conf.Drain.FlushTimeout <= 0 {
+		timeout = defaultFlushTimeout * time.Second
+	}
+	if !waitGroup(ctx, &g.serving, timeout) {
+		g.logger.Warn("flush timeout, some messages may be lost")
+		g.stop()
+		return errFlushTimeout
+	}
+
+	// the delivery streams never end by themselves, the unacked messages are redelivered by the streams
+	if g.deliverSrv != nil {
+		g.deliverSrv.Stop()
+	}
+
+	// the disconnected events are posted
+	if !g.stopWebhooks(timeout) {
+		g.logger.Warn("webhook flush timeout, some events may be lost")
+	}
+
+	g.stop()
+	g.logger.Info("gateway is stopped")
+
+	return nil
+}
+
+// stop closes the admin and dispatch servers, the monitors and the registry
+func (g *Gate) stop() {
+	if g.admin != nil {
+		g.admin.Close()
+	}
+	if g.dispatchSrv != nil {
+		g.dispatchSrv.Close()
+	}
+	if g.deliverSrv != nil {
+		g.deliverSrv.Stop()
+	}
+	// the queued lines of the rule files are written
+	if rs, ok := g.rules.Load().(*ruleSet); ok {
+		rs.close()
+	}
+
+	for _, t := range g.traceList() {
+		g.stopTrace(t.id)
+	}
+
+	if g.cancel != nil {
+		g.cancel()
+	}
+	if g.ownRegistry {
+		g.registry.Close()
+	}
+
+	removeRunning(g)
+}
+
+// drainConns closes the online connections, each one at a random moment in the window,
+// it returns the number of closed connections when all of them are closed.
+// The ones left are closed at once when ctx is done.
+func (g *Gate) drainConns(ctx context.Context, window time.Duration) int {
+	list := g.conns.list()
+
+	var wg sync.WaitGroup
+	now := make(chan struct{})
+	for _, ci := range list {
+		var delay time.Duration
+		if window > 0 {
+			delay = time.Duration(rand.Int63n(int64(window)))
+		}
+
+		wg.Add(1)
+		ci := ci
+		go func() {
+			defer wg.Done()
+
+			select {
+			case <-time.After(delay):
+			case <-now:
+			}
+			// MQTT 3.1.1 has no DISCONNECT from the server, closing the socket makes the client reconnect
+			ci.close(closeShutdown)
+		}()
+	}
+
+	if !waitGroup(ctx, &wg, window+time.Second) {
+		close(now)
+		wg.Wait()
+	}
+
+	return len(list)
+}
+
+// waitGroup waits for the goroutines in wg, false is returned on timeout or when ctx is done
+func waitGroup(ctx context.Context, wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
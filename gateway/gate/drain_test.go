@@ -0,0 +1,72 @@
+package gate
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+func Test_drainConns(t *testing.T) {
+	g := testGate(t, nil)
+
+	var clients []net.Conn
+	var cis []*connInfo
+	for i := 0; i < 3; i++ {
+		sc, cc := net.Pipe()
+		defer cc.Close()
+		clients = append(clients, cc)
+
+		ci := &connInfo{g: g, id: newCID(), c: sc}
+		cis = append(cis, ci)
+		g.saveCI(ci)
+		defer g.delCI(ci.id)
+	}
+
+	start := time.Now()
+	if got := g.drainConns(context.Background(), 100*time.Millisecond); got < len(cis) {
+		t.Fatalf("g.drainConns() = %d, want at least %d", got, len(cis))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("g.drainConns() took %v, want within the window", d)
+	}
+
+	for i, cc := range clients {
+		if _, err := cc.Read(make([]byte, 1)); err == nil {
+			t.Errorf("connection %d is still open", i)
+		}
+		if cis[i].closeReason != closeShutdown {
+			t.Errorf("closeReason = %q, want %q", cis[i].closeReason, closeShutdown)
+		}
+	}
+}
+
+func Test_publishWill_shutdown(t *testing.T) {
+	will := proto.NewPublishPacket()
+	will.SetTopic([]byte("devices/1/status"))
+
+	ci := &connInfo{g: testGate(t, nil), will: will}
+	ci.closing(closeShutdown)
+
+	before := stats.willDiscarded.Load()
+	publishWill(ci)
+	if ci.will != nil || stats.willDiscarded.Load() != before+1 {
+		t.Errorf("will of a drained connection is not discarded")
+	}
+}
+
+func Test_waitGroup(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if waitGroup(context.Background(), &wg, 10*time.Millisecond) {
+		t.Errorf("waitGroup() = true with a running goroutine")
+	}
+
+	wg.Done()
+	if !waitGroup(context.Background(), &wg, time.Second) {
+		t.Errorf("waitGroup() = false, want true")
+	}
+}
@@ -1,26 +1,273 @@
 package gate
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
 
+	"github.com/aiyun/gomqtt/registry"
+	"github.com/naoina/toml"
+	uatomic "github.com/uber-go/atomic"
+	"github.com/uber-go/zap"
+	"google.golang.org/grpc"
+)
+
+// the config files read by the gateway command
+const (
+	DefaultConfigFile = "/etc/gomqtt/gateway.toml"
+	// -c, relative to the working dir
+	StaticConfigFile = "configs/gateway.toml"
+)
+
+var errStarted = errors.New("gateway is already started")
+
+// Options creates a Gate
+type Options struct {
+	// the toml config, DefaultConfigFile if empty, it's read again on reload
+	ConfigFile string
+	// used instead of ConfigFile when set, e.g. in tests, and reloading keeps it
+	Config *Config
+	// replaces the logger built from [common] when set
+	Logger zap.Logger
+	// replaces the one built from [etcd] when set, e.g. a registry.NewMemory shared by the gateways in tests,
+	// it isn't closed by Shutdown
+	Registry registry.Registry
+}
+
+// Gate is a gateway with its config, connections, listeners and plugins,
+// so several gateways can run in one process. The stats and the prometheus metrics
+// are shared by all of them.
 type Gate struct {
+	opts Options
+
+	// *Config, replaced as a whole on reload
+	conf      atomic.Value
+	reloading sync.Mutex
+	logger    zap.Logger
+
+	conns     *connInfos
+	listeners []*listener
+
+	// admission control of the new connections
+	admit *admission
+	// set when shutting down, the new connections are rejected
+	draining uatomic.Bool
+	// the serve goroutines of the admitted connections
+	serving sync.WaitGroup
+
+	// the plugins built from the config, replaced on reload
+	auths    atomic.Value
+	acls     atomic.Value
+	hooks    atomic.Value
+	rules    atomic.Value
+	webhooks webhookList
+	traces   traceSet
+
+	streams *streamRouter
+	// the registration of the room and the watchers of the streams and the rooms
+	registry    registry.Registry
+	ownRegistry bool
+	room        roomReg
+	rooms       roomRing
+
+	admin       *http.Server
+	dispatchSrv *http.Server
+	deliverSrv  *grpc.Server
+
+	// stops the monitors and the watchers
+	cancel  context.CancelFunc
+	started bool
 }
 
-func New() *Gate {
-	return &Gate{}
+// New reads the config and builds the plugins, nothing is served before Start
+func New(opts Options) (*Gate, error) {
+	if opts.ConfigFile == "" {
+		opts.ConfigFile = DefaultConfigFile
+	}
+
+	conf, err := readConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gate{
+		opts:   opts,
+		logger: opts.Logger,
+		conns:  newConnInfos(),
+		admit:  newAdmission(),
+	}
+	if g.logger == nil {
+		if g.logger, err = newLogger(conf.Common.LogPath, conf.Common.LogLevel, conf.Common.IsDebug); err != nil {
+			return nil, err
+		}
+	}
+	g.conf.Store(conf)
+	g.streams = newStreamRouter(g)
+
+	p, err := g.newPlugins(conf)
+	if err != nil {
+		return nil, err
+	}
+	g.usePlugins(p)
+
+	return g, nil
+}
+
+// Config returns the current config, it must not be changed
+func (g *Gate) Config() *Config {
+	return g.conf.Load().(*Config)
 }
 
-func (g *Gate) Start(isStatic bool) {
-	// init configurations
-	fmt.Println(isStatic)
+// Logger is the logger of the gateway, for the hooks
+func (g *Gate) Logger() zap.Logger {
+	return g.logger
+}
+
+// readConfig parses Options.ConfigFile, or returns Options.Config
+func readConfig(opts Options) (*Config, error) {
+	if opts.Config != nil {
+		return opts.Config, nil
+	}
+
+	contents, err := ioutil.ReadFile(opts.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
 
-	loadConfig(isStatic)
+	tbl, err := toml.Parse(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{}
+	if err := toml.UnmarshalTable(tbl, conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// plugins is built from a config before any of it is used, so a bad config changes nothing
+type plugins struct {
+	auths    *authChain
+	acls     *aclRules
+	hooks    *hookChain
+	webhooks []*webhook
+	rules    *ruleSet
+}
+
+func (g *Gate) newPlugins(conf *Config) (*plugins, error) {
+	p := &plugins{}
+	var err error
+
+	// init the authenticators
+	if p.auths, err = g.newAuthChain(conf, conf.Auth.Chain); err != nil {
+		return nil, fmt.Errorf("init authenticators: %v", err)
+	}
+
+	// load the topic acl rules
+	if p.acls, err = loadAcl(conf.Acl.File, conf.Acl.Default); err != nil {
+		return nil, fmt.Errorf("load acl error: %v", err)
+	}
+
+	// build the plugin hooks
+	if p.hooks, err = g.newHookChain(conf, conf.Hook.Chain); err != nil {
+		return nil, fmt.Errorf("init hooks: %v", err)
+	}
+
+	// the event webhooks are started by usePlugins
+	if p.webhooks, err = newWebhooks(conf.Webhook, g.logger); err != nil {
+		return nil, err
+	}
+
+	// the rules may send messages to the webhooks
+	if p.rules, err = g.newRuleSet(conf.Rule, conf.Webhook); err != nil {
+		return nil, fmt.Errorf("init rules: %v", err)
+	}
+
+	return p, nil
+}
+
+// usePlugins replaces the running plugins, the old rules close their files
+func (g *Gate) usePlugins(p *plugins) {
+	g.auths.Store(p.auths)
+	g.acls.Store(p.acls)
+	g.hooks.Store(p.hooks)
+	g.swapWebhooks(p.webhooks)
+
+	old, _ := g.rules.Load().(*ruleSet)
+	g.rules.Store(p.rules)
+	if old != nil {
+		old.close()
+	}
+
+	g.logger.Info("plugins loaded", zap.Int("acl_rules", len(p.acls.rules)), zap.Int("hooks", len(p.hooks.hooks)),
+		zap.Int("webhooks", len(p.webhooks)), zap.Int("rules", len(p.rules.rules)))
+}
+
+// Start serves the listeners, the admin and dispatch apis and the deliveries, then registers
+// the room. The monitors and the watchers run until ctx is done or Shutdown.
+// Everything started is closed if it fails.
+func (g *Gate) Start(ctx context.Context) (err error) {
+	if g.started {
+		return errStarted
+	}
+	g.started = true
+
+	ctx, g.cancel = context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			g.abortStart()
+		}
+	}()
 
 	// init providers
-	providersStart()
+	if err := g.providersStart(); err != nil {
+		return err
+	}
+
+	// init admin service
+	if err := g.adminStart(); err != nil {
+		return err
+	}
+
+	// dispatch gives the clients a room
+	if err := g.dispatchStart(); err != nil {
+		return err
+	}
+
+	// the streams push messages through it
+	if err := g.deliverStart(); err != nil {
+		return err
+	}
 
-	// init addmin service
-	go adminStart()
+	// watch the streams and the rooms, and register this room
+	if err := g.registryStart(ctx); err != nil {
+		return err
+	}
 
 	// start the monitors
-	monitorsStart()
+	g.monitorsStart(ctx)
+
+	addRunning(g)
+
+	return nil
+}
+
+// abortStart closes the listeners and the servers started by a failed Start
+func (g *Gate) abortStart() {
+	for _, l := range g.listeners {
+		if err := l.p.Close(); err != nil {
+			g.logger.Warn("close listener error", zap.Error(err), zap.String("listener", l.name))
+		}
+		if l.certs != nil {
+			l.certs.stop()
+		}
+	}
+	g.listeners = nil
+
+	g.stop()
 }
@@ -1,41 +1,240 @@
 package gate
 
 import (
-	"reflect"
+	"context"
+	"net"
 	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+	"github.com/aiyun/gomqtt/registry"
+	"github.com/uber-go/zap"
 )
 
+// the logs are not checked in tests
+var testLogger = zap.New(zap.NewJSONEncoder(), zap.DiscardOutput)
+
+// testGate returns a gateway of the config, an empty one if it's nil, nothing is started
+func testGate(t *testing.T, conf *Config) *Gate {
+	if conf == nil {
+		conf = &Config{}
+	}
+
+	g, err := New(Options{Config: conf, Logger: testLogger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
 func TestNew(t *testing.T) {
+	invalidAcl := &Config{}
+	invalidAcl.Acl.Default = "maybe"
+
+	invalidHook := &Config{}
+	invalidHook.Hook.Chain = []string{"nope"}
+
 	tests := []struct {
-		name string
-		want *Gate
+		name    string
+		conf    *Config
+		wantErr bool
 	}{
-	// TODO: Add test cases.
+		{"empty", &Config{}, false},
+		{"invalid acl default", invalidAcl, true},
+		{"invalid hook", invalidHook, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := New(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("New() = %v, want %v", got, tt.want)
+			g, err := New(Options{Config: tt.conf, Logger: testLogger})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && g.Config() != tt.conf {
+				t.Errorf("Config() = %v, want the config of the options", g.Config())
 			}
 		})
 	}
+
+	if _, err := New(Options{ConfigFile: "testdata/none.toml", Logger: testLogger}); err == nil {
+		t.Errorf("New() with a missing config file succeeded")
+	}
+}
+
+// startTestGate starts a gateway with a tcp listener and the admin api on random ports
+func startTestGate(t *testing.T) (*Gate, string) {
+	conf := &Config{}
+	conf.Provider = []*ListenerConf{{Protocol: "tcp", Addr: "127.0.0.1:0"}}
+	conf.Admin.Addr = "127.0.0.1:0"
+	conf.Drain.Window = 1
+
+	g := testGate(t, conf)
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Shutdown(context.Background()) })
+
+	return g, g.listeners[0].p.(*TcpProvider).ln.Addr().String()
+}
+
+func mqttConnect(t *testing.T, addr, clientID string) net.Conn {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := proto.NewConnectPacket()
+	cp.SetVersion(4)
+	cp.SetCleanSession(true)
+	cp.SetKeepAlive(60)
+	cp.SetClientId([]byte(clientID))
+	if err := service.WritePacket(c, cp); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	p, _, _, err := service.ReadPacket(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack, ok := p.(*proto.ConnackPacket); !ok || ack.ReturnCode() != proto.ConnectionAccepted {
+		t.Fatalf("CONNECT reply = %v, want an accepted CONNACK", p)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	return c
+}
+
+func TestGate_Start_failed(t *testing.T) {
+	// the admin addr is taken after the listener is started
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+
+	conf := &Config{}
+	conf.Provider = []*ListenerConf{{Protocol: "tcp", Addr: addr}}
+	conf.Admin.Addr = taken.Addr().String()
+
+	g := testGate(t, conf)
+	if err := g.Start(context.Background()); err == nil {
+		g.Shutdown(context.Background())
+		t.Fatal("Start() with a taken admin addr succeeded")
+	}
+
+	// the listener started before is closed
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("the listener of the failed gateway is still open: %v", err)
+	}
+	ln.Close()
 }
 
 func TestGate_Start(t *testing.T) {
-	type args struct {
-		isStatic bool
+	// two gateways in one process don't share the connections
+	g1, addr1 := startTestGate(t)
+	g2, addr2 := startTestGate(t)
+
+	c1 := mqttConnect(t, addr1, "dev1")
+	defer c1.Close()
+	c2 := mqttConnect(t, addr2, "dev1")
+	defer c2.Close()
+
+	// the connection is saved right after CONNACK
+	deadline := time.Now().Add(time.Second)
+	for (g1.conns.len() == 0 || g2.conns.len() == 0) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
 	}
-	tests := []struct {
-		name string
-		g    *Gate
-		args args
-	}{
-	// TODO: Add test cases.
+	if g1.conns.byClient("dev1") == nil || g2.conns.byClient("dev1") == nil {
+		t.Fatalf("the client isn't online on both gateways")
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			g := &Gate{}
-			g.Start(tt.args.isStatic)
-		})
+	if g1.conns.len() != 1 || g2.conns.len() != 1 {
+		t.Errorf("conns = %d and %d, want 1 on each gateway", g1.conns.len(), g2.conns.len())
+	}
+
+	if err := g1.Start(context.Background()); err != errStarted {
+		t.Errorf("Start() again error = %v, want %v", err, errStarted)
+	}
+
+	// shutting down one gateway leaves the other one serving
+	if err := g1.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c1.Read(make([]byte, 1)); err == nil {
+		t.Errorf("the connection of the stopped gateway is still open")
+	}
+	if g2.conns.len() != 1 {
+		t.Errorf("conns of the other gateway = %d, want 1", g2.conns.len())
+	}
+	if _, err := net.Dial("tcp", addr1); err == nil {
+		t.Errorf("the listener of the stopped gateway still accepts")
+	}
+}
+
+// waitFor polls cond for a second
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestGate_registry(t *testing.T) {
+	reg := registry.NewMemory()
+	defer reg.Close()
+
+	conf := &Config{}
+	conf.Etcd.Streams = "/gomqtt/streams"
+	conf.Etcd.Rooms = "/gomqtt/rooms"
+	conf.Admin.Addr = "127.0.0.1:0"
+	conf.Drain.Window = 1
+
+	g, err := New(Options{Config: conf, Logger: testLogger, Registry: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeStreams(t, g)
+
+	ctx := context.Background()
+	if err := g.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream registered after the gateway starts is used
+	reg.Register(ctx, &registry.Service{Name: conf.Etcd.Streams, Key: "s1", Addr: "127.0.0.1:8991"})
+	if !waitFor(func() bool { r, err := g.streams.get("bob"); return err == nil && r.addr == "127.0.0.1:8991" }) {
+		t.Errorf("the registered stream isn't used")
+	}
+
+	// the room is registered, so dispatch sends the clients here
+	if !waitFor(func() bool { return len(g.rooms.members()) == 1 }) {
+		t.Fatalf("rooms = %v, want this room", g.rooms.members())
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rooms, err := reg.Watch(wctx, conf.Etcd.Rooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-rooms; ev.Type != registry.EventAdd || ev.Key != g.host() {
+		t.Fatalf("event = %+v, want the add of %s", ev, g.host())
+	}
+
+	// and deregistered when shutting down, the shared registry keeps working
+	if err := g.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ev := <-rooms; ev.Type != registry.EventRemove || ev.Key != g.host() {
+		t.Errorf("event = %+v, want the remove of %s", ev, g.host())
 	}
 }
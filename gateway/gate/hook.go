@@ -0,0 +1,286 @@
+package gate
+
+import (
+	"errors"
+	"sync"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/zap"
+)
+
+// Hook is a plugin called at the points of the connection and message lifecycle.
+// The hooks run in the order of [hook] chain, the first error stops the chain.
+// Embed HookBase to implement only some of the callbacks.
+//
+// The errors are mapped to the MQTT responses. OnConnect and OnAuthenticated reply the
+// proto.ConnackCode, or not authorized for other errors. OnSubscribe returns 0x80 in SUBACK.
+// OnPublish acks the message dropped by ErrHookDrop, other errors aren't acked and close the
+// connection, so the client reconnects and sends the message again.
+// OnDeliver acks ErrHookDrop as delivered and other errors as undelivered to the stream.
+// Any error of OnWill discards the will.
+type Hook interface {
+	// OnConnect is called with CONNECT before authentication
+	OnConnect(c *HookClient, cp *proto.ConnectPacket) error
+	// OnAuthenticated is called after the authenticators accept the client
+	OnAuthenticated(c *HookClient) error
+	// OnSubscribe can lower the granted qos
+	OnSubscribe(c *HookClient, filter string, qos byte) (byte, error)
+	// OnPublish can change the message, changing the topic redirects it
+	OnPublish(c *HookClient, m *HookMessage) error
+	// OnDeliver is called before a message from the streams is queued to the client
+	OnDeliver(c *HookClient, m *HookMessage) error
+	// OnDisconnect is called after an authenticated connection is closed
+	OnDisconnect(c *HookClient, reason string)
+	// OnWill can change the will message before it's published
+	OnWill(c *HookClient, m *HookMessage) error
+}
+
+var (
+	// ErrHookDrop drops the message silently
+	ErrHookDrop = errors.New("hook: drop")
+	// ErrHookDisconnect closes the connection
+	ErrHookDisconnect = errors.New("hook: disconnect")
+)
+
+// HookClient is the connection seen by the hooks
+type HookClient struct {
+	ID       int
+	IP       string
+	Listener string
+	// nil before authentication
+	Cred *Credential
+}
+
+// HookMessage is the message passed to the hooks, the qos of the messages of the clients can't be changed
+type HookMessage struct {
+	Topic   string
+	Payload []byte
+	Qos     byte
+	Retain  bool
+}
+
+// HookBase does nothing, embed it in the hooks
+type HookBase struct{}
+
+func (HookBase) OnConnect(c *HookClient, cp *proto.ConnectPacket) error { return nil }
+func (HookBase) OnAuthenticated(c *HookClient) error                    { return nil }
+func (HookBase) OnSubscribe(c *HookClient, filter string, qos byte) (byte, error) {
+	return qos, nil
+}
+func (HookBase) OnPublish(c *HookClient, m *HookMessage) error { return nil }
+func (HookBase) OnDeliver(c *HookClient, m *HookMessage) error { return nil }
+func (HookBase) OnDisconnect(c *HookClient, reason string)     {}
+func (HookBase) OnWill(c *HookClient, m *HookMessage) error    { return nil }
+
+// HookFactory builds a hook from conf, the config being loaded, which isn't Gate.Config yet on reload
+type HookFactory func(g *Gate, conf *Config) (Hook, error)
+
+var (
+	hookLock      sync.Mutex
+	hookFactories = map[string]HookFactory{
+		"log_payload":   newPayloadLogHook,
+		"topic_rewrite": newRewriteHook,
+	}
+)
+
+// RegisterHook adds a hook which can be named in [hook] chain, call it before starting the gateway
+func RegisterHook(name string, f HookFactory) {
+	hookLock.Lock()
+	hookFactories[name] = f
+	hookLock.Unlock()
+}
+
+type namedHook struct {
+	name string
+	Hook
+}
+
+// hookChain is replaced as a whole on reload
+type hookChain struct {
+	hooks  []namedHook
+	logger zap.Logger
+}
+
+// newHookChain builds the named hooks with the settings in conf
+func (g *Gate) newHookChain(conf *Config, names []string) (*hookChain, error) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+
+	hc := &hookChain{logger: g.logger}
+	for _, name := range names {
+		f, ok := hookFactories[name]
+		if !ok {
+			return nil, errors.New("invalid hook: " + name)
+		}
+
+		h, err := f(g, conf)
+		if err != nil {
+			return nil, err
+		}
+		hc.hooks = append(hc.hooks, namedHook{name, h})
+	}
+
+	return hc, nil
+}
+
+func (g *Gate) getHooks() *hookChain {
+	return g.hooks.Load().(*hookChain)
+}
+
+func (ci *connInfo) hookClient() *HookClient {
+	hc := &HookClient{ID: ci.id, IP: ci.ip, Cred: ci.cred}
+	if ci.l != nil {
+		hc.Listener = ci.l.name
+	}
+	return hc
+}
+
+// connackError maps the errors of OnConnect and OnAuthenticated
+func connackError(err error) proto.ConnackCode {
+	if code, ok := err.(proto.ConnackCode); ok {
+		return code
+	}
+	return proto.ErrNotAuthorized
+}
+
+func (hc *hookChain) onConnect(ci *connInfo) proto.ConnackCode {
+	if len(hc.hooks) == 0 {
+		return proto.ConnectionAccepted
+	}
+
+	c := ci.hookClient()
+	for _, h := range hc.hooks {
+		if err := h.OnConnect(c, ci.cp); err != nil {
+			hc.logger.Info("connect rejected by hook", zap.String("hook", h.name), zap.Int("cid", ci.id), zap.Error(err))
+			return connackError(err)
+		}
+	}
+	return proto.ConnectionAccepted
+}
+
+func (hc *hookChain) onAuthenticated(ci *connInfo) proto.ConnackCode {
+	if len(hc.hooks) == 0 {
+		return proto.ConnectionAccepted
+	}
+
+	c := ci.hookClient()
+	for _, h := range hc.hooks {
+		if err := h.OnAuthenticated(c); err != nil {
+			hc.logger.Info("client rejected by hook", zap.String("hook", h.name), zap.Int("cid", ci.id), zap.Error(err))
+			return connackError(err)
+		}
+	}
+	return proto.ConnectionAccepted
+}
+
+func (hc *hookChain) onSubscribe(ci *connInfo, filter string, qos byte) (byte, error) {
+	if len(hc.hooks) == 0 {
+		return qos, nil
+	}
+
+	c := ci.hookClient()
+	for _, h := range hc.hooks {
+		q, err := h.OnSubscribe(c, filter, qos)
+		if err != nil {
+			hc.logger.Info("subscribe rejected by hook", zap.String("hook", h.name), zap.Int("cid", ci.id), zap.String("topic", filter), zap.Error(err))
+			return proto.QosFailure, err
+		}
+
+		// the hooks can only lower it
+		if q < qos {
+			qos = q
+		}
+	}
+	return qos, nil
+}
+
+// onMessage runs one of the message callbacks
+func (hc *hookChain) onMessage(ci *connInfo, m *HookMessage, call func(Hook, *HookClient, *HookMessage) error) error {
+	c := ci.hookClient()
+	for _, h := range hc.hooks {
+		if err := call(h.Hook, c, m); err != nil {
+			hc.logger.Debug("message stopped by hook", zap.String("hook", h.name), zap.Int("cid", ci.id), zap.String("topic", m.Topic), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+func (hc *hookChain) onPublish(ci *connInfo, p *proto.PublishPacket) (*proto.PublishPacket, error) {
+	if len(hc.hooks) == 0 {
+		return p, nil
+	}
+
+	m := hookMessage(p)
+	if err := hc.onMessage(ci, m, Hook.OnPublish); err != nil {
+		return nil, err
+	}
+
+	// qos of the client's message is kept, it decides the ack
+	m.Qos = p.QoS()
+	return m.packet()
+}
+
+func (hc *hookChain) onDeliver(ci *connInfo, p *proto.PublishPacket) (*proto.PublishPacket, error) {
+	if len(hc.hooks) == 0 {
+		return p, nil
+	}
+
+	m := hookMessage(p)
+	if err := hc.onMessage(ci, m, Hook.OnDeliver); err != nil {
+		return nil, err
+	}
+
+	// qos 2 isn't supported by the gateway
+	if m.Qos > 1 {
+		m.Qos = 1
+	}
+	return m.packet()
+}
+
+func (hc *hookChain) onWill(ci *connInfo, p *proto.PublishPacket) (*proto.PublishPacket, error) {
+	if len(hc.hooks) == 0 {
+		return p, nil
+	}
+
+	m := hookMessage(p)
+	if err := hc.onMessage(ci, m, Hook.OnWill); err != nil {
+		return nil, err
+	}
+	return m.packet()
+}
+
+func (hc *hookChain) onDisconnect(ci *connInfo) {
+	if len(hc.hooks) == 0 || ci.cred == nil {
+		return
+	}
+
+	c := ci.hookClient()
+	for _, h := range hc.hooks {
+		h.OnDisconnect(c, ci.closeReason)
+	}
+}
+
+func hookMessage(p *proto.PublishPacket) *HookMessage {
+	return &HookMessage{
+		Topic:   string(p.Topic()),
+		Payload: p.Payload(),
+		Qos:     p.QoS(),
+		Retain:  p.Retain(),
+	}
+}
+
+// packet validates the message changed by the hooks
+func (m *HookMessage) packet() (*proto.PublishPacket, error) {
+	p := proto.NewPublishPacket()
+	if err := p.SetTopic([]byte(m.Topic)); err != nil {
+		return nil, err
+	}
+	if err := p.SetQoS(m.Qos); err != nil {
+		return nil, err
+	}
+	p.SetRetain(m.Retain)
+	p.SetPayload(m.Payload)
+
+	return p, nil
+}
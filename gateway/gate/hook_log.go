@@ -0,0 +1,77 @@
+package gate
+
+import (
+	"encoding/hex"
+	"unicode/utf8"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/zap"
+)
+
+// the bytes logged of each payload by default
+const defaultLogMaxBytes = 256
+
+// payloadLogHook logs the messages of the topics in [hook] log_topics
+type payloadLogHook struct {
+	HookBase
+	topics []string
+	max    int
+	logger zap.Logger
+}
+
+func newPayloadLogHook(g *Gate, conf *Config) (Hook, error) {
+	h := &payloadLogHook{topics: conf.Hook.LogTopics, max: conf.Hook.LogMaxBytes, logger: g.Logger()}
+	if h.max <= 0 {
+		h.max = defaultLogMaxBytes
+	}
+	return h, nil
+}
+
+// empty topics match everything
+func (h *payloadLogHook) match(topic string) bool {
+	if len(h.topics) == 0 {
+		return true
+	}
+
+	for _, f := range h.topics {
+		if proto.TopicMatch(f, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *payloadLogHook) log(msg string, c *HookClient, m *HookMessage) {
+	if !h.match(m.Topic) {
+		return
+	}
+
+	var user string
+	if c.Cred != nil {
+		user = c.Cred.Username
+	}
+	h.logger.Info(msg, zap.Int("cid", c.ID), zap.String("user", user), zap.String("topic", m.Topic),
+		zap.Int("qos", int(m.Qos)), zap.Int("size", len(m.Payload)), zap.String("payload", payloadString(m.Payload, h.max)))
+}
+
+func (h *payloadLogHook) OnPublish(c *HookClient, m *HookMessage) error {
+	h.log("publish payload", c, m)
+	return nil
+}
+
+func (h *payloadLogHook) OnDeliver(c *HookClient, m *HookMessage) error {
+	h.log("deliver payload", c, m)
+	return nil
+}
+
+// payloadString returns the text payload as it is, and the binary one in hex
+func payloadString(b []byte, max int) string {
+	if len(b) > max {
+		b = b[:max]
+	}
+
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return hex.EncodeToString(b)
+}
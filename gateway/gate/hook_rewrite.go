@@ -0,0 +1,109 @@
+package gate
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+// RewriteRule rewrites the topics matching From to To, {1}, {2}... in To are replaced by
+// the levels matched by the wildcards of From in order
+type RewriteRule struct {
+	From string
+	To   string
+}
+
+// rewriteHook redirects the published messages and the wills, the first matching rule is used
+type rewriteHook struct {
+	HookBase
+	rules []*RewriteRule
+}
+
+func newRewriteHook(g *Gate, conf *Config) (Hook, error) {
+	rules := conf.Hook.Rewrite
+	for i, r := range rules {
+		if r.From == "" || r.To == "" || strings.ContainsAny(r.To, "+#") {
+			return nil, errors.New("invalid topic rewrite rule " + strconv.Itoa(i))
+		}
+	}
+
+	return &rewriteHook{rules: rules}, nil
+}
+
+func (h *rewriteHook) rewrite(m *HookMessage) {
+	for _, r := range h.rules {
+		if t, ok := rewriteTopic(r.From, r.To, m.Topic); ok {
+			m.Topic = t
+			return
+		}
+	}
+}
+
+func (h *rewriteHook) OnPublish(c *HookClient, m *HookMessage) error {
+	h.rewrite(m)
+	return nil
+}
+
+func (h *rewriteHook) OnWill(c *HookClient, m *HookMessage) error {
+	h.rewrite(m)
+	return nil
+}
+
+// rewriteTopic returns the new topic if the topic matches from
+func rewriteTopic(from, to, topic string) (string, bool) {
+	if !proto.TopicMatch(from, topic) {
+		return "", false
+	}
+
+	fs := strings.Split(from, "/")
+	ts := strings.Split(topic, "/")
+
+	var vals []string
+	for i, f := range fs {
+		switch f {
+		case "+":
+			vals = append(vals, ts[i])
+		case "#":
+			// "a/#" matches "a" too
+			var v string
+			if i < len(ts) {
+				v = strings.Join(ts[i:], "/")
+			}
+			vals = append(vals, v)
+		}
+	}
+
+	return fillTopic(to, vals), true
+}
+
+// fillTopic replaces {n} in to by vals[n-1], the index is parsed as a whole so {1} isn't taken from {10}.
+// The placeholders out of range are kept as they are.
+func fillTopic(to string, vals []string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(to, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(to[i:], '}')
+		if j < 0 {
+			break
+		}
+
+		n, err := strconv.Atoi(to[i+1 : i+j])
+		if err != nil || n < 1 || n > len(vals) || to[i+1] == '+' {
+			b.WriteString(to[:i+1])
+			to = to[i+1:]
+			continue
+		}
+
+		b.WriteString(to[:i])
+		b.WriteString(vals[n-1])
+		to = to[i+j+1:]
+	}
+
+	b.WriteString(to)
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package gate
+
+import (
+	"errors"
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+// testHook returns err from the callbacks, and rewrites the published topic to "moved" when err is nil
+type testHook struct {
+	HookBase
+	err error
+}
+
+func (h *testHook) OnConnect(c *HookClient, cp *proto.ConnectPacket) error { return h.err }
+
+func (h *testHook) OnSubscribe(c *HookClient, filter string, qos byte) (byte, error) {
+	return 0, h.err
+}
+
+func (h *testHook) OnPublish(c *HookClient, m *HookMessage) error {
+	m.Topic = "moved"
+	return h.err
+}
+
+func useHooks(t *testing.T, g *Gate, hs ...Hook) {
+	hc := &hookChain{logger: testLogger}
+	for _, h := range hs {
+		hc.hooks = append(hc.hooks, namedHook{"test", h})
+	}
+	g.hooks.Store(hc)
+}
+
+func Test_newHookChain(t *testing.T) {
+	g := testGate(t, nil)
+
+	hc, err := g.newHookChain(g.Config(), []string{"log_payload", "topic_rewrite"})
+	if err != nil || len(hc.hooks) != 2 {
+		t.Fatalf("newHookChain() = %v, %v", hc, err)
+	}
+
+	if _, err := g.newHookChain(g.Config(), []string{"nope"}); err == nil {
+		t.Errorf("newHookChain() with an unknown hook succeeded")
+	}
+
+	RegisterHook("test", func(g *Gate, conf *Config) (Hook, error) { return &testHook{}, nil })
+	if _, err := g.newHookChain(g.Config(), []string{"test"}); err != nil {
+		t.Errorf("newHookChain() with a registered hook error = %v", err)
+	}
+}
+
+func Test_hookChain_onConnect(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want proto.ConnackCode
+	}{
+		{"accepted", nil, proto.ConnectionAccepted},
+		{"connack code", proto.ErrBadUsernameOrPassword, proto.ErrBadUsernameOrPassword},
+		{"other error", errors.New("banned"), proto.ErrNotAuthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc := &hookChain{logger: testLogger, hooks: []namedHook{{"test", &testHook{err: tt.err}}}}
+			ci := &connInfo{g: testGate(t, nil), id: newCID(), cp: proto.NewConnectPacket()}
+			if got := hc.onConnect(ci); got != tt.want {
+				t.Errorf("onConnect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_publish_hooks(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+		want    string
+	}{
+		{"redirected", nil, false, "Publish moved"},
+		{"dropped", ErrHookDrop, false, ""},
+		{"disconnect", ErrHookDisconnect, true, ""},
+		// closed, so the client sends it again after reconnecting
+		{"failed", errors.New("hook down"), true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useHooks(t, g, &testHook{err: tt.err})
+			fs := fakeStreams(t, g, "s1:9000")["s1:9000"]
+
+			ci := &connInfo{g: g, id: newCID(), cred: &Credential{Username: "bob"}}
+			p := proto.NewPublishPacket()
+			p.SetTopic([]byte("a/b"))
+			p.SetPayload([]byte("x"))
+
+			if err := publish(ci, p); (err != nil) != tt.wantErr {
+				t.Fatalf("publish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != "" && !fs.has(tt.want) {
+				t.Errorf("calls = %v, want %q", fs.calls, tt.want)
+			}
+			if tt.want == "" && len(fs.calls) != 0 {
+				t.Errorf("calls = %v, want none", fs.calls)
+			}
+			if tt.name == "failed" && ci.closeReason != closeHookFailed {
+				t.Errorf("closeReason = %q, want %q", ci.closeReason, closeHookFailed)
+			}
+		})
+	}
+}
+
+// clearHook empties the delivered payload
+type clearHook struct{ HookBase }
+
+func (clearHook) OnDeliver(c *HookClient, m *HookMessage) error {
+	m.Payload = nil
+	return nil
+}
+
+func Test_onDeliver_emptyPayload(t *testing.T) {
+	hc := &hookChain{logger: testLogger, hooks: []namedHook{{"clear", clearHook{}}}}
+	ci := &connInfo{g: testGate(t, nil), id: newCID()}
+	p := proto.NewPublishPacket()
+	p.SetTopic([]byte("a/b"))
+	p.SetPayload([]byte("x"))
+
+	// the empty message is still delivered
+	got, err := hc.onDeliver(ci, p)
+	if err != nil || len(got.Payload()) != 0 {
+		t.Errorf("onDeliver() = %v, %v, want empty payload", got, err)
+	}
+}
+
+func Test_rewriteTopic(t *testing.T) {
+	tests := []struct {
+		from   string
+		to     string
+		topic  string
+		want   string
+		wantOk bool
+	}{
+		{"old/+/data", "new/{1}", "old/dev1/data", "new/dev1", true},
+		{"x/+/+/#", "y/{2}/{1}/{3}", "x/a/b/c/d", "y/b/a/c/d", true},
+		{"a/#", "b/{1}", "a", "b/", true},
+		{"a/b", "c", "a/b", "c", true},
+		{"a/+", "b/{1}", "c/d", "", false},
+		// {10} isn't {1} followed by 0
+		{"+/+/+/+/+/+/+/+/+/+", "{10}/{1}", "a/b/c/d/e/f/g/h/i/j", "j/a", true},
+		{"a/+", "b/{2}/{x}/{1", "a/c", "b/{2}/{x}/{1", true},
+	}
+	for _, tt := range tests {
+		got, ok := rewriteTopic(tt.from, tt.to, tt.topic)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("rewriteTopic(%q, %q, %q) = %q, %v, want %q, %v", tt.from, tt.to, tt.topic, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func Test_payloadString(t *testing.T) {
+	tests := []struct {
+		payload []byte
+		max     int
+		want    string
+	}{
+		{[]byte("hello"), 10, "hello"},
+		{[]byte("hello"), 2, "he"},
+		{[]byte{0xff, 0x01}, 10, "ff01"},
+	}
+	for _, tt := range tests {
+		if got := payloadString(tt.payload, tt.max); got != tt.want {
+			t.Errorf("payloadString(%v, %d) = %q, want %q", tt.payload, tt.max, got, tt.want)
+		}
+	}
+}
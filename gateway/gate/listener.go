@@ -0,0 +1,248 @@
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/uber-go/atomic"
+)
+
+// listener is a running provider with its own settings and stats
+type listener struct {
+	g    *Gate
+	name string
+	p    Provider
+
+	// replaced on reload
+	sync.RWMutex
+	conf    *ListenerConf
+	auths   *authChain
+	trusted []*net.IPNet
+
+	// server certificates of tls and wss
+	certs *certStore
+
+	stats *listenerStats
+}
+
+type listenerStats struct {
+	// current connections
+	conns *atomic.Int64
+	// accepted connections in total
+	accepted *atomic.Int64
+	// connections rejected by MaxConns
+	rejected *atomic.Int64
+	// CONNECTs rejected by the authenticators
+	authFailed *atomic.Int64
+}
+
+func listenerName(lc *ListenerConf) string {
+	if lc.Name != "" {
+		return lc.Name
+	}
+
+	return lc.Protocol + "@" + lc.Addr
+}
+
+func newListener(g *Gate, lc *ListenerConf) (*listener, error) {
+	l := &listener{
+		g:    g,
+		name: listenerName(lc),
+		conf: lc,
+		stats: &listenerStats{
+			conns:      atomic.NewInt64(0),
+			accepted:   atomic.NewInt64(0),
+			rejected:   atomic.NewInt64(0),
+			authFailed: atomic.NewInt64(0),
+		},
+	}
+
+	for _, o := range g.listeners {
+		if o.name == l.name {
+			return nil, errors.New("duplicate listener name")
+		}
+	}
+
+	if lc.Addr == "" {
+		return nil, errors.New("empty listener addr")
+	}
+
+	if len(lc.AuthChain) > 0 {
+		ac, err := g.newAuthChain(g.Config(), lc.AuthChain)
+		if err != nil {
+			return nil, err
+		}
+		l.auths = ac
+	}
+
+	trusted, err := proxyTrusted(lc)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: %v", l.name, err)
+	}
+	l.trusted = trusted
+
+	switch lc.Protocol {
+	case "tcp", "tls", "unix":
+		l.p = &TcpProvider{l: l}
+	case "ws", "wss":
+		l.p = &WsProvider{l: l}
+	default:
+		return nil, fmt.Errorf("invalid protocol: %s", lc.Protocol)
+	}
+
+	if lc.Protocol == "tls" || lc.Protocol == "wss" {
+		l.certs = &certStore{logger: g.logger}
+	}
+
+	return l, nil
+}
+
+func (l *listener) getConf() *ListenerConf {
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.conf
+}
+
+// authChain returns the chain of this listener, or the one in [auth] if it's not set
+func (l *listener) authChain() *authChain {
+	l.RLock()
+	defer l.RUnlock()
+
+	if l.auths == nil {
+		return l.g.getAuths()
+	}
+	return l.auths
+}
+
+func (l *listener) proxyTrusted() []*net.IPNet {
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.trusted
+}
+
+// wrap enables the PROXY protocol on the raw listener
+func (l *listener) wrap(ln net.Listener) net.Listener {
+	if !l.getConf().ProxyProtocol {
+		return ln
+	}
+
+	return &proxyListener{Listener: ln, l: l}
+}
+
+// acquire counts a new connection, false is returned if MaxConns is reached
+func (l *listener) acquire() bool {
+	n := l.stats.conns.Inc()
+	if max := l.getConf().MaxConns; max > 0 && n > int64(max) {
+		l.stats.conns.Dec()
+		l.stats.rejected.Inc()
+		return false
+	}
+
+	l.stats.accepted.Inc()
+	return true
+}
+
+func (l *listener) release() {
+	l.stats.conns.Dec()
+}
+
+// listenerUpdate is the reloaded settings of a listener, the updates of all the listeners
+// are built before any of them is applied
+type listenerUpdate struct {
+	l       *listener
+	conf    *ListenerConf
+	auths   *authChain
+	trusted []*net.IPNet
+	// nil if the provider hasn't loaded its certificates
+	certs *certSet
+}
+
+// prepare builds the reloaded settings, the ones needing a restart are checked by diffConfig
+func (l *listener) prepare(conf *Config, lc *ListenerConf) (*listenerUpdate, error) {
+	u := &listenerUpdate{l: l, conf: lc}
+
+	trusted, err := proxyTrusted(lc)
+	if err != nil {
+		return nil, fmt.Errorf("listener %s: %v", l.name, err)
+	}
+	u.trusted = trusted
+
+	if len(lc.AuthChain) > 0 {
+		if u.auths, err = l.g.newAuthChain(conf, lc.AuthChain); err != nil {
+			return nil, fmt.Errorf("listener %s: %v", l.name, err)
+		}
+	}
+
+	// new certificates are used by the new connections
+	if l.certs != nil && l.certs.get() != nil {
+		if u.certs, err = loadCertSet(lc); err != nil {
+			return nil, fmt.Errorf("listener %s: %v", l.name, err)
+		}
+	}
+
+	return u, nil
+}
+
+func (u *listenerUpdate) apply() {
+	l := u.l
+	l.Lock()
+	l.conf = u.conf
+	l.auths = u.auths
+	l.trusted = u.trusted
+	l.Unlock()
+
+	if u.certs != nil {
+		l.certs.store(u.certs)
+	}
+}
+
+// prepareListeners builds the updates of the running listeners from conf
+func (g *Gate) prepareListeners(conf *Config) ([]*listenerUpdate, error) {
+	var us []*listenerUpdate
+	for _, l := range g.listeners {
+		for _, lc := range conf.Provider {
+			if listenerName(lc) != l.name {
+				continue
+			}
+
+			u, err := l.prepare(conf, lc)
+			if err != nil {
+				return nil, err
+			}
+			us = append(us, u)
+			break
+		}
+	}
+
+	return us, nil
+}
+
+// listenerInfo is the stats reported by the admin api
+type listenerInfo struct {
+	Name       string `json:"name"`
+	Protocol   string `json:"protocol"`
+	Addr       string `json:"addr"`
+	MaxConns   int    `json:"max_conns"`
+	Conns      int64  `json:"conns"`
+	Accepted   int64  `json:"accepted"`
+	Rejected   int64  `json:"rejected"`
+	AuthFailed int64  `json:"auth_failed"`
+}
+
+func (l *listener) info() listenerInfo {
+	lc := l.getConf()
+	return listenerInfo{
+		Name:       l.name,
+		Protocol:   lc.Protocol,
+		Addr:       lc.Addr,
+		MaxConns:   lc.MaxConns,
+		Conns:      l.stats.conns.Load(),
+		Accepted:   l.stats.accepted.Load(),
+		Rejected:   l.stats.rejected.Load(),
+		AuthFailed: l.stats.authFailed.Load(),
+	}
+}
@@ -0,0 +1,92 @@
+package gate
+
+import "testing"
+
+func Test_newListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		lc      *ListenerConf
+		want    string
+		wantErr bool
+	}{
+		{"tcp", &ListenerConf{Protocol: "tcp", Addr: ":1883"}, "tcp@:1883", false},
+		{"named", &ListenerConf{Name: "devices", Protocol: "tls", Addr: ":8883"}, "devices", false},
+		{"unix", &ListenerConf{Protocol: "unix", Addr: "/tmp/mqtt.sock"}, "unix@/tmp/mqtt.sock", false},
+		{"ws", &ListenerConf{Protocol: "wss", Addr: ":8084"}, "wss@:8084", false},
+		{"invalid protocol", &ListenerConf{Protocol: "udp", Addr: ":1883"}, "", true},
+		{"no addr", &ListenerConf{Protocol: "tcp"}, "", true},
+		{"invalid auth chain", &ListenerConf{Protocol: "tcp", Addr: ":1884", AuthChain: []string{"ldap"}}, "", true},
+	}
+	g := testGate(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newListener(g, tt.lc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && l.name != tt.want {
+				t.Errorf("newListener() name = %q, want %q", l.name, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newListener_duplicate(t *testing.T) {
+	g := testGate(t, nil)
+	l, err := newListener(g, &ListenerConf{Protocol: "tcp", Addr: ":1883"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g.listeners = append(g.listeners, l)
+
+	if _, err := newListener(g, &ListenerConf{Protocol: "tcp", Addr: ":1883"}); err == nil {
+		t.Errorf("newListener() accepted a duplicate name")
+	}
+}
+
+func Test_listener_acquire(t *testing.T) {
+	l, err := newListener(testGate(t, nil), &ListenerConf{Protocol: "tcp", Addr: ":1883", MaxConns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.acquire() || !l.acquire() {
+		t.Fatalf("acquire() failed under MaxConns")
+	}
+	if l.acquire() {
+		t.Errorf("acquire() succeeded over MaxConns")
+	}
+
+	l.release()
+	if !l.acquire() {
+		t.Errorf("acquire() failed after release")
+	}
+
+	info := l.info()
+	if info.Conns != 2 || info.Accepted != 3 || info.Rejected != 1 {
+		t.Errorf("info() = %+v, want 2 conns, 3 accepted, 1 rejected", info)
+	}
+}
+
+func Test_listener_authChain(t *testing.T) {
+	g := testGate(t, nil)
+	auths := g.getAuths()
+
+	l, err := newListener(g, &ListenerConf{Protocol: "tcp", Addr: ":1883"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.authChain() != auths {
+		t.Errorf("authChain() should fall back to the gateway chain")
+	}
+
+	u, err := l.prepare(g.Config(), &ListenerConf{Protocol: "tcp", Addr: ":1883", AuthChain: []string{"cert"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.apply()
+	if ac := l.authChain(); ac == auths || len(ac.auths) != 1 {
+		t.Errorf("authChain() = %v, want the listener chain", ac)
+	}
+}
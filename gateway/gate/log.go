@@ -1,16 +1,57 @@
 package gate
 
 import (
-	"log"
+	"fmt"
 	"os"
 	"strings"
 
 	"github.com/uber-go/zap"
 )
 
-var Logger zap.Logger
+// newLogger writes json to stdout in debug, or appends it to lp
+func newLogger(lp string, lv string, isDebug bool) (zap.Logger, error) {
+	level := parseLevel(lv)
 
-func InitLogger(lp string, lv string, isDebug bool) {
+	if isDebug {
+		return zap.New(
+			zap.NewJSONEncoder(
+				zap.RFC3339NanoFormatter("@timestamp"), // human-readable timestamps
+				zap.MessageKey("@message"),             // customize the message key
+				zap.LevelString("@level"),              // stringify the log level
+			),
+			zap.AddCaller(),
+			level,
+		), nil
+	}
+
+	f, err := os.OpenFile(lp, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	return zap.New(
+		zap.NewJSONEncoder(
+			zap.RFC3339Formatter("@timestamp"), // human-readable timestamps
+			zap.MessageKey("@message"),         // customize the message key
+			zap.LevelString("@level"),          // stringify the log level
+		),
+		zap.Output(f),
+		zap.AddCaller(),
+		level,
+	), nil
+}
+
+// checkLevel rejects the unknown levels on reload, they are debug when starting
+func checkLevel(lv string) error {
+	switch strings.ToLower(lv) {
+	case "", "debug", "info", "warn", "error", "fatal":
+		return nil
+	}
+	return fmt.Errorf("invalid log level: %s", lv)
+}
+
+// parseLevel returns debug for the unknown levels
+func parseLevel(lv string) zap.Level {
 	var level zap.Level
 
 	switch strings.ToLower(lv) {
@@ -28,31 +69,5 @@ func InitLogger(lp string, lv string, isDebug bool) {
 		level = zap.DebugLevel
 	}
 
-	if isDebug {
-		Logger = zap.New(
-			zap.NewJSONEncoder(
-				zap.RFC3339NanoFormatter("@timestamp"), // human-readable timestamps
-				zap.MessageKey("@message"),             // customize the message key
-				zap.LevelString("@level"),              // stringify the log level
-			),
-			zap.AddCaller(),
-			level,
-		)
-	} else {
-		f, err := os.OpenFile(lp, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0666)
-		if err != nil {
-			log.Panic(err)
-		}
-
-		Logger = zap.New(
-			zap.NewJSONEncoder(
-				zap.RFC3339Formatter("@timestamp"), // human-readable timestamps
-				zap.MessageKey("@message"),         // customize the message key
-				zap.LevelString("@level"),          // stringify the log level
-			),
-			zap.Output(f),
-			zap.AddCaller(),
-			level,
-		)
-	}
+	return level
 }
@@ -0,0 +1,247 @@
+package gate
+
+import (
+	"io/ioutil"
+	"sync"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics exported on /metrics of the admin server, the go runtime and process metrics
+// (goroutines, open fds) come with the default registry
+var (
+	packetsIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "packets_received_total",
+		Help:      "Packets received from the clients by type.",
+	}, []string{"type"})
+
+	packetsOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "packets_sent_total",
+		Help:      "Packets sent to the clients by type.",
+	}, []string{"type"})
+
+	bytesIn = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "bytes_received_total",
+		Help:      "Bytes of the packets received from the clients.",
+	})
+
+	bytesOut = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "bytes_sent_total",
+		Help:      "Bytes of the packets sent to the clients.",
+	})
+
+	writeQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "write_queue_depth",
+		Help:      "Packets waiting to be written to the clients.",
+	})
+
+	ruleMatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "rule_matched_total",
+		Help:      "Messages matched by the rules.",
+	}, []string{"rule"})
+
+	ruleErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "rule_payload_errors_total",
+		Help:      "Messages whose payload isn't json, so the where expression of the rule can't be evaluated.",
+	}, []string{"rule"})
+
+	ruleActionFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gomqtt",
+		Subsystem: "gateway",
+		Name:      "rule_action_failures_total",
+		Help:      "Failed actions of the rules by type.",
+	}, []string{"rule", "action"})
+)
+
+// the started gateways, the collector reports the sessions and listeners of all of them
+var running struct {
+	sync.Mutex
+	gates map[*Gate]struct{}
+}
+
+func addRunning(g *Gate) {
+	running.Lock()
+	if running.gates == nil {
+		running.gates = make(map[*Gate]struct{})
+	}
+	running.gates[g] = struct{}{}
+	running.Unlock()
+}
+
+func removeRunning(g *Gate) {
+	running.Lock()
+	delete(running.gates, g)
+	running.Unlock()
+}
+
+func runningGates() []*Gate {
+	running.Lock()
+	defer running.Unlock()
+
+	gates := make([]*Gate, 0, len(running.gates))
+	for g := range running.gates {
+		gates = append(gates, g)
+	}
+	return gates
+}
+
+func init() {
+	prometheus.MustRegister(packetsIn, packetsOut, bytesIn, bytesOut, writeQueue, ruleMatched, ruleErrors, ruleActionFailed, newGateCollector())
+}
+
+func countIn(p proto.Packet, n int) {
+	packetsIn.WithLabelValues(p.Name()).Inc()
+	bytesIn.Add(float64(n))
+
+	stats.bytesReceived.Add(int64(n))
+	if p.Type() == proto.PUBLISH {
+		stats.msgsReceived.Inc()
+	}
+}
+
+func countOut(p proto.Packet) {
+	packetsOut.WithLabelValues(p.Name()).Inc()
+	bytesOut.Add(float64(p.Len()))
+
+	stats.bytesSent.Add(int64(p.Len()))
+	if p.Type() == proto.PUBLISH {
+		stats.msgsSent.Inc()
+	}
+}
+
+// gateCollector exports the counters in stats and of the listeners when scraping
+type gateCollector struct {
+	sessions      *prometheus.Desc
+	conns         *prometheus.Desc
+	accepted      *prometheus.Desc
+	rejected      *prometheus.Desc
+	authFailures  *prometheus.Desc
+	keepalive     *prometheus.Desc
+	writeTimeout  *prometheus.Desc
+	routeFailed   *prometheus.Desc
+	publishLost   *prometheus.Desc
+	wills         *prometheus.Desc
+	aclDenied     *prometheus.Desc
+	limitActions  *prometheus.Desc
+	admitRejected *prometheus.Desc
+}
+
+func newGateCollector() *gateCollector {
+	name := func(n string) string {
+		return prometheus.BuildFQName("gomqtt", "gateway", n)
+	}
+
+	return &gateCollector{
+		sessions:      prometheus.NewDesc(name("sessions"), "Online sessions.", nil, nil),
+		conns:         prometheus.NewDesc(name("connections"), "Open connections by listener.", []string{"listener"}, nil),
+		accepted:      prometheus.NewDesc(name("connections_accepted_total"), "Connections accepted by listener.", []string{"listener"}, nil),
+		rejected:      prometheus.NewDesc(name("connections_rejected_total"), "Connections rejected by the max conns of the listener.", []string{"listener"}, nil),
+		authFailures:  prometheus.NewDesc(name("auth_failures_total"), "CONNECT rejected by the authenticators by listener.", []string{"listener"}, nil),
+		keepalive:     prometheus.NewDesc(name("keepalive_timeouts_total"), "Connections closed by keepalive timeout.", nil, nil),
+		writeTimeout:  prometheus.NewDesc(name("write_timeouts_total"), "Connections closed because the client didn't read in time.", nil, nil),
+		routeFailed:   prometheus.NewDesc(name("route_failures_total"), "Messages failed to route to stream.", nil, nil),
+		publishLost:   prometheus.NewDesc(name("publish_lost_total"), "QoS 0 messages lost because routing to stream or a publish hook failed.", nil, nil),
+		wills:         prometheus.NewDesc(name("wills_total"), "Will messages by result.", []string{"result"}, nil),
+		aclDenied:     prometheus.NewDesc(name("acl_denied_total"), "Requests denied by the acl.", []string{"access"}, nil),
+		limitActions:  prometheus.NewDesc(name("client_limit_actions_total"), "Actions taken by the client limits.", []string{"action"}, nil),
+		admitRejected: prometheus.NewDesc(name("admission_rejected_total"), "Connections rejected by the admission control.", []string{"reason"}, nil),
+	}
+}
+
+func (gc *gateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gc.sessions
+	ch <- gc.conns
+	ch <- gc.accepted
+	ch <- gc.rejected
+	ch <- gc.authFailures
+	ch <- gc.keepalive
+	ch <- gc.writeTimeout
+	ch <- gc.routeFailed
+	ch <- gc.publishLost
+	ch <- gc.wills
+	ch <- gc.aclDenied
+	ch <- gc.limitActions
+	ch <- gc.admitRejected
+}
+
+func (gc *gateCollector) Collect(ch chan<- prometheus.Metric) {
+	counter := func(d *prometheus.Desc, v int64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, float64(v), labels...)
+	}
+
+	// the listeners of the gateways in one process may have the same name, they are summed up
+	sessions := 0
+	var names []string
+	ls := make(map[string]*listenerInfo)
+	for _, g := range runningGates() {
+		sessions += g.conns.len()
+
+		for _, l := range g.listeners {
+			li := ls[l.name]
+			if li == nil {
+				li = &listenerInfo{}
+				ls[l.name] = li
+				names = append(names, l.name)
+			}
+			li.Conns += l.stats.conns.Load()
+			li.Accepted += l.stats.accepted.Load()
+			li.Rejected += l.stats.rejected.Load()
+			li.AuthFailed += l.stats.authFailed.Load()
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(gc.sessions, prometheus.GaugeValue, float64(sessions))
+
+	for _, name := range names {
+		li := ls[name]
+		ch <- prometheus.MustNewConstMetric(gc.conns, prometheus.GaugeValue, float64(li.Conns), name)
+		counter(gc.accepted, li.Accepted, name)
+		counter(gc.rejected, li.Rejected, name)
+		counter(gc.authFailures, li.AuthFailed, name)
+	}
+
+	counter(gc.keepalive, stats.keepaliveTimeouts.Load())
+	counter(gc.writeTimeout, stats.writeTimeouts.Load())
+	counter(gc.routeFailed, stats.routeFailed.Load())
+	counter(gc.publishLost, stats.publishLost.Load())
+
+	counter(gc.wills, stats.willPublished.Load(), "published")
+	counter(gc.wills, stats.willDiscarded.Load(), "discarded")
+	counter(gc.wills, stats.willFailed.Load(), "failed")
+
+	counter(gc.aclDenied, stats.aclPubDenied.Load(), "pub")
+	counter(gc.aclDenied, stats.aclSubDenied.Load(), "sub")
+
+	counter(gc.limitActions, stats.limitThrottled.Load(), limitThrottle)
+	counter(gc.limitActions, stats.limitDropped.Load(), limitDrop)
+	counter(gc.limitActions, stats.limitDisconnected.Load(), limitDisconnect)
+
+	counter(gc.admitRejected, stats.rejectedRate.Load(), rejectRate)
+	counter(gc.admitRejected, stats.rejectedGlobal.Load(), rejectGlobal)
+	counter(gc.admitRejected, stats.rejectedIP.Load(), rejectIP)
+	counter(gc.admitRejected, stats.rejectedDraining.Load(), rejectDraining)
+}
+
+// openFDs counts the open file descriptors of the process, -1 if it's unknown(not linux)
+func openFDs() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	return len(fds)
+}
@@ -0,0 +1,42 @@
+package gate
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+func Test_metrics(t *testing.T) {
+	countIn(proto.NewPingreqPacket(), 2)
+	countOut(proto.NewPingrespPacket())
+	stats.keepaliveTimeouts.Inc()
+
+	g := testGate(t, nil)
+	rec := adminRequest(t, g, "GET", "/metrics", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("code = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`gomqtt_gateway_packets_received_total{type="PINGREQ"}`,
+		`gomqtt_gateway_packets_sent_total{type="PINGRESP"}`,
+		`gomqtt_gateway_bytes_received_total`,
+		`gomqtt_gateway_keepalive_timeouts_total`,
+		`gomqtt_gateway_admission_rejected_total{reason="accept rate"}`,
+		`gomqtt_gateway_write_queue_depth 0`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func Test_openFDs(t *testing.T) {
+	if n := openFDs(); n == 0 {
+		t.Errorf("openFDs() = 0, the process has stdin at least")
+	}
+}
@@ -1,28 +1,60 @@
 package gate
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
+	"context"
 	"runtime"
-	"strings"
 	"time"
 
 	"github.com/uber-go/zap"
 )
 
-func monitorLeaking() {
+func (g *Gate) monitorLeaking(ctx context.Context) {
 	for {
-		r := exec.Command("/bin/sh", "-c", fmt.Sprintf("lsof -n |awk '{print $2}'|sort|uniq -c | grep %v", os.Getpid()))
-		v, _ := r.Output()
-		fds := strings.Split(string(v), " ")[2]
-		Logger.Debug("goroutine和fd数目", zap.Int("goroutine", runtime.NumGoroutine()), zap.String("fd", fds))
-		time.Sleep(10 * time.Second)
+		g.logger.Debug("goroutine和fd数目", zap.Int("goroutine", runtime.NumGoroutine()), zap.Int("fd", openFDs()))
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-ctx.Done():
+			return
+		}
 	}
 
 }
 
-func monitorsStart() {
+func (g *Gate) monitorStats(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(60 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+
+		g.logger.Info("gateway stats", zap.Int64("will_published", stats.willPublished.Load()),
+			zap.Int64("will_discarded", stats.willDiscarded.Load()), zap.Int64("will_failed", stats.willFailed.Load()),
+			zap.Int64("acl_pub_denied", stats.aclPubDenied.Load()), zap.Int64("acl_sub_denied", stats.aclSubDenied.Load()),
+			zap.Int64("rejected_rate", stats.rejectedRate.Load()), zap.Int64("rejected_global", stats.rejectedGlobal.Load()),
+			zap.Int64("rejected_ip", stats.rejectedIP.Load()), zap.Int64("rejected_draining", stats.rejectedDraining.Load()),
+			zap.Int64("limit_throttled", stats.limitThrottled.Load()), zap.Int64("limit_dropped", stats.limitDropped.Load()),
+			zap.Int64("limit_disconnected", stats.limitDisconnected.Load()), zap.Int64("keepalive_timeouts", stats.keepaliveTimeouts.Load()),
+			zap.Int64("write_timeouts", stats.writeTimeouts.Load()), zap.Int64("route_failed", stats.routeFailed.Load()),
+			zap.Int64("publish_lost", stats.publishLost.Load()))
+
+		for _, l := range g.listeners {
+			g.logger.Info("listener stats", zap.String("name", l.name), zap.Int64("conns", l.stats.conns.Load()),
+				zap.Int64("accepted", l.stats.accepted.Load()), zap.Int64("rejected", l.stats.rejected.Load()),
+				zap.Int64("auth_failed", l.stats.authFailed.Load()))
+		}
+	}
+}
+
+// monitorsStart runs the monitors until ctx is done
+func (g *Gate) monitorsStart(ctx context.Context) {
 	// monitor the goroutine and file descriptor leaking
-	go monitorLeaking()
+	go g.monitorLeaking(ctx)
+
+	// report the runtime counters
+	go g.monitorStats(ctx)
+
+	// publish the statistics to $SYS
+	go g.sysPublish(ctx)
 }
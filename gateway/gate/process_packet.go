@@ -6,7 +6,6 @@ import (
 	"fmt"
 
 	proto "github.com/aiyun/gomqtt/mqtt/protocol"
-	"github.com/aiyun/gomqtt/mqtt/service"
 	"github.com/uber-go/zap"
 )
 
@@ -15,7 +14,9 @@ func processPacket(ci *connInfo, pt proto.Packet) error {
 
 	switch p := pt.(type) {
 	case *proto.DisconnectPacket: // recv Disconnect
-		Logger.Info("Disconnect")
+		ci.g.logger.Info("Disconnect")
+		ci.closing(closeDisconnect)
+		discardWill(ci)
 		err = errors.New("recv disconnect packet")
 
 	case *proto.PublishPacket: // recv publish
@@ -31,10 +32,10 @@ func processPacket(ci *connInfo, pt proto.Packet) error {
 		err = unsubscribe(ci, p)
 
 	case *proto.PingreqPacket:
-		Logger.Info("recv ping req")
+		ci.g.logger.Info("recv ping req")
 		pingReq(ci)
 	default:
-		Logger.Warn("recv invalid packet type", zap.String("invalid_type", fmt.Sprintf("%T", pt)), zap.Int("cid", ci.id))
+		ci.g.logger.Warn("recv invalid packet type", zap.String("invalid_type", fmt.Sprintf("%T", pt)), zap.Int("cid", ci.id))
 	}
 
 	return err
@@ -42,5 +43,5 @@ func processPacket(ci *connInfo, pt proto.Packet) error {
 
 func pingReq(ci *connInfo) {
 	pb := proto.NewPingrespPacket()
-	service.WritePacket(ci.c, pb)
+	ci.write(pb)
 }
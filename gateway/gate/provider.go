@@ -1,21 +1,29 @@
 package gate
 
+import "fmt"
+
+// Provider serves a listener, Start returns after listening and the connections are served in the background
 type Provider interface {
-	Start()
+	Start() error
 	Close() error
 }
 
-func providersStart() {
-	for _, v := range Conf.Provider.Invoked {
-		switch v {
-		case "tcp":
-			tp := &TcpProvider{}
-			go tp.Start()
-		case "websocket":
-			wp := &WsProvider{}
-			go wp.Start()
-		default:
-			Logger.Fatal("invalid provider,please check your configuration")
+func (g *Gate) providersStart() error {
+	for _, lc := range g.Config().Provider {
+		l, err := newListener(g, lc)
+		if err != nil {
+			return fmt.Errorf("invalid provider %s, please check your configuration: %v", listenerName(lc), err)
+		}
+
+		if err := l.p.Start(); err != nil {
+			// the certificate files may be watched already
+			if l.certs != nil {
+				l.certs.stop()
+			}
+			return fmt.Errorf("start provider %s: %v", l.name, err)
 		}
+		g.listeners = append(g.listeners, l)
 	}
+
+	return nil
 }
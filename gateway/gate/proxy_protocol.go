@@ -0,0 +1,226 @@
+package gate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the header must arrive in time, or the connection will be closed
+const proxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("proxy protocol: invalid header")
+)
+
+// proxyListener parses the PROXY protocol header of the connections from the trusted sources
+type proxyListener struct {
+	net.Listener
+	l *listener
+}
+
+// proxyTrusted parses the trusted CIDRs of the listener. Without them anyone could send a header
+// and spoof the ip used by the limits, acls and logs, only the local peers of a unix socket are trusted.
+func proxyTrusted(lc *ListenerConf) ([]*net.IPNet, error) {
+	if lc.ProxyProtocol && len(lc.ProxyTrusted) == 0 && lc.Protocol != "unix" {
+		return nil, errors.New("proxy_protocol needs proxy_trusted")
+	}
+	return parseCIDRs(lc.ProxyTrusted)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func (pl *proxyListener) Accept() (net.Conn, error) {
+	c, err := pl.Listener.Accept()
+	if err != nil {
+		return c, err
+	}
+
+	if !pl.trust(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	// the header is read in the goroutine of the connection, so a slow client can't block the accepting
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// empty trusted list means trusting only the local peers of a unix socket
+func (pl *proxyListener) trust(addr net.Addr) bool {
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		// unix socket, the peer is local
+		return true
+	}
+
+	trusted := pl.l.proxyTrusted()
+
+	for _, n := range trusted {
+		if n.Contains(ta.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyConn replaces the remote address with the one in the header
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// init reads the header once, it's triggered by serve logging the remote address before setting any deadline
+func (pc *proxyConn) init() {
+	pc.once.Do(func() {
+		pc.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		pc.remote, pc.err = readProxyHeader(pc.r)
+		pc.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (pc *proxyConn) Read(b []byte) (int, error) {
+	pc.init()
+	if pc.err != nil {
+		return 0, pc.err
+	}
+
+	return pc.r.Read(b)
+}
+
+func (pc *proxyConn) RemoteAddr() net.Addr {
+	pc.init()
+	if pc.remote == nil {
+		return pc.Conn.RemoteAddr()
+	}
+
+	return pc.remote
+}
+
+// readProxyHeader reads a v1 or v2 header, nil address is returned if the header carries no address(LOCAL or UNKNOWN)
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// the shortest header is "PROXY UNKNOWN\r\n", longer than the v2 signature
+	sig, err := r.Peek(len(proxyV2Sig))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(sig, proxyV2Sig):
+		return readProxyV2(r)
+	case bytes.HasPrefix(sig, proxyV1Prefix):
+		return readProxyV1(r)
+	}
+
+	return nil, errProxyHeader
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+
+		// 107 bytes at most
+		if len(line) >= 107 {
+			return nil, errProxyHeader
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errProxyHeader
+	}
+
+	port, err := strconv.Atoi(fields[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	if hdr[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	switch hdr[12] & 0x0f {
+	case 0: // LOCAL, health checks of the balancer
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+
+	switch hdr[13] >> 4 {
+	case 1: // AF_INET
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	// AF_UNSPEC and AF_UNIX, the addresses are ignored
+	return nil, nil
+}
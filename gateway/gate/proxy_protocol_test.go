@@ -0,0 +1,121 @@
+package gate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func proxyV2Header(cmd, fam byte, body []byte) []byte {
+	b := append([]byte{}, proxyV2Sig...)
+	b = append(b, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(body)))
+	return append(b, body...)
+}
+
+func Test_readProxyHeader(t *testing.T) {
+	v4 := []byte{1, 2, 3, 4, 10, 0, 0, 1, 0x1f, 0x90, 0x07, 0x5b}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(v6[32:], 5555)
+
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 1.2.3.4 10.0.0.1 8080 1883\r\n"), "1.2.3.4:8080", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 ::1 5555 1883\r\n"), "[2001:db8::1]:5555", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 bad ip", []byte("PROXY TCP4 1.2.3 10.0.0.1 8080 1883\r\n"), "", true},
+		{"v1 no crlf", []byte("PROXY TCP4 1.2.3.4 10.0.0.1 8080 1883\n"), "", true},
+		{"v2 inet", proxyV2Header(1, 0x11, v4), "1.2.3.4:8080", false},
+		{"v2 inet6", proxyV2Header(1, 0x21, v6), "[2001:db8::1]:5555", false},
+		{"v2 local", proxyV2Header(0, 0, nil), "", false},
+		{"v2 short", proxyV2Header(1, 0x11, v4[:8]), "", true},
+		{"no header", []byte{0x10, 0x0c, 0x00, 0x04, 'M', 'Q', 'T', 'T', 4, 2, 0, 60, 0, 0}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the mqtt payload follows the header
+			r := bufio.NewReader(bytes.NewReader(append(tt.header, "payload"...)))
+			got, err := readProxyHeader(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readProxyHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var addr string
+			if got != nil {
+				addr = got.String()
+			}
+			if addr != tt.want {
+				t.Errorf("readProxyHeader() = %q, want %q", addr, tt.want)
+			}
+
+			rest, _ := ioutil.ReadAll(r)
+			if string(rest) != "payload" {
+				t.Errorf("remaining = %q, want %q", rest, "payload")
+			}
+		})
+	}
+}
+
+func Test_proxyListener(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		want    string
+		wantErr bool
+	}{
+		// anyone could spoof the ip
+		{"empty", nil, "", true},
+		{"trusted", []string{"127.0.0.0/8"}, "1.2.3.4", false},
+		{"untrusted", []string{"10.0.0.0/8"}, "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := newListener(testGate(t, nil), &ListenerConf{Protocol: "tcp", Addr: "127.0.0.1:0", ProxyProtocol: true, ProxyTrusted: tt.trusted})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			raw, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln := l.wrap(raw)
+			defer ln.Close()
+
+			go func() {
+				c, err := net.Dial("tcp", raw.Addr().String())
+				if err != nil {
+					return
+				}
+				defer c.Close()
+
+				c.Write([]byte("PROXY TCP4 1.2.3.4 10.0.0.1 8080 1883\r\n"))
+				ioutil.ReadAll(c)
+			}()
+
+			c, err := ln.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if got := addrIP(c.RemoteAddr()); got != tt.want {
+				t.Errorf("RemoteAddr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
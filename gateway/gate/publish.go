@@ -1,20 +1,118 @@
 package gate
 
 import (
+	"errors"
+
 	proto "github.com/aiyun/gomqtt/mqtt/protocol"
-	"github.com/aiyun/gomqtt/mqtt/service"
+	rpc "github.com/aiyun/gomqtt/proto"
+	"github.com/corego/tools"
+	"github.com/uber-go/zap"
+)
+
+var (
+	errPubDenied     = errors.New("publish denied by acl")
+	errLimitExceeded = errors.New("client limit exceeded")
+	errRouteFailed   = errors.New("publish route failed")
 )
 
 func publish(ci *connInfo, p *proto.PublishPacket) error {
-	// need give back the ack
+	if act, reason := ci.limits.checkPublish(p, ci.g.maxPayload()); act != "" {
+		if onLimit(ci, act, reason) {
+			return errLimitExceeded
+		}
+		// dropped, the client still gets the ack
+		pubAck(ci, p)
+		return nil
+	}
+
+	// the hooks may redirect the message, the acl checks the final topic
+	msg, err := ci.g.getHooks().onPublish(ci, p)
+	switch {
+	case err == ErrHookDrop:
+		pubAck(ci, p)
+		return nil
+	case err == ErrHookDisconnect:
+		ci.closing(closeHook)
+		return err
+	case err != nil:
+		// no ack, the connection is closed so the client reconnects and sends it again,
+		// a qos 0 message is lost
+		if p.QoS() == 0 {
+			stats.publishLost.Inc()
+		}
+		ci.g.logger.Warn("publish hook error", zap.Error(err), zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(p.Topic())))
+		ci.closing(closeHookFailed)
+		return err
+	}
+
+	if !ci.g.aclCheck(ci.cred, aclPub, tools.Bytes2String(msg.Topic())) {
+		stats.aclPubDenied.Inc()
+		ci.g.logger.Info("publish denied", zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(msg.Topic())))
+
+		if ci.g.Config().Acl.PubDeny == "disconnect" {
+			ci.closing(closeAclDenied)
+			return errPubDenied
+		}
+		// the message is dropped silently, the client still gets the ack
+	} else if !ci.g.getRules().apply(ci, msg) {
+		// dropped by a rule, the client still gets the ack
+	} else if err := ci.g.pubToStream(ci, msg); err != nil {
+		// no ack, the connection is closed so the client reconnects and sends it again,
+		// a qos 0 message is lost
+		stats.routeFailed.Inc()
+		if p.QoS() == 0 {
+			stats.publishLost.Inc()
+		}
+		ci.g.logger.Warn("publish error", zap.Error(err), zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(msg.Topic())))
+		ci.closing(closeRouteFailed)
+		return errRouteFailed
+	} else {
+		ev := newEvent(eventPublished, ci)
+		ev.Topic = string(msg.Topic())
+		ev.Payload = msg.Payload()
+		ev.Qos = msg.QoS()
+		ev.Retain = msg.Retain()
+		ci.g.emitEvent(ev)
+	}
+
+	pubAck(ci, p)
+	return nil
+}
+
+// pubAck gives back the ack of a qos 1 message
+func pubAck(ci *connInfo, p *proto.PublishPacket) {
 	if p.QoS() == 1 {
 		pb := proto.NewPubackPacket()
 		pb.SetPacketID(p.PacketID())
-		service.WritePacket(ci.c, pb)
+		ci.write(pb)
 	}
-	return nil
 }
 
+// puback acks the delivery to the stream
 func puback(ci *connInfo, p *proto.PubackPacket) error {
+	ci.acked(p.PacketID())
 	return nil
 }
+
+// pubToStream routes the message to the stream owning the topic, ci is nil for the messages of the gateway itself
+func (g *Gate) pubToStream(ci *connInfo, p *proto.PublishPacket) error {
+	topic := tools.Bytes2String(p.Topic())
+	c, err := g.streams.get(topicKey(topic))
+	if err != nil {
+		return err
+	}
+
+	pm := &rpc.PubMsg{
+		Tp:     p.Topic(),
+		Pl:     p.Payload(),
+		Qos:    int32(p.QoS()),
+		Retain: p.Retain(),
+		Gip:    g.gatewayAddr(),
+	}
+	if ci != nil && ci.cred != nil {
+		pm.An = ci.cred.Username
+		pm.Cid = ci.cred.ClientID
+	}
+
+	return c.Publish(pm)
+}
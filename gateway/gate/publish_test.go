@@ -26,3 +26,27 @@ func Test_publish(t *testing.T) {
 		})
 	}
 }
+
+func Test_publish_routeFailed(t *testing.T) {
+	g := testGate(t, nil)
+	useHooks(t, g)
+	// no stream to route to
+	fakeStreams(t, g)
+
+	ci := &connInfo{g: g, id: newCID(), cred: &Credential{Username: "bob"}}
+	p := proto.NewPublishPacket()
+	p.SetTopic([]byte("a/b"))
+	p.SetPayload([]byte("x"))
+
+	lost := stats.publishLost.Load()
+	// the connection is closed, so the client sends it again after reconnecting
+	if err := publish(ci, p); err != errRouteFailed {
+		t.Fatalf("publish() error = %v, want %v", err, errRouteFailed)
+	}
+	if ci.closeReason != closeRouteFailed {
+		t.Errorf("closeReason = %q, want %q", ci.closeReason, closeRouteFailed)
+	}
+	if stats.publishLost.Load() != lost+1 {
+		t.Errorf("the lost qos 0 message isn't counted")
+	}
+}
@@ -2,8 +2,10 @@ package gate
 
 import (
 	"fmt"
+	"net"
 	"time"
 
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
 	"github.com/aiyun/gomqtt/mqtt/service"
 	"github.com/uber-go/zap"
 )
@@ -19,17 +21,37 @@ func recvPacket(ci *connInfo) {
 		// We need to considering about the network delay,so here allows 10 seconds delay.
 		ci.c.SetReadDeadline(time.Now().Add(wait))
 
-		pt, buf, n, err := service.ReadPacket(ci.c)
+		pt, buf, n, err := service.ReadPacketLimit(ci.c, ci.limits.maxRead(ci.g.maxPayload()))
+		if err == service.ErrPacketTooLarge {
+			// the payload is skipped, so the connection is still usable
+			if onLimit(ci, ci.limits.payloadAction(), "payload too large") {
+				break
+			}
+			// dropped, the client still gets the ack
+			pubAck(ci, pt.(*proto.PublishPacket))
+			continue
+		}
+
 		if err != nil {
-			Logger.Warn("Read packet error", zap.Error(err), zap.String("buf", fmt.Sprintf("%v", buf)), zap.Int("bytes", n), zap.Int("cid", ci.id))
+			ci.g.logger.Warn("Read packet error", zap.Error(err), zap.String("buf", fmt.Sprintf("%v", buf)), zap.Int("bytes", n), zap.Int("cid", ci.id))
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				ci.closing(closeKeepalive)
+				stats.keepaliveTimeouts.Inc()
+			} else {
+				ci.closing(closeReadError)
+			}
 			break
 		}
 
+		countIn(pt, n)
+		ci.g.tracePacket(ci, traceIn, pt)
+
 		err = processPacket(ci, pt)
 		if err != nil {
+			ci.closing(closeProtocol)
 			break
 		}
 
-		ci.inCount++
+		ci.inCount.Inc()
 	}
 }
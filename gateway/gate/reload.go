@@ -0,0 +1,215 @@
+package gate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/uber-go/zap"
+)
+
+// restartError is returned by reloadConfig when the changed settings are only read at start
+type restartError struct {
+	fields []string
+}
+
+func (e *restartError) Error() string {
+	return "restart required to change " + strings.Join(e.fields, ", ")
+}
+
+// RequestReload asks the gateway running with the config file to reload it by POST /reload,
+// the admin addr and token are read from the file. The response body is returned.
+func RequestReload(configFile string) (string, error) {
+	conf, err := readConfig(Options{ConfigFile: configFile})
+	if err != nil {
+		return "", err
+	}
+
+	addr := conf.Admin.Addr
+	if addr == "" {
+		addr = defaultAdminAddr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	req, err := http.NewRequest("POST", "http://"+net.JoinHostPort(host, port)+"/reload", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.Admin.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return string(body), fmt.Errorf("reload: %s", resp.Status)
+	}
+	return string(body), nil
+}
+
+// reloadResult is the response of /reload
+type reloadResult struct {
+	// the changed settings, all applied on success and none on error
+	Changed []string `json:"changed"`
+	// the changed settings needing a restart
+	Restart []string `json:"restart,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// configField is a part of the config compared on reload
+type configField struct {
+	name string
+	// it's only read when the gateway starts
+	restart bool
+	get     func(c *Config) interface{}
+}
+
+// the sections compared on reload, the [[provider]] ones are compared by listener
+var configFields = []configField{
+	{"common.version", false, func(c *Config) interface{} { return c.Common.Version }},
+	{"common.log_level", false, func(c *Config) interface{} { return c.Common.LogLevel }},
+	{"common.is_debug", true, func(c *Config) interface{} { return c.Common.IsDebug }},
+	{"common.log_path", true, func(c *Config) interface{} { return c.Common.LogPath }},
+	{"auth", false, func(c *Config) interface{} { return c.Auth }},
+	{"acl", false, func(c *Config) interface{} { return c.Acl }},
+	{"etcd", true, func(c *Config) interface{} { return c.Etcd }},
+	{"mqtt", false, func(c *Config) interface{} { return c.Mqtt }},
+	{"client_limit", false, func(c *Config) interface{} { return c.ClientLimit }},
+	{"limit", false, func(c *Config) interface{} { return c.Limit }},
+	{"drain", false, func(c *Config) interface{} { return c.Drain }},
+	{"dispatch", true, func(c *Config) interface{} { return c.Dispatch }},
+	{"stream", false, func(c *Config) interface{} { return c.Stream }},
+	{"deliver.addr", true, func(c *Config) interface{} { return c.Deliver.Addr }},
+	{"deliver.queue_size", false, func(c *Config) interface{} { return c.Deliver.QueueSize }},
+	{"hook", false, func(c *Config) interface{} { return c.Hook }},
+	{"webhook", false, func(c *Config) interface{} { return c.Webhook }},
+	{"rule", false, func(c *Config) interface{} { return c.Rule }},
+	{"admin.token", false, func(c *Config) interface{} { return c.Admin.Token }},
+	{"admin.trace_dir", false, func(c *Config) interface{} { return c.Admin.TraceDir }},
+	{"admin.addr", true, func(c *Config) interface{} { return c.Admin.Addr }},
+}
+
+// the settings of a listener read when the provider starts, the others are applied on reload
+var listenerFields = []struct {
+	name string
+	get  func(lc *ListenerConf) interface{}
+}{
+	{"protocol", func(lc *ListenerConf) interface{} { return lc.Protocol }},
+	{"addr", func(lc *ListenerConf) interface{} { return lc.Addr }},
+	{"proxy_protocol", func(lc *ListenerConf) interface{} { return lc.ProxyProtocol }},
+	{"tls_client_auth", func(lc *ListenerConf) interface{} { return lc.TlsClientAuth }},
+	{"tls_cas", func(lc *ListenerConf) interface{} { return lc.TlsCas }},
+	{"tls_crls", func(lc *ListenerConf) interface{} { return lc.TlsCrls }},
+	{"tls_watch", func(lc *ListenerConf) interface{} { return lc.TlsWatch }},
+	{"ws_path", func(lc *ListenerConf) interface{} { return lc.WsPath }},
+}
+
+// diffConfig returns the changed settings, and the ones of them needing a restart
+func diffConfig(old, conf *Config) (changed, restart []string) {
+	for _, f := range configFields {
+		if reflect.DeepEqual(f.get(old), f.get(conf)) {
+			continue
+		}
+
+		changed = append(changed, f.name)
+		if f.restart {
+			restart = append(restart, f.name)
+		}
+	}
+
+	// the listeners are matched by name
+	olds := make(map[string]*ListenerConf)
+	for _, lc := range old.Provider {
+		olds[listenerName(lc)] = lc
+	}
+
+	for _, lc := range conf.Provider {
+		name := "provider." + listenerName(lc)
+		o, ok := olds[listenerName(lc)]
+		if !ok {
+			changed = append(changed, name)
+			restart = append(restart, name)
+			continue
+		}
+		delete(olds, listenerName(lc))
+
+		if reflect.DeepEqual(o, lc) {
+			continue
+		}
+		changed = append(changed, name)
+		for _, f := range listenerFields {
+			if !reflect.DeepEqual(f.get(o), f.get(lc)) {
+				restart = append(restart, name+"."+f.name)
+			}
+		}
+	}
+
+	for _, lc := range old.Provider {
+		if _, ok := olds[listenerName(lc)]; ok {
+			name := "provider." + listenerName(lc)
+			changed = append(changed, name)
+			restart = append(restart, name)
+		}
+	}
+
+	return changed, restart
+}
+
+// reloadConfig reads the config again, and applies the changes if all of them can be applied
+// without restarting and the new plugins and listener settings are built, otherwise nothing changes
+func (g *Gate) reloadConfig() ([]string, error) {
+	// one reload at a time, so the config and the plugins are from the same file
+	g.reloading.Lock()
+	defer g.reloading.Unlock()
+
+	conf, err := readConfig(g.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, restart := diffConfig(g.Config(), conf)
+	if len(restart) > 0 {
+		return changed, &restartError{fields: restart}
+	}
+
+	if err := checkLevel(conf.Common.LogLevel); err != nil {
+		return changed, err
+	}
+
+	p, err := g.newPlugins(conf)
+	if err != nil {
+		return changed, err
+	}
+
+	us, err := g.prepareListeners(conf)
+	if err != nil {
+		p.rules.close()
+		return changed, err
+	}
+
+	g.conf.Store(conf)
+	g.logger.SetLevel(parseLevel(conf.Common.LogLevel))
+	g.usePlugins(p)
+	g.streams.updateBreakers()
+	for _, u := range us {
+		u.apply()
+	}
+
+	g.logger.Info("config reloaded", zap.String("changed", strings.Join(changed, ", ")))
+	return changed, nil
+}
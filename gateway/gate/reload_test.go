@@ -0,0 +1,191 @@
+package gate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/uber-go/zap"
+)
+
+func Test_diffConfig(t *testing.T) {
+	base := func() *Config {
+		c := &Config{}
+		c.Common.LogLevel = "info"
+		c.Etcd.Addrs = []string{"127.0.0.1:2379"}
+		c.Provider = []*ListenerConf{{Protocol: "tcp", Addr: ":1883"}, {Name: "secure", Protocol: "tls", Addr: ":8883", TlsCert: "a.pem"}}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		change      func(c *Config)
+		wantChanged []string
+		wantRestart []string
+	}{
+		{"same", func(c *Config) {}, nil, nil},
+		{"reloadable", func(c *Config) {
+			c.Common.LogLevel = "warn"
+			c.Limit.MaxConns = 10
+			c.Acl.Default = "deny"
+			c.Provider[1].TlsCert = "b.pem"
+		}, []string{"common.log_level", "acl", "limit", "provider.secure"}, nil},
+		{"restart", func(c *Config) {
+			c.Etcd.Addrs = nil
+			c.Admin.Addr = ":9000"
+			c.Provider[1].Addr = ":8884"
+		}, []string{"etcd", "admin.addr", "provider.secure"}, []string{"etcd", "admin.addr", "provider.secure.addr"}},
+		{"listener added", func(c *Config) {
+			c.Provider = append(c.Provider, &ListenerConf{Protocol: "ws", Addr: ":8083"})
+		}, []string{"provider.ws@:8083"}, []string{"provider.ws@:8083"}},
+		{"listener removed", func(c *Config) {
+			c.Provider = c.Provider[1:]
+		}, []string{"provider.tcp@:1883"}, []string{"provider.tcp@:1883"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := base()
+			tt.change(conf)
+
+			changed, restart := diffConfig(base(), conf)
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(restart, tt.wantRestart) {
+				t.Errorf("restart = %v, want %v", restart, tt.wantRestart)
+			}
+		})
+	}
+}
+
+func TestGate_reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.toml")
+	write := func(s string) {
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+[common]
+log_level = "info"
+[etcd]
+addrs = ["127.0.0.1:2379"]
+[admin]
+token = "secret"
+`)
+	logger := zap.New(zap.NewJSONEncoder(), zap.DiscardOutput)
+	g, err := New(Options{ConfigFile: path, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		conf     string
+		wantCode int
+		wantRes  reloadResult
+		wantMax  int
+	}{
+		{"applied", `
+[common]
+log_level = "warn"
+[etcd]
+addrs = ["127.0.0.1:2379"]
+[limit]
+max_conns = 10
+`, http.StatusOK, reloadResult{Changed: []string{"common.log_level", "limit"}}, 10},
+		{"restart required", `
+[common]
+log_level = "warn"
+[etcd]
+addrs = ["127.0.0.1:2380"]
+[limit]
+max_conns = 20
+`, http.StatusConflict, reloadResult{Changed: []string{"etcd", "limit"}, Restart: []string{"etcd"}, Error: "restart required to change etcd"}, 10},
+		{"invalid", `
+[common]
+log_level = "warn"
+[etcd]
+addrs = ["127.0.0.1:2379"]
+[acl]
+default = "maybe"
+[limit]
+max_conns = 20
+`, http.StatusBadRequest, reloadResult{Changed: []string{"acl", "limit"}, Error: "load acl error: invalid acl default: maybe"}, 10},
+		{"unchanged", `
+[common]
+log_level = "warn"
+[etcd]
+addrs = ["127.0.0.1:2379"]
+[limit]
+max_conns = 10
+`, http.StatusOK, reloadResult{Changed: []string{}}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write(tt.conf + "[admin]\ntoken = \"secret\"\n")
+
+			rec := adminRequest(t, g, "POST", "/reload", "secret", "")
+			var res reloadResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode || !reflect.DeepEqual(res, tt.wantRes) {
+				t.Errorf("POST /reload = %d %+v, want %d %+v", rec.Code, res, tt.wantCode, tt.wantRes)
+			}
+
+			// nothing is applied when the reload fails
+			if got := g.Config().Limit.MaxConns; got != tt.wantMax {
+				t.Errorf("max_conns = %d, want %d", got, tt.wantMax)
+			}
+			if logger.Level() != zap.WarnLevel {
+				t.Errorf("log level = %v, want warn", logger.Level())
+			}
+		})
+	}
+}
+
+func TestRequestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gateway.toml")
+	if err := ioutil.WriteFile(path, []byte("[admin]\ntoken = \"secret\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(Options{ConfigFile: path, Logger: testLogger})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// it swaps the auth and acls, only the admins can do it
+	if rec := adminRequest(t, g, "GET", "/reload", "secret", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /reload code = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if rec := adminRequest(t, g, "POST", "/reload", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST /reload without token code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	srv := httptest.NewServer(g.newAdmin())
+	defer srv.Close()
+
+	// the config of "gateway reload" points to the admin server
+	client := filepath.Join(dir, "client.toml")
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	for _, tt := range []struct {
+		token   string
+		wantErr bool
+	}{{"secret", false}, {"wrong", true}} {
+		conf := "[admin]\naddr = \"" + addr + "\"\ntoken = \"" + tt.token + "\"\n"
+		if err := ioutil.WriteFile(client, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if body, err := RequestReload(client); (err != nil) != tt.wantErr {
+			t.Errorf("RequestReload() with token %q = %q, %v, wantErr %v", tt.token, body, err, tt.wantErr)
+		}
+	}
+}
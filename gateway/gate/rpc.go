@@ -2,114 +2,250 @@ package gate
 
 import (
 	"context"
-	"log"
+	"math/rand"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	rpc "github.com/aiyun/gomqtt/proto"
 	"github.com/uber-go/zap"
 )
 
+// default settings of the stream clients
+const (
+	defaultCallTimeout     = 3 * time.Second
+	defaultRetries         = 2
+	defaultBackoff         = 100 * time.Millisecond
+	defaultBreakerFailures = 5
+	defaultBreakerTimeout  = 10 * time.Second
+	defaultHealthInterval  = 5 * time.Second
+)
+
+// Rpc is the client of one stream
 type Rpc struct {
+	g      *Gate
+	addr   string
 	conn   *grpc.ClientConn
 	client rpc.RpcClient
+	health healthpb.HealthClient
+
+	breaker *breaker
+	stop    chan struct{}
 }
 
-func (r *Rpc) Init(addr string) {
+// newRpc connects to the stream in the background, the health check is started if conn isn't nil
+func newRpc(g *Gate, addr string) (*Rpc, error) {
 	conn, err := grpc.Dial(addr, grpc.WithInsecure())
 	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+		return nil, err
+	}
+
+	sc := g.streamConf()
+	r := &Rpc{
+		g:       g,
+		addr:    addr,
+		conn:    conn,
+		client:  rpc.NewRpcClient(conn),
+		health:  healthpb.NewHealthClient(conn),
+		breaker: newBreaker(sc.breakerFailures, sc.breakerTimeout),
+		stop:    make(chan struct{}),
+	}
+	go r.checkHealth()
+
+	return r, nil
+}
+
+func (r *Rpc) Close() error {
+	if r.stop != nil {
+		close(r.stop)
+	}
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// checkHealth trips the breaker when the stream isn't serving, so the calls fail fast
+func (r *Rpc) checkHealth() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(r.g.streamConf().healthInterval):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), r.g.streamConf().callTimeout)
+		resp, err := r.health.Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+
+		if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+			if !r.breaker.isOpen() {
+				r.g.logger.Warn("stream unhealthy", zap.String("addr", r.addr), zap.Error(err))
+			}
+			r.breaker.trip(time.Now())
+		}
 	}
+}
 
-	c := rpc.NewRpcClient(conn)
+// call runs fn with a deadline, the idempotent calls are retried with backoff on the transient errors
+func (r *Rpc) call(idempotent bool, fn func(ctx context.Context) error) error {
+	sc := r.g.streamConf()
+
+	var err error
+	for i := 0; ; i++ {
+		if r.breaker != nil {
+			if err := r.breaker.allow(time.Now()); err != nil {
+				return err
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), sc.callTimeout)
+		err = fn(ctx)
+		cancel()
+
+		if r.breaker != nil {
+			if err == nil || !transient(err) {
+				// the stream answered, even with an error
+				r.breaker.success()
+			} else {
+				r.breaker.failure(time.Now())
+			}
+		}
+
+		if err == nil || !idempotent || !transient(err) || i >= sc.retries {
+			return err
+		}
+
+		time.Sleep(backoff(sc.backoff, i))
+	}
+}
 
-	r.conn = conn
-	r.client = c
+// transient errors are worth retrying, the stream may be restarting or overloaded
+func transient(err error) bool {
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
 }
 
-func (r *Rpc) Close(addr string) {
-	r.conn.Close()
+// backoff doubles the base on each retry, with a jitter up to the half
+func backoff(base time.Duration, retry int) time.Duration {
+	d := base << uint(retry)
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
 }
 
-// 用户登录接口12
+// 用户登录接口
 func (r *Rpc) LogIn(acm *rpc.AccMsg) error {
-	req, err := r.client.LogIn(context.Background(), acm)
-	if err != nil {
-		Logger.Error("LogIn", zap.Error(err))
+	return r.call(true, func(ctx context.Context) error {
+		_, err := r.client.LogIn(ctx, acm)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
 }
 
+// 用户登出, 重试的LogOut可能晚于接管连接的LogIn到达, 所以不重试
 func (r *Rpc) LogOut(acm *rpc.AccMsg) error {
-	req, err := r.client.LogOut(context.Background(), acm)
-	if err != nil {
-		Logger.Error("LogOut", zap.Error(err))
+	return r.call(false, func(ctx context.Context) error {
+		_, err := r.client.LogOut(ctx, acm)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
 }
 
 // 用户订阅相关
 func (r *Rpc) Subscribe(tm *rpc.TcMsg) error {
-	req, err := r.client.Subscribe(context.Background(), tm)
-	if err != nil {
-		Logger.Error("Subscribe", zap.Error(err))
+	return r.call(true, func(ctx context.Context) error {
+		_, err := r.client.Subscribe(ctx, tm)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
 }
 
 func (r *Rpc) UnSubscribe(tm *rpc.TcMsg) error {
-	req, err := r.client.Subscribe(context.Background(), tm)
-	if err != nil {
-		Logger.Error("UnSubscribe", zap.Error(err))
+	return r.call(true, func(ctx context.Context) error {
+		_, err := r.client.UnSubscribe(ctx, tm)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
+}
+
+// 转发客户端发布的消息，重试可能导致重复投递，所以不重试
+func (r *Rpc) Publish(pm *rpc.PubMsg) error {
+	return r.call(false, func(ctx context.Context) error {
+		_, err := r.client.Publish(ctx, pm)
+		return err
+	})
 }
 
 // 推送接口
-func (r *Rpc) BPush(ctx context.Context, bm *rpc.BPushMsg) error {
-	req, err := r.client.BPush(context.Background(), bm)
-	if err != nil {
-		Logger.Error("BPush", zap.Error(err))
+func (r *Rpc) BPush(bm *rpc.BPushMsg) error {
+	return r.call(false, func(ctx context.Context) error {
+		_, err := r.client.BPush(ctx, bm)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
 }
 
-func (r *Rpc) SPush(ctx context.Context, sp *rpc.SPushMsg) error {
-	req, err := r.client.SPush(context.Background(), sp)
-	if err != nil {
-		Logger.Error("SPush", zap.Error(err))
+func (r *Rpc) SPush(sp *rpc.SPushMsg) error {
+	return r.call(false, func(ctx context.Context) error {
+		_, err := r.client.SPush(ctx, sp)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
 }
 
-func (r *Rpc) PChat(ctx context.Context, pm *rpc.PChatMsg) error {
-	req, err := r.client.PChat(context.Background(), pm)
-	if err != nil {
-		Logger.Error("PChat", zap.Error(err))
+func (r *Rpc) PChat(pm *rpc.PChatMsg) error {
+	return r.call(false, func(ctx context.Context) error {
+		_, err := r.client.PChat(ctx, pm)
 		return err
-	}
-	log.Println(req)
-	return nil
+	})
 }
 
-func (r *Rpc) GChat(ctx context.Context, gm *rpc.GChatMsg) error {
-	req, err := r.client.GChat(context.Background(), gm)
-	if err != nil {
-		Logger.Error("GChat", zap.Error(err))
+func (r *Rpc) GChat(gm *rpc.GChatMsg) error {
+	return r.call(false, func(ctx context.Context) error {
+		_, err := r.client.GChat(ctx, gm)
 		return err
+	})
+}
+
+// streamSettings is [stream] with the defaults applied
+type streamSettings struct {
+	callTimeout     time.Duration
+	retries         int
+	backoff         time.Duration
+	breakerFailures int
+	breakerTimeout  time.Duration
+	healthInterval  time.Duration
+}
+
+func (g *Gate) streamConf() streamSettings {
+	c := g.Config().Stream
+	s := streamSettings{
+		callTimeout:     time.Duration(c.CallTimeout) * time.Millisecond,
+		retries:         c.Retries,
+		backoff:         time.Duration(c.Backoff) * time.Millisecond,
+		breakerFailures: c.BreakerFailures,
+		breakerTimeout:  time.Duration(c.BreakerTimeout) * time.Second,
+		healthInterval:  time.Duration(c.HealthInterval) * time.Second,
+	}
+
+	if s.callTimeout <= 0 {
+		s.callTimeout = defaultCallTimeout
+	}
+	if s.retries < 0 {
+		s.retries = 0
+	} else if c.Retries == 0 {
+		s.retries = defaultRetries
 	}
-	log.Println(req)
-	return nil
+	if s.backoff <= 0 {
+		s.backoff = defaultBackoff
+	}
+	if s.breakerFailures <= 0 {
+		s.breakerFailures = defaultBreakerFailures
+	}
+	if s.breakerTimeout <= 0 {
+		s.breakerTimeout = defaultBreakerTimeout
+	}
+	if s.healthInterval <= 0 {
+		s.healthInterval = defaultHealthInterval
+	}
+
+	return s
 }
@@ -0,0 +1,296 @@
+package gate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/zap"
+)
+
+// the actions of the rules
+const (
+	// publish a copy to another topic
+	ruleRepublish = "republish"
+	// post the message to a [[webhook]]
+	ruleWebhook = "webhook"
+	// append the message to a file as a json line
+	ruleFile = "file"
+	// don't route the original message, the client still gets the ack
+	ruleDrop = "drop"
+)
+
+// the event of the messages sent to the webhooks and files by the rules
+const eventRule = "rule"
+
+// the lines waiting for a file, the ones over it are dropped
+const fileSinkQueue = 1024
+
+var (
+	errRuleWebhook = errors.New("rule webhook not found or full")
+	errSinkClosed  = errors.New("file sink is closed")
+	errSinkFull    = errors.New("file sink is full")
+)
+
+type rule struct {
+	name  string
+	topic string
+	// nil matches all
+	where   exprNode
+	actions []*RuleAction
+}
+
+// ruleSet is replaced as a whole on reload, it owns the files of the file actions
+type ruleSet struct {
+	g     *Gate
+	rules []*rule
+	files map[string]*fileSink
+}
+
+func (g *Gate) getRules() *ruleSet {
+	return g.rules.Load().(*ruleSet)
+}
+
+// newRuleSet compiles the rules, the webhook actions must name one of the webhooks
+func (g *Gate) newRuleSet(confs []*RuleConf, webhooks []*WebhookConf) (*ruleSet, error) {
+	rs := &ruleSet{g: g, files: make(map[string]*fileSink)}
+	for i, rc := range confs {
+		r, err := rs.compile(i, rc, webhooks)
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+		rs.rules = append(rs.rules, r)
+	}
+
+	return rs, nil
+}
+
+func (rs *ruleSet) compile(i int, rc *RuleConf, webhooks []*WebhookConf) (*rule, error) {
+	r := &rule{name: rc.Name, topic: rc.Topic, actions: rc.Actions}
+	if r.name == "" {
+		r.name = fmt.Sprintf("rule%d", i)
+	}
+
+	if !proto.ValidFilter(r.topic) || strings.HasPrefix(r.topic, sharePrefix) {
+		return nil, fmt.Errorf("rule %s: invalid topic %q", r.name, r.topic)
+	}
+
+	if strings.TrimSpace(rc.Where) != "" {
+		where, err := parseExpr(rc.Where)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: where: %v", r.name, err)
+		}
+		r.where = where
+	}
+
+	for _, a := range rc.Actions {
+		switch a.Type {
+		case ruleRepublish:
+			if a.Topic == "" || strings.ContainsAny(a.Topic, "+#") || a.Qos > 1 {
+				return nil, fmt.Errorf("rule %s: invalid republish topic %q or qos %d", r.name, a.Topic, a.Qos)
+			}
+		case ruleWebhook:
+			if !webhookConfigured(webhooks, a.Webhook) {
+				return nil, fmt.Errorf("rule %s: webhook %q not found", r.name, a.Webhook)
+			}
+		case ruleFile:
+			if _, ok := rs.files[a.Path]; ok {
+				break
+			}
+			s, err := openFileSink(a.Path, rs.g.logger)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.name, err)
+			}
+			rs.files[a.Path] = s
+		case ruleDrop:
+		default:
+			return nil, fmt.Errorf("rule %s: invalid action %q", r.name, a.Type)
+		}
+	}
+
+	return r, nil
+}
+
+func (rs *ruleSet) close() {
+	for _, s := range rs.files {
+		s.close()
+	}
+}
+
+// apply runs the actions of the rules matching the message, false is returned if it's dropped
+func (rs *ruleSet) apply(ci *connInfo, p *proto.PublishPacket) bool {
+	if len(rs.rules) == 0 {
+		return true
+	}
+
+	topic := string(p.Topic())
+
+	// the payload is decoded once for all the rules
+	var (
+		doc     interface{}
+		decoded bool
+		docErr  error
+	)
+
+	keep := true
+	for _, r := range rs.rules {
+		if !proto.TopicMatch(r.topic, topic) {
+			continue
+		}
+
+		if r.where != nil {
+			if !decoded {
+				decoded = true
+				docErr = json.Unmarshal(p.Payload(), &doc)
+			}
+			if docErr != nil {
+				ruleErrors.WithLabelValues(r.name).Inc()
+				continue
+			}
+			if !truthy(r.where.eval(doc)) {
+				continue
+			}
+		}
+
+		ruleMatched.WithLabelValues(r.name).Inc()
+		for _, a := range r.actions {
+			if a.Type == ruleDrop {
+				keep = false
+				continue
+			}
+
+			if err := rs.run(r, a, ci, p); err != nil {
+				ruleActionFailed.WithLabelValues(r.name, a.Type).Inc()
+				rs.g.logger.Warn("rule action error", zap.String("rule", r.name), zap.String("action", a.Type), zap.String("topic", topic), zap.Error(err))
+			}
+		}
+	}
+
+	return keep
+}
+
+func (rs *ruleSet) run(r *rule, a *RuleAction, ci *connInfo, p *proto.PublishPacket) error {
+	switch a.Type {
+	case ruleRepublish:
+		topic, _ := rewriteTopic(r.topic, a.Topic, string(p.Topic()))
+		np := proto.NewPublishPacket()
+		if err := np.SetTopic([]byte(topic)); err != nil {
+			return err
+		}
+		np.SetQoS(a.Qos)
+		np.SetRetain(a.Retain)
+		np.SetPayload(p.Payload())
+
+		// the copies don't go through the rules again
+		return rs.g.pubToStream(ci, np)
+
+	case ruleWebhook:
+		for _, wh := range rs.g.getWebhooks() {
+			if wh.conf.Name == a.Webhook && wh.push(ruleEvent(r, ci, p)) {
+				return nil
+			}
+		}
+		return errRuleWebhook
+
+	case ruleFile:
+		b, err := json.Marshal(ruleEvent(r, ci, p))
+		if err != nil {
+			return err
+		}
+		return rs.files[a.Path].write(b)
+	}
+
+	return nil
+}
+
+func ruleEvent(r *rule, ci *connInfo, p *proto.PublishPacket) *event {
+	ev := newEvent(eventRule, ci)
+	ev.Rule = r.name
+	ev.Topic = string(p.Topic())
+	ev.Payload = p.Payload()
+	ev.Qos = p.QoS()
+	ev.Retain = p.Retain()
+	return ev
+}
+
+func webhookConfigured(webhooks []*WebhookConf, name string) bool {
+	for _, wc := range webhooks {
+		if wc.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fileSink appends json lines to a file in its own goroutine, so a slow disk doesn't block the publishing clients
+type fileSink struct {
+	f      *os.File
+	logger zap.Logger
+	queue  chan []byte
+	done   chan struct{}
+
+	// guards closed, the queue isn't sent to after closing
+	sync.Mutex
+	closed bool
+}
+
+func openFileSink(path string, logger zap.Logger) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{
+		f:      f,
+		logger: logger,
+		queue:  make(chan []byte, fileSinkQueue),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// write queues the line, errSinkFull is returned if the disk can't keep up
+func (s *fileSink) write(b []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return errSinkClosed
+	}
+
+	select {
+	case s.queue <- append(b, '\n'):
+		return nil
+	default:
+		return errSinkFull
+	}
+}
+
+func (s *fileSink) run() {
+	defer close(s.done)
+
+	for b := range s.queue {
+		if _, err := s.f.Write(b); err != nil {
+			s.logger.Warn("rule file write error", zap.String("path", s.f.Name()), zap.Error(err))
+		}
+	}
+	s.f.Close()
+}
+
+// close waits for the queued lines to be written
+func (s *fileSink) close() {
+	s.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.Unlock()
+
+	<-s.done
+}
@@ -0,0 +1,340 @@
+package gate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The expressions of the rules are evaluated over the json payload, e.g.
+//   temp > 80 && (status == "on" || !sensor.ok)
+// The fields are dot paths into the payload, the array items are indexed by number like list.0.
+// The values are numbers, 'single' or "double" quoted strings, true, false and null.
+// The operators are || && ! == != > >= < <=, a missing field is null.
+
+// exprNode is a node of the parsed expression
+type exprNode interface {
+	eval(doc interface{}) interface{}
+}
+
+type (
+	exprLiteral struct{ v interface{} }
+	exprField   struct{ path []string }
+	exprNot     struct{ x exprNode }
+	exprBinary  struct {
+		op   string
+		l, r exprNode
+	}
+)
+
+func (e exprLiteral) eval(doc interface{}) interface{} { return e.v }
+
+func (e exprField) eval(doc interface{}) interface{} {
+	v := doc
+	for _, p := range e.path {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			v = x[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil
+			}
+			v = x[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+func (e exprNot) eval(doc interface{}) interface{} { return !truthy(e.x.eval(doc)) }
+
+func (e exprBinary) eval(doc interface{}) interface{} {
+	switch e.op {
+	case "&&":
+		return truthy(e.l.eval(doc)) && truthy(e.r.eval(doc))
+	case "||":
+		return truthy(e.l.eval(doc)) || truthy(e.r.eval(doc))
+	}
+
+	return compare(e.op, e.l.eval(doc), e.r.eval(doc))
+}
+
+// truthy is false for false, null, 0 and ""
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case float64:
+		return x != 0
+	case string:
+		return x != ""
+	}
+	return true
+}
+
+// compare orders the numbers and the strings, the other values can only be checked for equality
+func compare(op string, l, r interface{}) bool {
+	switch lv := l.(type) {
+	case float64:
+		if rv, ok := r.(float64); ok {
+			return order(op, cmpFloat(lv, rv))
+		}
+	case string:
+		if rv, ok := r.(string); ok {
+			return order(op, strings.Compare(lv, rv))
+		}
+	}
+
+	switch op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	return false
+}
+
+func equal(l, r interface{}) bool {
+	switch l.(type) {
+	case nil, bool, float64, string:
+		return l == r
+	}
+	return false
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func order(op string, c int) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+// parseExpr compiles the expression
+func parseExpr(s string) (exprNode, error) {
+	toks, err := lexExpr(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{toks: toks}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].s)
+	}
+	return n, nil
+}
+
+// token kinds
+const (
+	tokOp = iota
+	tokNumber
+	tokString
+	tokIdent
+)
+
+type exprToken struct {
+	kind int
+	s    string
+}
+
+func lexExpr(s string) ([]exprToken, error) {
+	var toks []exprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="),
+			strings.HasPrefix(s[i:], ">="), strings.HasPrefix(s[i:], "<="):
+			toks = append(toks, exprToken{tokOp, s[i : i+2]})
+			i += 2
+
+		case strings.IndexByte("()!<>", c) >= 0:
+			toks = append(toks, exprToken{tokOp, s[i : i+1]})
+			i++
+
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			toks = append(toks, exprToken{tokString, s[i+1 : i+1+j]})
+			i += j + 2
+
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(s) && (s[j] == '.' || s[j] == 'e' || s[j] == 'E' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			toks = append(toks, exprToken{tokNumber, s[i:j]})
+			i = j
+
+		case isIdent(c):
+			j := i + 1
+			for j < len(s) && (isIdent(s[j]) || s[j] == '.' || (s[j] >= '0' && s[j] <= '9')) {
+				j++
+			}
+			toks = append(toks, exprToken{tokIdent, s[i:j]})
+			i = j
+
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+	return toks, nil
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// exprParser is a recursive descent parser, || binds looser than &&, and both looser than the comparisons
+type exprParser struct {
+	toks []exprToken
+	pos  int
+}
+
+func (p *exprParser) peekOp(ops ...string) string {
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != tokOp {
+		return ""
+	}
+	for _, op := range ops {
+		if p.toks[p.pos].s == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) or() (exprNode, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("||") != "" {
+		p.pos++
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{"||", l, r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) and() (exprNode, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("&&") != "" {
+		p.pos++
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{"&&", l, r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) not() (exprNode, error) {
+	if p.peekOp("!") != "" {
+		p.pos++
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return exprNot{x}, nil
+	}
+	return p.cmp()
+}
+
+func (p *exprParser) cmp() (exprNode, error) {
+	l, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peekOp("==", "!=", ">", ">=", "<", "<="); op != "" {
+		p.pos++
+		r, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return exprBinary{op, l, r}, nil
+	}
+	return l, nil
+}
+
+func (p *exprParser) value() (exprNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.s)
+		}
+		return exprLiteral{f}, nil
+	case tokString:
+		return exprLiteral{t.s}, nil
+	case tokIdent:
+		switch t.s {
+		case "true":
+			return exprLiteral{true}, nil
+		case "false":
+			return exprLiteral{false}, nil
+		case "null":
+			return exprLiteral{nil}, nil
+		}
+		return exprField{strings.Split(t.s, ".")}, nil
+	}
+
+	if t.s == "(" {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peekOp(")") == "" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t.s)
+}
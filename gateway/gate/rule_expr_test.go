@@ -0,0 +1,49 @@
+package gate
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_parseExpr(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"temp": 85.5, "status": "on", "ok": false, "sensor": {"id": "s1", "list": [1, 2]}, "none": null}`), &doc)
+
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{`temp > 80`, true, false},
+		{`temp <= 80`, false, false},
+		{`temp > 80 && status == "on"`, true, false},
+		{`temp > 90 || status == 'on'`, true, false},
+		{`!(temp > 90) && !ok`, true, false},
+		{`sensor.id == "s1" && sensor.list.1 == 2`, true, false},
+		{`missing == null && none == null`, true, false},
+		{`missing > 1`, false, false},
+		{`status != "off"`, true, false},
+		{`status > 1`, false, false},
+		{`temp >= -1.5e2`, true, false},
+		{`ok`, false, false},
+		{`temp >`, false, true},
+		{`(temp > 1`, false, true},
+		{`temp > 1 status`, false, true},
+		{`"open`, false, true},
+		{`temp # 1`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			n, err := parseExpr(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExpr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := truthy(n.eval(doc)); got != tt.want {
+				t.Errorf("eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package gate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+func Test_newRuleSet(t *testing.T) {
+	g := testGate(t, &Config{Webhook: []*WebhookConf{{Name: "w1", Url: "http://x"}}})
+	defer g.stopWebhooks(time.Second)
+
+	tests := []struct {
+		name    string
+		rule    *RuleConf
+		wantErr bool
+	}{
+		{"valid", &RuleConf{Topic: "a/+", Where: "x > 1", Actions: []*RuleAction{{Type: ruleRepublish, Topic: "b/{1}"}, {Type: ruleWebhook, Webhook: "w1"}, {Type: ruleDrop}}}, false},
+		{"no topic", &RuleConf{}, true},
+		{"invalid topic", &RuleConf{Topic: "a/#/b"}, true},
+		{"bad where", &RuleConf{Topic: "a", Where: "x >"}, true},
+		{"wildcard republish", &RuleConf{Topic: "a", Actions: []*RuleAction{{Type: ruleRepublish, Topic: "b/+"}}}, true},
+		{"unknown webhook", &RuleConf{Topic: "a", Actions: []*RuleAction{{Type: ruleWebhook, Webhook: "w2"}}}, true},
+		{"unknown action", &RuleConf{Topic: "a", Actions: []*RuleAction{{Type: "mail"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := g.newRuleSet([]*RuleConf{tt.rule}, g.Config().Webhook)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRuleSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				rs.close()
+			}
+		})
+	}
+}
+
+func Test_ruleSet_apply(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	rs, err := g.newRuleSet([]*RuleConf{
+		{Name: "hot", Topic: "devices/+/telemetry", Where: "temp > 80", Actions: []*RuleAction{
+			{Type: ruleRepublish, Topic: "alerts/{1}", Qos: 1},
+			{Type: ruleFile, Path: path},
+		}},
+		{Name: "noise", Topic: "devices/+/debug", Actions: []*RuleAction{{Type: ruleDrop}}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.close()
+
+	tests := []struct {
+		name     string
+		topic    string
+		payload  string
+		wantKeep bool
+		wantPub  string
+	}{
+		{"hot", "devices/d1/telemetry", `{"temp": 90}`, true, "Publish alerts/d1"},
+		{"cold", "devices/d2/telemetry", `{"temp": 20}`, true, ""},
+		{"not json", "devices/d3/telemetry", `hot`, true, ""},
+		{"dropped", "devices/d1/debug", `x`, false, ""},
+		{"other topic", "rooms/r1", `{"temp": 90}`, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := fakeStreams(t, g, "s1:9000")["s1:9000"]
+
+			p := proto.NewPublishPacket()
+			p.SetTopic([]byte(tt.topic))
+			p.SetPayload([]byte(tt.payload))
+
+			ci := &connInfo{g: g, id: newCID(), cred: &Credential{ClientID: "d1", Username: "bob"}}
+			if got := rs.apply(ci, p); got != tt.wantKeep {
+				t.Errorf("apply() = %v, want %v", got, tt.wantKeep)
+			}
+			if tt.wantPub != "" && !fs.has(tt.wantPub) {
+				t.Errorf("calls = %v, want %q", fs.calls, tt.wantPub)
+			}
+			if tt.wantPub == "" && len(fs.calls) != 0 {
+				t.Errorf("calls = %v, want none", fs.calls)
+			}
+		})
+	}
+
+	// the lines are written in the background, close waits for them
+	rs.close()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	var ev event
+	if len(lines) != 1 || json.Unmarshal([]byte(lines[0]), &ev) != nil || ev.Rule != "hot" || ev.Topic != "devices/d1/telemetry" {
+		t.Errorf("file = %s, want one line of the hot message", b)
+	}
+}
+
+func Test_ruleSet_webhook(t *testing.T) {
+	g := testGate(t, nil)
+	ws := newWebhookServer(t, 0)
+	wc := &WebhookConf{Name: "w1", Url: ws.URL, Events: []string{eventConnected}}
+	useWebhooks(t, g, wc)
+
+	rs, err := g.newRuleSet([]*RuleConf{{Topic: "a/#", Actions: []*RuleAction{{Type: ruleWebhook, Webhook: "w1"}}}}, []*WebhookConf{wc})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := proto.NewPublishPacket()
+	p.SetTopic([]byte("a/b"))
+	p.SetPayload([]byte("x"))
+	rs.apply(&connInfo{g: g, id: newCID()}, p)
+
+	// the message goes to the webhook of the rule, whatever the events of the webhook are
+	g.stopWebhooks(time.Second)
+	if ws.events() != 1 {
+		t.Errorf("events = %d, want 1", ws.events())
+	}
+}
@@ -1,30 +1,53 @@
 package gate
 
 import (
-	"errors"
 	"net"
 	"time"
 
-	"fmt"
-
 	proto "github.com/aiyun/gomqtt/mqtt/protocol"
 	"github.com/aiyun/gomqtt/mqtt/service"
 	"github.com/corego/tools"
 	"github.com/uber-go/zap"
 )
 
-func serve(c net.Conn) {
+func serve(l *listener, c net.Conn) {
+	// the PROXY protocol header is read here
+	addr := c.RemoteAddr()
+
+	ip := limitIP(addr)
+	if reason := l.g.admit.acquire(l, ip); reason != "" {
+		rejectConn(l, c, reason)
+		return
+	}
+	// added by acquire, the will message is published before it's done
+	defer l.g.serving.Done()
+
 	// init a new connInfo
-	ci := &connInfo{}
+	ci := &connInfo{g: l.g}
 
 	//generate a uuid for this conn
-	ci.id = 1
+	ci.id = newCID()
 	ci.c = c
-	Logger.Debug("a new connection has established", zap.Int("cid", ci.id), zap.String("ip", c.RemoteAddr().String()))
+	ci.l = l
+	ci.addr = addr
+	ci.ip = addrIP(addr)
+	ci.g.logger.Debug("a new connection has established", zap.Int("cid", ci.id), zap.String("ip", addr.String()), zap.String("listener", l.name))
 
 	defer func() {
 		c.Close()
-		delCI(ci.id)
+		l.g.admit.release(l, ip)
+		ci.g.delCI(ci.id)
+
+		// the will message is still here, so this connection isn't closed by DISCONNECT
+		publishWill(ci)
+
+		if !ci.connected.IsZero() {
+			ev := newEvent(eventDisconnected, ci)
+			ev.Reason = ci.closeReason
+			ci.g.emitEvent(ev)
+		}
+
+		ci.g.getHooks().onDisconnect(ci)
 	}()
 
 	//----------------Connection init---------------------------------------------
@@ -33,17 +56,35 @@ func serve(c net.Conn) {
 		return
 	}
 
-	// save ci
-	saveCI(ci)
+	// the deliveries are accepted once the session is found by the streams
+	ci.openOut(ci.g.Config().Deliver.QueueSize)
+	defer ci.closeOut()
+
+	// save ci, the old session using the same client id will be taken over
+	if old := ci.g.saveCI(ci); old != nil {
+		ci.g.logger.Info("session taken over", zap.Int("cid", old.id), zap.Int("new_cid", ci.id))
+		old.close(closeTakeover)
+	}
+
+	if err := streamLogin(ci); err != nil {
+		ci.g.logger.Warn("stream login error", zap.Error(err), zap.Int("cid", ci.id))
+	}
+	ci.g.emitEvent(newEvent(eventConnected, ci))
+	defer func() {
+		if err := streamLogout(ci); err != nil {
+			ci.g.logger.Warn("stream logout error", zap.Error(err), zap.Int("cid", ci.id))
+		}
+	}()
 
 	ci.stopped = make(chan struct{})
 	go recvPacket(ci)
+	go ci.writeLoop()
 
 	// loop reading data
 	for {
 		select {
 		case <-ci.stopped:
-			Logger.Info("user's main thread is going to stop")
+			ci.g.logger.Info("user's main thread is going to stop")
 			goto STOP
 		}
 	}
@@ -58,51 +99,86 @@ func initConnection(ci *connInfo) error {
 
 	reply := proto.NewConnackPacket()
 
-	pt, buf, n, err := service.ReadPacket(ci.c)
+	cp, n, err := readConnect(ci.c)
 	if err != nil {
-		Logger.Warn("Read packet error", zap.Error(err), zap.String("buf", fmt.Sprintf("%v", buf)), zap.Int("bytes", n), zap.Int("cid", ci.id))
+		ci.g.logger.Warn("Read connect error", zap.Error(err), zap.Int("cid", ci.id))
 
 		if code, ok := err.(proto.ConnackCode); ok {
 			reply.SetReturnCode(code)
 			service.WritePacket(ci.c, reply)
+		} else if err == errNotConnect {
+			reply.SetReturnCode(proto.ErrIdentifierRejected)
+			service.WritePacket(ci.c, reply)
 		}
 		return err
 	}
 
-	cp, ok := pt.(*proto.ConnectPacket)
-	if !ok {
-		Logger.Warn("this first packet is not connect type", zap.String("packet_type", fmt.Sprintf("%T", cp)), zap.Int("cid", ci.id))
+	ci.cp = cp
+	countIn(cp, n)
+	ci.g.tracePacket(ci, traceIn, cp)
 
-		reply.SetReturnCode(proto.ErrIdentifierRejected)
-		service.WritePacket(ci.c, reply)
-		return errors.New("invalid packet")
+	will, err := newWill(cp)
+	if err != nil {
+		ci.g.logger.Info("invalid will message", zap.Error(err), zap.Int("cid", ci.id))
+		return err
 	}
 
-	ci.cp = cp
-
-	Logger.Debug("user connected!", zap.String("user", tools.Bytes2String(ci.cp.Username())), zap.String("password", tools.Bytes2String(ci.cp.Password())), zap.Int("cid", ci.id),
+	ci.g.logger.Debug("user connected!", zap.String("user", tools.Bytes2String(ci.cp.Username())), zap.String("password", redact(ci.cp.Password())), zap.Int("cid", ci.id),
 		zap.Float64("keepalive", float64(cp.KeepAlive())))
 
+	hc := ci.g.getHooks()
+	if code := hc.onConnect(ci); code != proto.ConnectionAccepted {
+		reply.SetReturnCode(code)
+		writeConnack(ci, reply)
+		return code
+	}
+
 	// validate the user
-	ok = userValidate(ci.cp.Username(), ci.cp.Password())
-	if !ok {
-		Logger.Debug("user invalid", zap.Int("cid", ci.id))
+	code := userValidate(ci)
+	if code != proto.ConnectionAccepted {
+		ci.g.logger.Info("user rejected", zap.Int("cid", ci.id), zap.String("user", tools.Bytes2String(ci.cp.Username())), zap.String("ip", ci.ip), zap.String("reason", code.Error()))
+		ci.l.stats.authFailed.Inc()
+
+		reply.SetReturnCode(code)
+		writeConnack(ci, reply)
+		return code
+	}
 
-		reply.SetReturnCode(proto.ErrIdentifierRejected)
-		service.WritePacket(ci.c, reply)
-		return errors.New("invalid user")
+	if code := hc.onAuthenticated(ci); code != proto.ConnectionAccepted {
+		reply.SetReturnCode(code)
+		writeConnack(ci, reply)
+		return code
 	}
 
 	reply.SetReturnCode(proto.ConnectionAccepted)
-	if err := service.WritePacket(ci.c, reply); err != nil {
-		Logger.Info("write packet error", zap.Error(err), zap.Int("cid", ci.id))
+	if err := writeConnack(ci, reply); err != nil {
+		ci.g.logger.Info("write packet error", zap.Error(err), zap.Int("cid", ci.id))
 		return err
 	}
+	countOut(reply)
 
 	// if keepalive == 0 ,we should specify a default keepalive
 	if ci.cp.KeepAlive() == 0 {
-		ci.cp.SetKeepAlive(Conf.Mqtt.MaxKeepalive)
+		ci.cp.SetKeepAlive(ci.g.Config().Mqtt.MaxKeepalive)
+	}
+
+	// the session is accepted, store the will message
+	ci.will = will
+	ci.connected = time.Now()
+
+	// the username may be mapped from the certificate, so the class is selected after authentication
+	ci.limits = newClientLimiter(ci.g.Config().ClientLimit, ci.cred.Username)
+	ci.subs = make(map[string]byte)
+
+	return nil
+}
+
+// writeConnack writes the reply of CONNECT, the connection isn't serving yet so ci.write isn't used
+func writeConnack(ci *connInfo, reply *proto.ConnackPacket) error {
+	if err := service.WritePacket(ci.c, reply); err != nil {
+		return err
 	}
 
+	ci.g.tracePacket(ci, traceOut, reply)
 	return nil
 }
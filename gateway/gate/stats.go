@@ -0,0 +1,72 @@
+package gate
+
+import "github.com/uber-go/atomic"
+
+// gateStats holds the runtime counters of the gateway
+type gateStats struct {
+	// will messages published on ungraceful disconnect
+	willPublished *atomic.Int64
+	// will messages discarded because of DISCONNECT
+	willDiscarded *atomic.Int64
+	// will messages failed to route
+	willFailed *atomic.Int64
+
+	// publish and subscribe requests rejected by the acl
+	aclPubDenied *atomic.Int64
+	aclSubDenied *atomic.Int64
+
+	// new connections rejected by the admission control, the ones over
+	// the max conns of listeners are counted by the listeners
+	rejectedRate     *atomic.Int64
+	rejectedGlobal   *atomic.Int64
+	rejectedIP       *atomic.Int64
+	rejectedDraining *atomic.Int64
+
+	// actions taken by the client limits
+	limitThrottled    *atomic.Int64
+	limitDropped      *atomic.Int64
+	limitDisconnected *atomic.Int64
+
+	// connections closed by keepalive timeout, and by the clients not reading
+	keepaliveTimeouts *atomic.Int64
+	writeTimeouts     *atomic.Int64
+
+	// messages failed to route to stream
+	routeFailed *atomic.Int64
+
+	// qos 0 messages lost because routing or a hook failed, the qos 1 ones are sent again
+	publishLost *atomic.Int64
+
+	// PUBLISH packets and bytes of all the packets, published to $SYS
+	msgsReceived  *atomic.Int64
+	msgsSent      *atomic.Int64
+	bytesReceived *atomic.Int64
+	bytesSent     *atomic.Int64
+}
+
+var stats = &gateStats{
+	willPublished: atomic.NewInt64(0),
+	willDiscarded: atomic.NewInt64(0),
+	willFailed:    atomic.NewInt64(0),
+	aclPubDenied:  atomic.NewInt64(0),
+	aclSubDenied:  atomic.NewInt64(0),
+
+	rejectedRate:     atomic.NewInt64(0),
+	rejectedGlobal:   atomic.NewInt64(0),
+	rejectedIP:       atomic.NewInt64(0),
+	rejectedDraining: atomic.NewInt64(0),
+
+	limitThrottled:    atomic.NewInt64(0),
+	limitDropped:      atomic.NewInt64(0),
+	limitDisconnected: atomic.NewInt64(0),
+
+	keepaliveTimeouts: atomic.NewInt64(0),
+	writeTimeouts:     atomic.NewInt64(0),
+	routeFailed:       atomic.NewInt64(0),
+	publishLost:       atomic.NewInt64(0),
+
+	msgsReceived:  atomic.NewInt64(0),
+	msgsSent:      atomic.NewInt64(0),
+	bytesReceived: atomic.NewInt64(0),
+	bytesSent:     atomic.NewInt64(0),
+}
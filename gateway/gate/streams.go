@@ -0,0 +1,305 @@
+package gate
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	rpc "github.com/aiyun/gomqtt/proto"
+	"github.com/corego/tools"
+	"github.com/uber-go/zap"
+	"stathat.com/c/consistent"
+)
+
+var errNoStream = errors.New("no stream available")
+
+// the ip of the gateway, see gatewayAddr
+var localIP = tools.LocalIP()
+
+// newStreamClient connects to a stream, replaced in the tests
+var newStreamClient = newRpc
+
+// streamRouter hashes the accounts and topics over the streams watched from the registry, and keeps a grpc client per stream.
+// Topics are hashed by their first level, so a filter goes to the stream receiving its publishes, and a filter
+// starting with a wildcard may match any topic, it goes to all the streams.
+type streamRouter struct {
+	g *Gate
+
+	sync.RWMutex
+	hash    *consistent.Consistent
+	clients map[string]*Rpc
+}
+
+func newStreamRouter(g *Gate) *streamRouter {
+	return &streamRouter{
+		g:       g,
+		hash:    consistent.New(),
+		clients: make(map[string]*Rpc),
+	}
+}
+
+// topicKey is the first level of the topic
+func topicKey(topic string) string {
+	if i := strings.IndexByte(topic, '/'); i >= 0 {
+		return topic[:i]
+	}
+	return topic
+}
+
+// filterTargets returns the stream addrs a filter is subscribed to
+func filterTargets(h *consistent.Consistent, filter string) map[string]bool {
+	targets := make(map[string]bool)
+
+	// shared subscriptions are routed by the real filter
+	_, filter, _ = splitShare(filter)
+	key := topicKey(filter)
+	if key == "+" || key == "#" {
+		for _, addr := range h.Members() {
+			targets[addr] = true
+		}
+		return targets
+	}
+
+	if addr, err := h.Get(key); err == nil {
+		targets[addr] = true
+	}
+	return targets
+}
+
+// updateBreakers applies the reloaded [stream] settings to the clients, the deadlines and retries are read on each call
+func (sr *streamRouter) updateBreakers() {
+	sc := sr.g.streamConf()
+
+	sr.RLock()
+	defer sr.RUnlock()
+	for _, c := range sr.clients {
+		if c.breaker != nil {
+			c.breaker.setLimits(sc.breakerFailures, sc.breakerTimeout)
+		}
+	}
+}
+
+// get returns the client of the stream owning the key
+func (sr *streamRouter) get(key string) (*Rpc, error) {
+	sr.RLock()
+	defer sr.RUnlock()
+
+	addr, err := sr.hash.Get(key)
+	if err != nil {
+		return nil, errNoStream
+	}
+
+	return sr.clients[addr], nil
+}
+
+func (sr *streamRouter) forFilter(filter string) ([]*Rpc, error) {
+	sr.RLock()
+	defer sr.RUnlock()
+
+	var cs []*Rpc
+	for addr := range filterTargets(sr.hash, filter) {
+		cs = append(cs, sr.clients[addr])
+	}
+
+	if len(cs) == 0 {
+		return nil, errNoStream
+	}
+	return cs, nil
+}
+
+// update replaces the stream set, the sessions and subscriptions owned by another stream now are moved to it
+func (sr *streamRouter) update(addrs []string) {
+	addrs = uniqAddrs(addrs)
+
+	sr.Lock()
+	old := sr.hash
+	if sameMembers(old.Members(), addrs) {
+		sr.Unlock()
+		return
+	}
+
+	clients := make(map[string]*Rpc, len(addrs))
+	var members []string
+	for _, addr := range addrs {
+		c, ok := sr.clients[addr]
+		if !ok {
+			var err error
+			if c, err = newStreamClient(sr.g, addr); err != nil {
+				// left out of the hash, it's tried again on the next change
+				sr.g.logger.Warn("connect stream error", zap.Error(err), zap.String("addr", addr))
+				continue
+			}
+		}
+
+		clients[addr] = c
+		members = append(members, addr)
+	}
+
+	cur := consistent.New()
+	cur.Set(members)
+
+	var removed []*Rpc
+	for addr, c := range sr.clients {
+		if _, ok := clients[addr]; !ok {
+			removed = append(removed, c)
+		}
+	}
+
+	sr.hash = cur
+	sr.clients = clients
+	sr.Unlock()
+
+	sr.g.logger.Info("stream set changed", zap.Object("old", old.Members()), zap.Object("new", members))
+	sr.resolveSessions(old, cur, clients)
+
+	for _, c := range removed {
+		c.Close()
+	}
+}
+
+// resolveSessions moves the logins and subscriptions whose stream changed, the removed streams are gone
+// with their state, so only the alive ones in clients are told to forget
+func (sr *streamRouter) resolveSessions(old, cur *consistent.Consistent, clients map[string]*Rpc) {
+	list := sr.g.conns.list()
+
+	for _, ci := range list {
+		if ci.cred == nil {
+			continue
+		}
+
+		o, _ := old.Get(ci.cred.Username)
+		n, _ := cur.Get(ci.cred.Username)
+		if o != n {
+			if c := clients[n]; c != nil {
+				c.LogIn(accMsg(ci))
+			}
+			if c := clients[o]; c != nil {
+				c.LogOut(accMsg(ci))
+			}
+		}
+
+		ci.slock.RLock()
+		subs := make(map[string]byte, len(ci.subs))
+		for t, qos := range ci.subs {
+			subs[t] = qos
+		}
+		ci.slock.RUnlock()
+
+		for t, qos := range subs {
+			os := filterTargets(old, t)
+			ns := filterTargets(cur, t)
+			for addr := range ns {
+				if !os[addr] {
+					clients[addr].Subscribe(tcMsg(ci, t, qos))
+				}
+			}
+			for addr := range os {
+				if c := clients[addr]; c != nil && !ns[addr] {
+					c.UnSubscribe(tcMsg(ci, t, qos))
+				}
+			}
+		}
+	}
+}
+
+func uniqAddrs(addrs []string) []string {
+	set := make(map[string]bool)
+	var uniq []string
+	for _, addr := range addrs {
+		if addr != "" && !set[addr] {
+			set[addr] = true
+			uniq = append(uniq, addr)
+		}
+	}
+
+	sort.Strings(uniq)
+	return uniq
+}
+
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a = append([]string{}, a...)
+	sort.Strings(a)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// accMsg identifies the session, the conn id is sent as ConVer
+func accMsg(ci *connInfo) *rpc.AccMsg {
+	am := &rpc.AccMsg{ConVer: int32(ci.id), Gip: ci.g.gatewayAddr()}
+	if ci.cred != nil {
+		am.An = ci.cred.Username
+		am.Un = ci.cred.ClientID
+	}
+	return am
+}
+
+func tcMsg(ci *connInfo, filter string, qos byte) *rpc.TcMsg {
+	group, filter, _ := splitShare(filter)
+	tm := &rpc.TcMsg{Tp: []byte(filter), Qos: int32(qos), Gip: ci.g.gatewayAddr(), ConId: int64(ci.id), Group: group}
+	if ci.cred != nil {
+		tm.An = ci.cred.Username
+		tm.Cid = ci.cred.ClientID
+	}
+	return tm
+}
+
+// streamLogin tells the stream owning the account the session is online
+func streamLogin(ci *connInfo) error {
+	c, err := ci.g.streams.get(ci.cred.Username)
+	if err != nil {
+		return err
+	}
+
+	return c.LogIn(accMsg(ci))
+}
+
+// streamLogout tells the stream the session is closed, the stream drops the subscriptions of the connection
+func streamLogout(ci *connInfo) error {
+	if ci.cred == nil {
+		return nil
+	}
+
+	// the shared subscriptions live on the streams of the filters, leave the groups
+	// before the undelivered messages are acked, so they go to the other members
+	unsubShares(ci)
+
+	c, err := ci.g.streams.get(ci.cred.Username)
+	if err != nil {
+		return err
+	}
+
+	return c.LogOut(accMsg(ci))
+}
+
+func unsubShares(ci *connInfo) {
+	ci.slock.RLock()
+	defer ci.slock.RUnlock()
+
+	for filter, qos := range ci.subs {
+		if !strings.HasPrefix(filter, sharePrefix) {
+			continue
+		}
+
+		if err := unsubToStream(ci, filter, qos); err != nil {
+			ci.g.logger.Warn("leave shared group error", zap.Error(err), zap.Int("cid", ci.id), zap.String("topic", filter))
+		}
+	}
+}
+
+// streamAddrs returns the addrs watched from the registry
+func streamAddrs(m map[string]string) []string {
+	addrs := make([]string, 0, len(m))
+	for _, addr := range m {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
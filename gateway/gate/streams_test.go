@@ -0,0 +1,316 @@
+package gate
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	rpc "github.com/aiyun/gomqtt/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStream records the calls of the gateway
+type fakeStream struct {
+	rpc.RpcClient
+
+	sync.Mutex
+	calls []string
+}
+
+func (fs *fakeStream) record(call string) (*rpc.Reply, error) {
+	fs.Lock()
+	fs.calls = append(fs.calls, call)
+	fs.Unlock()
+	return &rpc.Reply{}, nil
+}
+
+func (fs *fakeStream) LogIn(ctx context.Context, in *rpc.AccMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.record("LogIn " + in.An)
+}
+
+func (fs *fakeStream) LogOut(ctx context.Context, in *rpc.AccMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.record("LogOut " + in.An)
+}
+
+func (fs *fakeStream) Subscribe(ctx context.Context, in *rpc.TcMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.record("Subscribe " + tcCall(in))
+}
+
+func (fs *fakeStream) UnSubscribe(ctx context.Context, in *rpc.TcMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.record("UnSubscribe " + tcCall(in))
+}
+
+func tcCall(in *rpc.TcMsg) string {
+	if in.Group != "" {
+		return string(in.Tp) + " group=" + in.Group
+	}
+	return string(in.Tp)
+}
+
+func (fs *fakeStream) Publish(ctx context.Context, in *rpc.PubMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.record("Publish " + string(in.Tp))
+}
+
+func (fs *fakeStream) has(call string) bool {
+	fs.Lock()
+	defer fs.Unlock()
+	for _, c := range fs.calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+// fakeStreams replaces the stream router with fake streams
+func fakeStreams(t *testing.T, g *Gate, addrs ...string) map[string]*fakeStream {
+	oldNew := newStreamClient
+	t.Cleanup(func() { newStreamClient = oldNew })
+
+	fakes := make(map[string]*fakeStream)
+	newStreamClient = func(g *Gate, addr string) (*Rpc, error) {
+		fs := &fakeStream{}
+		fakes[addr] = fs
+		return &Rpc{g: g, addr: addr, client: fs}, nil
+	}
+
+	g.streams = newStreamRouter(g)
+	g.streams.update(addrs)
+	return fakes
+}
+
+func Test_filterTargets(t *testing.T) {
+	g := testGate(t, nil)
+	fakeStreams(t, g, "s1:9000", "s2:9000", "s3:9000")
+
+	tests := []struct {
+		filter string
+		want   int
+	}{
+		{"a/b", 1},
+		{"a/+", 1},
+		{"a/#", 1},
+		{"+/b", 3},
+		{"#", 3},
+		{"$share/g1/a/b", 1},
+		{"$share/g1/#", 3},
+	}
+	for _, tt := range tests {
+		if got := filterTargets(g.streams.hash, tt.filter); len(got) != tt.want {
+			t.Errorf("filterTargets(%q) = %v, want %d streams", tt.filter, got, tt.want)
+		}
+	}
+
+	// a filter goes to the stream receiving its publishes
+	pub, _ := g.streams.hash.Get(topicKey("a/b/c"))
+	if !filterTargets(g.streams.hash, "a/+/c")[pub] {
+		t.Errorf("a/+/c isn't routed to %s", pub)
+	}
+	if !filterTargets(g.streams.hash, "$share/g1/a/+/c")[pub] {
+		t.Errorf("$share/g1/a/+/c isn't routed to %s", pub)
+	}
+}
+
+func Test_subToStream_routing(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+	conf.Mqtt.QosMax = 1
+
+	ci := &connInfo{g: g, id: newCID(), cred: &Credential{Username: "bob", ClientID: "c1"}}
+
+	fakeStreams(t, g)
+	if _, err := subToStream(ci, "a/b", 1); err != errNoStream {
+		t.Errorf("subToStream() without streams error = %v, want %v", err, errNoStream)
+	}
+
+	fakes := fakeStreams(t, g, "s1:9000", "s2:9000")
+	qos, err := subToStream(ci, "#", 2)
+	if err != nil || qos != 1 {
+		t.Fatalf("subToStream() = %v, %v, want 1", qos, err)
+	}
+	for addr, fs := range fakes {
+		if !fs.has("Subscribe #") {
+			t.Errorf("%s didn't get the wildcard subscription", addr)
+		}
+	}
+}
+
+func Test_streamRouter_update(t *testing.T) {
+	g := testGate(t, nil)
+	fakes := fakeStreams(t, g, "s1:9000")
+
+	ci := &connInfo{g: g, id: newCID(), cred: &Credential{Username: "bob"}, subs: map[string]byte{}}
+	// enough filters to have some of them moved
+	for _, f := range []string{"a/x", "b/x", "c/x", "d/x", "e/x", "f/x", "g/x", "h/x", "#"} {
+		ci.subs[f] = 0
+	}
+	g.saveCI(ci)
+	defer g.delCI(ci.id)
+
+	old := g.streams.hash
+	g.streams.update([]string{"s1:9000", "s2:9000"})
+
+	s2 := fakes["s2:9000"]
+	if s2 == nil {
+		t.Fatal("no client for the new stream")
+	}
+	if !s2.has("Subscribe #") {
+		t.Errorf("wildcard filter isn't subscribed on the new stream")
+	}
+	for f := range ci.subs {
+		if topicKey(f) == "#" {
+			continue
+		}
+
+		o, _ := old.Get(topicKey(f))
+		n, _ := g.streams.hash.Get(topicKey(f))
+		if o != n && !s2.has("Subscribe "+f) {
+			t.Errorf("%s moved to %s but isn't subscribed there", f, n)
+		}
+		if o == n && s2.has("Subscribe "+f) {
+			t.Errorf("%s didn't move but is subscribed on %s", f, n)
+		}
+	}
+
+	if n, _ := g.streams.hash.Get("bob"); n == "s2:9000" && !s2.has("LogIn bob") {
+		t.Errorf("the account moved but isn't logged in")
+	}
+}
+
+// flakyStream fails the first calls with the code
+type flakyStream struct {
+	rpc.RpcClient
+	fails int
+	code  codes.Code
+	calls int
+}
+
+func (fs *flakyStream) call() (*rpc.Reply, error) {
+	fs.calls++
+	if fs.calls <= fs.fails {
+		return nil, status.Error(fs.code, "flaky")
+	}
+	return &rpc.Reply{}, nil
+}
+
+func (fs *flakyStream) Subscribe(ctx context.Context, in *rpc.TcMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.call()
+}
+
+func (fs *flakyStream) Publish(ctx context.Context, in *rpc.PubMsg, opts ...grpc.CallOption) (*rpc.Reply, error) {
+	return fs.call()
+}
+
+func Test_Rpc_call(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+	conf.Stream.Backoff = 1
+	conf.Stream.Retries = 2
+
+	tests := []struct {
+		name      string
+		fails     int
+		code      codes.Code
+		publish   bool
+		wantErr   bool
+		wantCalls int
+	}{
+		{"retried", 2, codes.Unavailable, false, false, 3},
+		{"retries exhausted", 3, codes.Unavailable, false, true, 3},
+		{"not transient", 1, codes.InvalidArgument, false, true, 1},
+		{"publish not retried", 1, codes.Unavailable, true, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &flakyStream{fails: tt.fails, code: tt.code}
+			r := &Rpc{g: g, client: fs, breaker: newBreaker(10, time.Second)}
+
+			var err error
+			if tt.publish {
+				err = r.Publish(&rpc.PubMsg{})
+			} else {
+				err = r.Subscribe(&rpc.TcMsg{})
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fs.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", fs.calls, tt.wantCalls)
+			}
+		})
+	}
+
+	// the breaker fails fast
+	fs := &flakyStream{fails: 100, code: codes.Unavailable}
+	r := &Rpc{g: g, client: fs, breaker: newBreaker(1, time.Minute)}
+	r.Subscribe(&rpc.TcMsg{})
+	if err := r.Subscribe(&rpc.TcMsg{}); err != errBreakerOpen {
+		t.Errorf("error = %v, want %v", err, errBreakerOpen)
+	}
+	if fs.calls != 1 {
+		t.Errorf("calls = %d, want 1", fs.calls)
+	}
+}
+
+func Test_unsubToStream(t *testing.T) {
+	g := testGate(t, nil)
+	fakes := fakeStreams(t, g, "s1:9000")
+	ci := &connInfo{g: g, id: newCID(), cred: &Credential{Username: "bob"}}
+
+	if err := unsubToStream(ci, "a/b", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !fakes["s1:9000"].has("UnSubscribe a/b") {
+		t.Errorf("UnSubscribe isn't called")
+	}
+}
+
+func Test_shareToStream(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+	conf.Mqtt.QosMax = 1
+
+	fakes := fakeStreams(t, g, "s1:9000")
+	ci := &connInfo{g: g, id: newCID(), cred: &Credential{Username: "bob", ClientID: "c1"}, subs: make(map[string]byte)}
+
+	qos, err := subToStream(ci, "$share/g1/a/b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci.subs["$share/g1/a/b"] = qos
+	ci.subs["c/d"] = qos
+
+	fs := fakes["s1:9000"]
+	if !fs.has("Subscribe a/b group=g1") {
+		t.Errorf("the group isn't sent with the real filter: %v", fs.calls)
+	}
+
+	// closing the session leaves the group, the normal subscriptions are dropped by the stream
+	if err := streamLogout(ci); err != nil {
+		t.Fatal(err)
+	}
+	if !fs.has("UnSubscribe a/b group=g1") || fs.has("UnSubscribe c/d") {
+		t.Errorf("streamLogout() calls = %v, want leaving the group only", fs.calls)
+	}
+}
+
+func Test_streamRouter_updateBreakers(t *testing.T) {
+	g := testGate(t, nil)
+	b := newBreaker(10, time.Second)
+	g.streams.clients["s1:9000"] = &Rpc{g: g, breaker: b}
+
+	// the reloaded settings reach the existing clients
+	conf := &Config{}
+	conf.Stream.BreakerFailures = 1
+	conf.Stream.BreakerTimeout = 60
+	g.conf.Store(conf)
+	g.streams.updateBreakers()
+
+	b.failure(time.Now())
+	if err := b.allow(time.Now().Add(30 * time.Second)); err != errBreakerOpen {
+		t.Errorf("allow() = %v, want %v", err, errBreakerOpen)
+	}
+}
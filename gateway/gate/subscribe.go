@@ -2,19 +2,67 @@ package gate
 
 import (
 	proto "github.com/aiyun/gomqtt/mqtt/protocol"
-	"github.com/aiyun/gomqtt/mqtt/service"
+	"github.com/corego/tools"
+	"github.com/uber-go/zap"
 )
 
 func subscribe(ci *connInfo, p *proto.SubscribePacket) error {
 	var rets []byte
 
 	for i, t := range p.Topics() {
-		qos, err := subToStream(t, p.Qos()[i])
-		if err != nil {
+		// the acl applies to the real filter of a shared subscription
+		_, filter, ok := splitShare(tools.Bytes2String(t))
+		if !ok {
+			ci.g.logger.Info("invalid shared subscription", zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(t)))
+			rets = append(rets, proto.QosFailure)
+			continue
+		}
+
+		if !ci.g.aclCheck(ci.cred, aclSub, filter) {
+			stats.aclSubDenied.Inc()
+			ci.g.logger.Info("subscribe denied", zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(t)))
 
+			rets = append(rets, proto.QosFailure)
+			continue
 		}
 
+		// resubscribing replaces the old one, so it isn't counted
+		if _, ok := ci.subs[string(t)]; !ok {
+			if act, reason := ci.limits.checkSubscribe(len(ci.subs)); act != "" {
+				if onLimit(ci, act, reason) {
+					return errLimitExceeded
+				}
+
+				rets = append(rets, proto.QosFailure)
+				continue
+			}
+		}
+
+		qos, err := ci.g.getHooks().onSubscribe(ci, tools.Bytes2String(t), p.Qos()[i])
+		if err == ErrHookDisconnect {
+			ci.closing(closeHook)
+			return err
+		} else if err != nil {
+			rets = append(rets, proto.QosFailure)
+			continue
+		}
+
+		qos, err = subToStream(ci, tools.Bytes2String(t), qos)
+		if err != nil {
+			ci.g.logger.Warn("subscribe error", zap.Error(err), zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(t)))
+			rets = append(rets, proto.QosFailure)
+			continue
+		}
+
+		ci.slock.Lock()
+		ci.subs[string(t)] = qos
+		ci.slock.Unlock()
 		rets = append(rets, qos)
+
+		ev := newEvent(eventSubscribed, ci)
+		ev.Topic = string(t)
+		ev.Qos = qos
+		ci.g.emitEvent(ev)
 	}
 
 	// give back the suback
@@ -23,19 +71,67 @@ func subscribe(ci *connInfo, p *proto.SubscribePacket) error {
 
 	// return the final qos level
 	pb.AddReturnCodes(rets)
-	service.WritePacket(ci.c, pb)
+	ci.write(pb)
 
 	return nil
 }
 
 func unsubscribe(ci *connInfo, p *proto.UnsubscribePacket) error {
+	for _, t := range p.Topics() {
+		ci.slock.Lock()
+		qos, ok := ci.subs[string(t)]
+		delete(ci.subs, string(t))
+		ci.slock.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if err := unsubToStream(ci, string(t), qos); err != nil {
+			ci.g.logger.Warn("unsubscribe error", zap.Error(err), zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(t)))
+		}
+	}
+
 	pb := proto.NewUnsubackPacket()
 	pb.SetPacketID(p.PacketID())
 
-	service.WritePacket(ci.c, pb)
+	ci.write(pb)
 	return nil
 }
 
-func subToStream(t []byte, qos byte) (byte, error) {
-	return 1, nil
+// subToStream subscribes the filter on the streams it's routed to, the granted qos is returned
+func subToStream(ci *connInfo, filter string, qos byte) (byte, error) {
+	cs, err := ci.g.streams.forFilter(filter)
+	if err != nil {
+		return proto.QosFailure, err
+	}
+
+	if qos > ci.g.Config().Mqtt.QosMax {
+		qos = ci.g.Config().Mqtt.QosMax
+	}
+
+	tm := tcMsg(ci, filter, qos)
+	for _, c := range cs {
+		if err := c.Subscribe(tm); err != nil {
+			return proto.QosFailure, err
+		}
+	}
+
+	return qos, nil
+}
+
+func unsubToStream(ci *connInfo, filter string, qos byte) error {
+	cs, err := ci.g.streams.forFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	tm := tcMsg(ci, filter, qos)
+	for _, c := range cs {
+		if err := c.UnSubscribe(tm); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -50,8 +50,9 @@ func Test_unsubscribe(t *testing.T) {
 
 func Test_subToStream(t *testing.T) {
 	type args struct {
-		t   []byte
-		qos byte
+		ci     *connInfo
+		filter string
+		qos    byte
 	}
 	tests := []struct {
 		name    string
@@ -63,7 +64,7 @@ func Test_subToStream(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := subToStream(tt.args.t, tt.args.qos)
+			got, err := subToStream(tt.args.ci, tt.args.filter, tt.args.qos)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("subToStream() error = %v, wantErr %v", err, tt.wantErr)
 				return
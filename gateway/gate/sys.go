@@ -0,0 +1,88 @@
+package gate
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/zap"
+)
+
+// the root of the broker statistics, the clients can't publish to it and
+// only the acl rules allowing it explicitly can subscribe to it
+const sysPrefix = "$SYS/"
+
+var startTime = time.Now()
+
+func isSysTopic(topic string) bool {
+	return strings.HasPrefix(topic, sysPrefix) || topic == "$SYS"
+}
+
+// sysStats returns the topics under $SYS/<room>/ and their values
+func (g *Gate) sysStats() map[string]string {
+	list := g.conns.list()
+	subs := 0
+	for _, ci := range list {
+		ci.slock.RLock()
+		subs += len(ci.subs)
+		ci.slock.RUnlock()
+	}
+
+	return map[string]string{
+		"version":             g.Config().Common.Version,
+		"uptime":              strconv.FormatInt(int64(time.Since(startTime).Seconds()), 10),
+		"clients/connected":   strconv.Itoa(len(list)),
+		"subscriptions/count": strconv.Itoa(subs),
+		"messages/received":   strconv.FormatInt(stats.msgsReceived.Load(), 10),
+		"messages/sent":       strconv.FormatInt(stats.msgsSent.Load(), 10),
+		"bytes/received":      strconv.FormatInt(stats.bytesReceived.Load(), 10),
+		"bytes/sent":          strconv.FormatInt(stats.bytesSent.Load(), 10),
+	}
+}
+
+// sysPublish publishes the retained statistics every [mqtt] sys_interval seconds, 0 disables it
+func (g *Gate) sysPublish(ctx context.Context) {
+	room := g.host()
+	for {
+		interval := g.Config().Mqtt.SysInterval
+		wait := time.Duration(interval) * time.Second
+		if interval <= 0 {
+			wait = 60 * time.Second
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if interval <= 0 {
+			continue
+		}
+
+		for k, v := range g.sysStats() {
+			p := sysPacket(room, k, v)
+			if p == nil {
+				continue
+			}
+
+			// there is no client, the message is routed as the broker itself
+			if err := g.pubToStream(nil, p); err != nil {
+				g.logger.Warn("publish $SYS error", zap.Error(err), zap.String("topic", k))
+				break
+			}
+		}
+	}
+}
+
+func sysPacket(room, name, value string) *proto.PublishPacket {
+	p := proto.NewPublishPacket()
+	if err := p.SetTopic([]byte(sysPrefix + room + "/" + name)); err != nil {
+		return nil
+	}
+
+	p.SetRetain(true)
+	p.SetPayload([]byte(value))
+	return p
+}
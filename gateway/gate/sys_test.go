@@ -0,0 +1,43 @@
+package gate
+
+import (
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+func Test_sysStats(t *testing.T) {
+	conf := &Config{}
+	g := testGate(t, conf)
+	conf.Common.Version = "1.2.3"
+
+	got := g.sysStats()
+	if got["version"] != "1.2.3" {
+		t.Errorf("version = %q, want %q", got["version"], "1.2.3")
+	}
+	for _, k := range []string{"uptime", "clients/connected", "subscriptions/count", "messages/received", "messages/sent", "bytes/received", "bytes/sent"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("missing %s", k)
+		}
+	}
+}
+
+func Test_sysPacket(t *testing.T) {
+	p := sysPacket("room1", "uptime", "10")
+	if p == nil {
+		t.Fatal("sysPacket() = nil")
+	}
+
+	topic := string(p.Topic())
+	if topic != "$SYS/room1/uptime" || !p.Retain() || string(p.Payload()) != "10" {
+		t.Errorf("sysPacket() = %v", p)
+	}
+
+	// ordinary wildcards don't receive the statistics
+	if proto.TopicMatch("#", topic) || proto.TopicMatch("+/room1/uptime", topic) {
+		t.Errorf("first level wildcard matches %s", topic)
+	}
+	if !proto.TopicMatch("$SYS/+/uptime", topic) {
+		t.Errorf("$SYS/+/uptime doesn't match %s", topic)
+	}
+}
@@ -3,6 +3,7 @@ package gate
 import (
 	"io"
 	"net"
+	"os"
 	"strings"
 	"time"
 
@@ -13,56 +14,91 @@ import (
 	"github.com/uber-go/zap"
 )
 
-/* Websocket Provider */
+/* Tcp Provider, serves tcp, tls and unix socket */
 
 type TcpProvider struct {
+	l  *listener
+	ln net.Listener
 }
 
-func (tp *TcpProvider) Start() {
+func (tp *TcpProvider) Start() error {
 	var ln net.Listener
 	var err error
 
-	if !Conf.Provider.EnableTls { //start tcp
-		ln, err = net.Listen("tcp", Conf.Provider.TcpAddr)
+	logger := tp.l.g.logger
+	lc := tp.l.getConf()
+	switch lc.Protocol {
+	case "tcp": //start tcp
+		ln, err = net.Listen("tcp", lc.Addr)
 		if err != nil {
-			Logger.Fatal("tcp Listen", zap.Error(err))
+			return err
 		}
+		ln = tp.l.wrap(ln)
 
-		Logger.Debug("tcp provider startted", zap.String("addr", Conf.Provider.TcpAddr))
+		logger.Debug("tcp provider startted", zap.String("addr", lc.Addr))
 
-	} else { // start tls
-		cert, err := tls.LoadX509KeyPair(Conf.Provider.TlsCert, Conf.Provider.TlsKey)
+	case "tls": // start tls
+		config, err := newTLSConfig(tp.l)
 		if err != nil {
-			Logger.Fatal("tls load cert", zap.Error(err))
-			return
+			return fmt.Errorf("tls load config: %v", err)
 		}
 
-		config := &tls.Config{Certificates: []tls.Certificate{cert}}
-		ln, err = tls.Listen("tcp", Conf.Provider.TcpAddr, config)
+		raw, err := net.Listen("tcp", lc.Addr)
 		if err != nil {
-			Logger.Fatal("tls listen", zap.Error(err))
-			return
+			return err
 		}
 
-		Logger.Debug("tls provider startted", zap.String("addr", Conf.Provider.TcpAddr))
+		// the PROXY protocol header comes before the tls handshake
+		ln = tls.NewListener(tp.l.wrap(raw), config)
+
+		logger.Debug("tls provider startted", zap.String("addr", lc.Addr))
+
+	case "unix": // start unix socket
+		// the socket file left by the last run
+		os.Remove(lc.Addr)
+
+		ln, err = net.Listen("unix", lc.Addr)
+		if err != nil {
+			return err
+		}
+		ln = tp.l.wrap(ln)
+
+		logger.Debug("unix provider startted", zap.String("addr", lc.Addr))
 	}
 
+	tp.ln = ln
+
 	// start accepting
+	go tp.serve()
+
+	return nil
+}
+
+func (tp *TcpProvider) serve() {
 	for {
-		c, err := accept(ln)
+		c, err := accept(tp.ln)
 		if err != nil {
-			Logger.Warn("accept tcp connection error", zap.Error(err))
+			if err == io.EOF {
+				// the listener is closed
+				return
+			}
+
+			tp.l.g.logger.Warn("accept tcp connection error", zap.Error(err), zap.String("listener", tp.l.name))
 			if c != nil {
 				c.Close()
 			}
+			continue
 		}
 
-		go serve(c)
+		go serve(tp.l, c)
 	}
 }
 
 func (tp *TcpProvider) Close() error {
-	return nil
+	if tp.ln == nil {
+		return nil
+	}
+	return tp.ln.Close()
 }
 
 func accept(ln net.Listener) (net.Conn, error) {
@@ -85,7 +121,7 @@ func accept(ln net.Listener) (net.Conn, error) {
 	}
 
 	if c == nil {
-		Logger.Fatal("BUG: net.Listener returned (nil, nil)")
+		return nil, fmt.Errorf("BUG: net.Listener returned (nil, nil)")
 	}
 
 	return c, nil
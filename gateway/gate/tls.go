@@ -0,0 +1,296 @@
+package gate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	errCertRevoked = errors.New("tls: client certificate has been revoked")
+	errCRLOutdated = errors.New("tls: crl is outdated, reload a new one")
+)
+
+// newTLSConfig builds the tls config of the tls and wss listeners
+func newTLSConfig(l *listener) (*tls.Config, error) {
+	lc := l.getConf()
+
+	// the certificates are picked in each handshake, so the reloaded ones are used by new connections
+	if err := l.certs.init(lc); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{GetCertificate: l.certs.getCertificate}
+
+	switch strings.ToLower(lc.TlsClientAuth) {
+	case "", "none":
+		return config, nil
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "required":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls client auth: %s", lc.TlsClientAuth)
+	}
+
+	pool, cas, err := loadCAs(lc.TlsCas)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = pool
+
+	if len(lc.TlsCrls) > 0 {
+		crls, err := loadCRLs(lc.TlsCrls, cas)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyPeerCertificate = crls.verify
+	}
+
+	return config, nil
+}
+
+// loadCAs reads the pem ca bundles used to verify the client certificates
+func loadCAs(files []string) (*x509.CertPool, []*x509.Certificate, error) {
+	if len(files) == 0 {
+		return nil, nil, errors.New("tls: client auth is enabled but no ca is configured")
+	}
+
+	pool := x509.NewCertPool()
+	var cas []*x509.Certificate
+	for _, f := range files {
+		certs, err := readCerts(f)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, c := range certs {
+			pool.AddCert(c)
+		}
+		cas = append(cas, certs...)
+	}
+
+	return pool, cas, nil
+}
+
+func readCerts(file string) ([]*x509.Certificate, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		certs = append(certs, c)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificate found", file)
+	}
+
+	return certs, nil
+}
+
+// crlSet holds the revoked serial numbers, keyed by issuer and serial
+type crlSet struct {
+	files []string
+	cas   []*x509.Certificate
+
+	sync.RWMutex
+	serials map[string]struct{}
+	// the earliest NextUpdate of the crls, zero if none has one
+	nextUpdate time.Time
+}
+
+// loadCRLs reads the pem or der crl files, each crl must be signed by one of the cas
+func loadCRLs(files []string, cas []*x509.Certificate) (*crlSet, error) {
+	s := &crlSet{files: files, cas: cas}
+	if err := s.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the files again, the set isn't changed on error
+func (s *crlSet) load(now time.Time) error {
+	serials := make(map[string]struct{})
+	var nextUpdate time.Time
+	for _, f := range s.files {
+		contents, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+
+		if block, _ := pem.Decode(contents); block != nil {
+			contents = block.Bytes
+		}
+
+		rl, err := x509.ParseRevocationList(contents)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+
+		signed := false
+		for _, ca := range s.cas {
+			if rl.CheckSignatureFrom(ca) == nil {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			return fmt.Errorf("%s: crl is not signed by any configured ca", f)
+		}
+
+		if !rl.NextUpdate.IsZero() {
+			if rl.NextUpdate.Before(now) {
+				return fmt.Errorf("%s: crl is outdated since %v", f, rl.NextUpdate)
+			}
+			if nextUpdate.IsZero() || rl.NextUpdate.Before(nextUpdate) {
+				nextUpdate = rl.NextUpdate
+			}
+		}
+
+		for _, r := range rl.RevokedCertificateEntries {
+			serials[crlKey(rl.RawIssuer, r.SerialNumber.String())] = struct{}{}
+		}
+	}
+
+	s.Lock()
+	s.serials = serials
+	s.nextUpdate = nextUpdate
+	s.Unlock()
+	return nil
+}
+
+func crlKey(issuer []byte, serial string) string {
+	return string(issuer) + "/" + serial
+}
+
+// revoked is called with the read lock held
+func (s *crlSet) revoked(c *x509.Certificate) bool {
+	_, ok := s.serials[crlKey(c.RawIssuer, c.SerialNumber.String())]
+	return ok
+}
+
+// verify is called after the chains are verified, any revoked certificate in the chain fails the handshake
+func (s *crlSet) verify(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	return s.check(chains, time.Now())
+}
+
+// check fails closed after the crls are outdated, the revocations since then are unknown.
+// The files are read again then, they may have been replaced by the new crls.
+func (s *crlSet) check(chains [][]*x509.Certificate, now time.Time) error {
+	if len(chains) == 0 {
+		return nil
+	}
+
+	s.RLock()
+	next := s.nextUpdate
+	s.RUnlock()
+	if !next.IsZero() && now.After(next) {
+		if err := s.load(now); err != nil {
+			return errCRLOutdated
+		}
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	for _, chain := range chains {
+		for _, c := range chain {
+			if s.revoked(c) {
+				return errCertRevoked
+			}
+		}
+	}
+
+	return nil
+}
+
+// stapleOCSP attaches the der ocsp response of the server certificate, the response must be good and fresh
+func stapleOCSP(cert *tls.Certificate, file string) error {
+	der, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	// the issuer is needed to check the signature of the response
+	var issuer *x509.Certificate
+	if len(cert.Certificate) > 1 {
+		if issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+			return err
+		}
+	}
+
+	resp, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	if resp.Status != ocsp.Good {
+		return fmt.Errorf("%s: server certificate status is not good", file)
+	}
+
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(time.Now()) {
+		return fmt.Errorf("%s: ocsp response is outdated since %v", file, resp.NextUpdate)
+	}
+
+	cert.OCSPStaple = der
+	return nil
+}
+
+// peerCerts returns the verified client certificates of the connection
+func peerCerts(c net.Conn) []*x509.Certificate {
+	switch conn := c.(type) {
+	case *tls.Conn:
+		return conn.ConnectionState().PeerCertificates
+	case *wsConn:
+		return conn.certs
+	}
+
+	return nil
+}
+
+// certIdentity maps the client certificate to a username, according to TlsIdentity of the listener:
+// cn uses the subject common name, san uses the first dns name or email address.
+func certIdentity(c *x509.Certificate, mode string) string {
+	switch strings.ToLower(mode) {
+	case "cn":
+		return c.Subject.CommonName
+	case "san":
+		if len(c.DNSNames) > 0 {
+			return c.DNSNames[0]
+		}
+		if len(c.EmailAddresses) > 0 {
+			return c.EmailAddresses[0]
+		}
+	}
+
+	return ""
+}
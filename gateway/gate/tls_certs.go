@@ -0,0 +1,235 @@
+package gate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber-go/zap"
+)
+
+// certSet is the loaded server certificates, it's replaced as a whole on reload
+type certSet struct {
+	// the settings the certificates are loaded from
+	conf *ListenerConf
+	// TlsCert and TlsKey, used when no name matches
+	def *tls.Certificate
+	// lower case dns name -> certificate, wildcard names are kept as "*.example.com"
+	names map[string]*tls.Certificate
+	// modification time of the files when they are loaded
+	mods map[string]time.Time
+}
+
+type certStore struct {
+	logger zap.Logger
+
+	// serializes the loading
+	sync.Mutex
+	set      atomic.Value
+	watching bool
+	// stops the watching when the listener is closed
+	stopped chan struct{}
+}
+
+// init loads the certificates when the provider starts
+func (cs *certStore) init(lc *ListenerConf) error {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.get() != nil {
+		return nil
+	}
+
+	if err := cs.loadLocked(lc); err != nil {
+		return err
+	}
+
+	if lc.TlsWatch > 0 && !cs.watching {
+		cs.watching = true
+		cs.stopped = make(chan struct{})
+		go cs.watch(time.Duration(lc.TlsWatch)*time.Second, cs.stopped)
+	}
+
+	return nil
+}
+
+func (cs *certStore) get() *certSet {
+	set, _ := cs.set.Load().(*certSet)
+	return set
+}
+
+// reload replaces the certificates, the old ones are kept if anything goes wrong
+func (cs *certStore) reload(lc *ListenerConf) error {
+	cs.Lock()
+	defer cs.Unlock()
+
+	return cs.loadLocked(lc)
+}
+
+func (cs *certStore) loadLocked(lc *ListenerConf) error {
+	set, err := loadCertSet(lc)
+	if err != nil {
+		return err
+	}
+
+	cs.storeLocked(set)
+	return nil
+}
+
+// store replaces the certificates with the ones loaded by the config reloading
+func (cs *certStore) store(set *certSet) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.storeLocked(set)
+}
+
+func (cs *certStore) storeLocked(set *certSet) {
+	cs.set.Store(set)
+	cs.logger.Info("tls certificates loaded", zap.String("addr", set.conf.Addr), zap.Int("names", len(set.names)))
+}
+
+// watch polls the modification time of the files, and reloads the certificates when any of them changes
+func (cs *certStore) watch(interval time.Duration, stopped chan struct{}) {
+	for {
+		select {
+		case <-time.After(interval):
+		case <-stopped:
+			return
+		}
+
+		set := cs.get()
+		if !set.changed() {
+			continue
+		}
+
+		// the files may be half written, the next tick will retry
+		if err := cs.reload(set.conf); err != nil {
+			cs.logger.Warn("reload tls certificates error, keep the old ones", zap.Error(err))
+		}
+	}
+}
+
+// stop ends the watching
+func (cs *certStore) stop() {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.watching {
+		close(cs.stopped)
+		cs.watching = false
+	}
+}
+
+func (set *certSet) changed() bool {
+	for f, mod := range set.mods {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		if !fi.ModTime().Equal(mod) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func loadCertSet(lc *ListenerConf) (*certSet, error) {
+	set := &certSet{
+		conf:  lc,
+		names: make(map[string]*tls.Certificate),
+		mods:  make(map[string]time.Time),
+	}
+
+	def, err := set.add(lc.TlsCert, lc.TlsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.TlsOcsp != "" {
+		if err := stapleOCSP(def, lc.TlsOcsp); err != nil {
+			return nil, err
+		}
+		set.stat(lc.TlsOcsp)
+	}
+	set.def = def
+
+	for _, p := range lc.TlsSni {
+		if _, err := set.add(p.Cert, p.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	return set, nil
+}
+
+// add loads a key pair and indexes it by the names in the certificate
+func (set *certSet) add(certFile, keyFile string) (*tls.Certificate, error) {
+	// stat before reading, so a change during the loading will be found in the next check
+	set.stat(certFile)
+	set.stat(keyFile)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	names := leaf.DNSNames
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = []string{leaf.Subject.CommonName}
+	}
+
+	for _, n := range names {
+		n = strings.ToLower(n)
+		// the first certificate wins
+		if _, ok := set.names[n]; !ok {
+			set.names[n] = &cert
+		}
+	}
+
+	return &cert, nil
+}
+
+func (set *certSet) stat(f string) {
+	if fi, err := os.Stat(f); err == nil {
+		set.mods[f] = fi.ModTime()
+	}
+}
+
+// getCertificate selects the certificate by SNI: the exact name, then the wildcard one, then the default one
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := cs.get()
+	if set == nil {
+		return nil, errors.New("tls: no certificate loaded")
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return set.def, nil
+	}
+
+	if c, ok := set.names[name]; ok {
+		return c, nil
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if c, ok := set.names["*"+name[i:]]; ok {
+			return c, nil
+		}
+	}
+
+	return set.def, nil
+}
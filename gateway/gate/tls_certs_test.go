@@ -0,0 +1,122 @@
+package gate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/naoina/toml"
+)
+
+func writeKeyPair(t *testing.T, dir, name string, tc *testCert) (string, string) {
+	keyDer, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := filepath.Join(dir, name+".pem")
+	key := filepath.Join(dir, name+".key")
+	writePem(t, cert, "CERTIFICATE", tc.cert.Raw)
+	writePem(t, key, "EC PRIVATE KEY", keyDer)
+
+	return cert, key
+}
+
+func Test_certStore_getCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	def := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "gateway"}}, nil)
+	a := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2), DNSNames: []string{"a.example.com"}}, nil)
+	b := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(3), DNSNames: []string{"*.b.example.com"}}, nil)
+
+	defCert, defKey := writeKeyPair(t, dir, "default", def)
+	aCert, aKey := writeKeyPair(t, dir, "a", a)
+	bCert, bKey := writeKeyPair(t, dir, "b", b)
+
+	conf := `
+[[provider]]
+protocol = "tls"
+addr = "127.0.0.1:0"
+tls_cert = "` + defCert + `"
+tls_key = "` + defKey + `"
+
+[[provider.tls_sni]]
+cert = "` + aCert + `"
+key = "` + aKey + `"
+
+[[provider.tls_sni]]
+cert = "` + bCert + `"
+key = "` + bKey + `"
+`
+	c := &Config{}
+	if err := toml.Unmarshal([]byte(conf), c); err != nil {
+		t.Fatal(err)
+	}
+
+	lc := c.Provider[0]
+	cs := &certStore{logger: testLogger}
+	if err := cs.init(lc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want *testCert
+	}{
+		{"", def},
+		{"a.example.com", a},
+		{"A.Example.Com.", a},
+		{"x.b.example.com", b},
+		{"x.y.b.example.com", def},
+		{"unknown.com", def},
+	}
+	for _, tt := range tests {
+		got, err := cs.getCertificate(&tls.ClientHelloInfo{ServerName: tt.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Leaf.SerialNumber.Cmp(tt.want.cert.SerialNumber) != 0 {
+			t.Errorf("getCertificate(%q) = serial %v, want %v", tt.name, got.Leaf.SerialNumber, tt.want.cert.SerialNumber)
+		}
+	}
+
+	// rotate the default certificate
+	rotated := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(4), Subject: pkix.Name{CommonName: "gateway"}}, nil)
+	writeKeyPair(t, dir, "default", rotated)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(defCert, later, later)
+
+	if !cs.get().changed() {
+		t.Fatalf("changed() = false after the certificate is rewritten")
+	}
+
+	if err := cs.reload(lc); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := cs.getCertificate(&tls.ClientHelloInfo{})
+	if got.Leaf.SerialNumber.Cmp(rotated.cert.SerialNumber) != 0 {
+		t.Errorf("getCertificate() after reload = serial %v, want %v", got.Leaf.SerialNumber, rotated.cert.SerialNumber)
+	}
+
+	// a broken file keeps the old certificates
+	ioutil.WriteFile(defKey, []byte("broken"), 0600)
+	if err := cs.reload(lc); err == nil {
+		t.Errorf("reload() with a broken key should fail")
+	}
+
+	got, _ = cs.getCertificate(&tls.ClientHelloInfo{})
+	if got.Leaf.SerialNumber.Cmp(rotated.cert.SerialNumber) != 0 {
+		t.Errorf("getCertificate() after failed reload = serial %v, want %v", got.Leaf.SerialNumber, rotated.cert.SerialNumber)
+	}
+}
@@ -0,0 +1,264 @@
+package gate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{c, key}
+}
+
+func (tc *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.cert.Raw}, PrivateKey: tc.key, Leaf: tc.cert}
+}
+
+// tcpPair returns both ends of a loopback connection, unlike net.Pipe writes are buffered
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sc, cc
+}
+
+func writePem(t *testing.T, path, typ string, der []byte) {
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_certIdentity(t *testing.T) {
+	c := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "device-1"},
+		DNSNames:       []string{"device-1.plant.local"},
+		EmailAddresses: []string{"ops@plant.local"},
+	}
+
+	tests := []struct {
+		mode string
+		cert *x509.Certificate
+		want string
+	}{
+		{"cn", c, "device-1"},
+		{"san", c, "device-1.plant.local"},
+		{"san", &x509.Certificate{EmailAddresses: []string{"ops@plant.local"}}, "ops@plant.local"},
+		{"", c, ""},
+	}
+	for _, tt := range tests {
+		if got := certIdentity(tt.cert, tt.mode); got != tt.want {
+			t.Errorf("certIdentity(%q) = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func Test_newTLSConfig_clientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil)
+	server := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "gateway"},
+		DNSNames:     []string{"gateway"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "device-1"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	revoked := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "device-2"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: revoked.cert.SerialNumber, RevocationTime: time.Now()},
+		},
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(server.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePem(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.cert.Raw)
+	writePem(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", server.cert.Raw)
+	writePem(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", keyDer)
+	writePem(t, filepath.Join(dir, "crl.pem"), "X509 CRL", crl)
+
+	l, err := newListener(testGate(t, nil), &ListenerConf{
+		Protocol:      "tls",
+		Addr:          "127.0.0.1:0",
+		TlsCert:       filepath.Join(dir, "cert.pem"),
+		TlsKey:        filepath.Join(dir, "key.pem"),
+		TlsClientAuth: "optional",
+		TlsCas:        []string{filepath.Join(dir, "ca.pem")},
+		TlsCrls:       []string{filepath.Join(dir, "crl.pem")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := newTLSConfig(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		wantErr bool
+		wantCN  string
+	}{
+		{"valid cert", []tls.Certificate{client.tlsCert()}, false, "device-1"},
+		{"revoked cert", []tls.Certificate{revoked.tlsCert()}, true, ""},
+		{"no cert", nil, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, cc := tcpPair(t)
+			defer sc.Close()
+			defer cc.Close()
+
+			srv := tls.Server(sc, config)
+			cli := tls.Client(cc, &tls.Config{ServerName: "gateway", RootCAs: roots, Certificates: tt.certs})
+
+			done := make(chan error, 1)
+			go func() {
+				err := cli.Handshake()
+				if err != nil {
+					cc.Close()
+				}
+				done <- err
+			}()
+
+			err := srv.Handshake()
+			if err != nil {
+				sc.Close()
+			}
+			<-done
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Handshake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var cn string
+			if certs := peerCerts(srv); len(certs) > 0 {
+				cn = certs[0].Subject.CommonName
+			}
+			if cn != tt.wantCN {
+				t.Errorf("peerCerts() cn = %q, want %q", cn, tt.wantCN)
+			}
+		})
+	}
+
+	// the loaded crl isn't trusted after its next update
+	crls, err := loadCRLs([]string{filepath.Join(dir, "crl.pem")}, []*x509.Certificate{ca.cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := [][]*x509.Certificate{{client.cert, ca.cert}}
+	if err := crls.check(chains, time.Now()); err != nil {
+		t.Errorf("check() = %v, want nil", err)
+	}
+	if err := crls.check(chains, time.Now().Add(2*time.Hour)); err != errCRLOutdated {
+		t.Errorf("check() after next update = %v, want %v", err, errCRLOutdated)
+	}
+
+	// a new crl replacing the file is read when the old one is outdated
+	crl, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(2),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(3 * time.Hour),
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePem(t, filepath.Join(dir, "crl.pem"), "X509 CRL", crl)
+	if err := crls.check(chains, time.Now().Add(2*time.Hour)); err != nil {
+		t.Errorf("check() with the new crl = %v, want nil", err)
+	}
+
+	l.conf.TlsClientAuth = "required"
+	l.conf.TlsCas = nil
+	if _, err := newTLSConfig(l); err == nil {
+		t.Errorf("newTLSConfig() without ca should fail")
+	}
+}
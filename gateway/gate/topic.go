@@ -0,0 +1,95 @@
+package gate
+
+import "strings"
+
+// filterCovers reports whether every topic matched by sub is also matched by filter
+func filterCovers(filter, sub string) bool {
+	fs := strings.Split(filter, "/")
+	ss := strings.Split(sub, "/")
+
+	for i, f := range fs {
+		if f == "#" {
+			// '#' in the first level doesn't cover '$' topics
+			return !(i == 0 && strings.HasPrefix(sub, "$"))
+		}
+
+		if i >= len(ss) {
+			return false
+		}
+
+		switch {
+		case f == "+":
+			if ss[i] == "#" || (i == 0 && strings.HasPrefix(sub, "$")) {
+				return false
+			}
+		case f != ss[i]:
+			return false
+		}
+	}
+
+	return len(fs) == len(ss)
+}
+
+// filterOverlaps reports whether there is a topic matched by both filters
+func filterOverlaps(a, b string) bool {
+	if strings.HasPrefix(a, "$") || strings.HasPrefix(b, "$") {
+		if !strings.HasPrefix(a, "$") {
+			a, b = b, a
+		}
+		// a starts with '$', a first level wildcard in b can't match it
+		if strings.HasPrefix(b, "+") || strings.HasPrefix(b, "#") {
+			return false
+		}
+	}
+
+	as := strings.Split(a, "/")
+	bs := strings.Split(b, "/")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == "#" || bs[i] == "#" {
+			return true
+		}
+
+		if as[i] != "+" && bs[i] != "+" && as[i] != bs[i] {
+			return false
+		}
+	}
+
+	if len(as) == len(bs) {
+		return true
+	}
+
+	// "a/#" also matches "a"
+	if len(as) == len(bs)+1 && as[len(as)-1] == "#" {
+		return true
+	}
+	if len(bs) == len(as)+1 && bs[len(bs)-1] == "#" {
+		return true
+	}
+
+	return false
+}
+
+// sharePrefix starts a shared subscription: $share/<group>/<filter>
+const sharePrefix = "$share/"
+
+// splitShare returns the group and the real filter of a shared subscription,
+// the group is empty for the normal ones. ok is false if the shared subscription is invalid.
+func splitShare(filter string) (group, real string, ok bool) {
+	if !strings.HasPrefix(filter, sharePrefix) {
+		return "", filter, true
+	}
+
+	rest := filter[len(sharePrefix):]
+	i := strings.IndexByte(rest, '/')
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+
+	group, real = rest[:i], rest[i+1:]
+	if strings.ContainsAny(group, "+#") {
+		return "", "", false
+	}
+
+	return group, real, true
+}
@@ -0,0 +1,76 @@
+package gate
+
+import "testing"
+
+func Test_filterCovers(t *testing.T) {
+	tests := []struct {
+		filter string
+		sub    string
+		want   bool
+	}{
+		{"a/#", "a/b/+", true},
+		{"a/#", "a/#", true},
+		{"a/#", "a", true},
+		{"a/+", "a/b", true},
+		{"a/+", "a/#", false},
+		{"a/+", "a/b/c", false},
+		{"a/b", "a/+", false},
+		{"#", "#", true},
+		{"#", "$SYS/#", false},
+		{"+/#", "$SYS/a", false},
+		{"$SYS/#", "$SYS/a", true},
+	}
+	for _, tt := range tests {
+		if got := filterCovers(tt.filter, tt.sub); got != tt.want {
+			t.Errorf("filterCovers(%q, %q) = %v, want %v", tt.filter, tt.sub, got, tt.want)
+		}
+	}
+}
+
+func Test_filterOverlaps(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "+/b", true},
+		{"a/secret", "#", true},
+		{"a/secret", "a/#", true},
+		{"a/#", "a", true},
+		{"a/b/c", "a/+", false},
+		{"$SYS/#", "#", false},
+		{"$SYS/#", "+/clients", false},
+		{"$SYS/#", "$SYS/clients", true},
+	}
+	for _, tt := range tests {
+		if got := filterOverlaps(tt.a, tt.b); got != tt.want {
+			t.Errorf("filterOverlaps(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func Test_splitShare(t *testing.T) {
+	tests := []struct {
+		filter    string
+		wantGroup string
+		wantReal  string
+		wantOk    bool
+	}{
+		{"a/b", "", "a/b", true},
+		{"$share/g1/a/b", "g1", "a/b", true},
+		{"$share/g1/#", "g1", "#", true},
+		{"$share/g1/", "", "", false},
+		{"$share//a", "", "", false},
+		{"$share/g1", "", "", false},
+		{"$share/g+/a", "", "", false},
+		{"$SYS/#", "", "$SYS/#", true},
+	}
+	for _, tt := range tests {
+		group, real, ok := splitShare(tt.filter)
+		if group != tt.wantGroup || real != tt.wantReal || ok != tt.wantOk {
+			t.Errorf("splitShare(%q) = %q, %q, %v, want %q, %q, %v", tt.filter, group, real, ok, tt.wantGroup, tt.wantReal, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package gate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/labstack/echo"
+	"github.com/uber-go/atomic"
+	"github.com/uber-go/zap"
+)
+
+// the ttl of the traces, in seconds
+const (
+	defaultTraceTTL = 300
+	maxTraceTTL     = 86400
+)
+
+// the records buffered for a slow sse client, the newer ones are dropped when it's full
+const traceStreamBuffer = 1024
+
+// the bytes of the payload kept in a record
+const tracePayloadMax = 1024
+
+// the direction of the traced packets
+const (
+	traceIn  = "in"
+	traceOut = "out"
+)
+
+var (
+	errTraceSelector = errors.New("client_id, username or ip is required")
+	errTraceTTL      = fmt.Errorf("ttl must be between 1 and %d seconds", maxTraceTTL)
+	errTraceDir      = errors.New("admin trace_dir is not configured")
+	errTraceFile     = errors.New("file must be a plain file name")
+)
+
+// traceReq selects the connections to trace, all the given fields must match
+type traceReq struct {
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+	// the name of the file in the admin trace_dir, the records are only streamed when it's empty
+	File string `json:"file"`
+	// seconds
+	TTL int `json:"ttl"`
+}
+
+// trace records the packets of the selected connections until it expires or is stopped
+type trace struct {
+	id      int
+	req     traceReq
+	created time.Time
+	expires time.Time
+	timer   *time.Timer
+	file    *fileSink
+
+	// the sse clients, lock guards them and stopped
+	lock    sync.Mutex
+	subs    map[chan []byte]struct{}
+	stopped bool
+
+	packets atomic.Int64
+	dropped atomic.Int64
+}
+
+type traceInfo struct {
+	ID       int       `json:"id"`
+	ClientID string    `json:"client_id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	IP       string    `json:"ip,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Packets  int64     `json:"packets"`
+	Dropped  int64     `json:"dropped"`
+	Streams  int       `json:"streams"`
+}
+
+// traceRecord is one json line of the trace, the password of CONNECT is never recorded
+type traceRecord struct {
+	Time     time.Time `json:"time"`
+	Dir      string    `json:"dir"`
+	ConnID   int       `json:"conn_id"`
+	ClientID string    `json:"client_id"`
+	Username string    `json:"username,omitempty"`
+	IP       string    `json:"ip"`
+	Type     string    `json:"type"`
+	Packet   string    `json:"packet"`
+	// the payload of PUBLISH, hex if it's not utf-8
+	Payload string `json:"payload,omitempty"`
+}
+
+// traceSet is the running traces of a gateway
+type traceSet struct {
+	sync.RWMutex
+	list   map[int]*trace
+	lastID int
+
+	// len(list), it's checked for every packet without the lock
+	active atomic.Int32
+}
+
+// startTrace validates the request and starts a trace expiring after its ttl
+func (g *Gate) startTrace(req *traceReq) (*trace, error) {
+	if req.ClientID == "" && req.Username == "" && req.IP == "" {
+		return nil, errTraceSelector
+	}
+
+	if req.TTL == 0 {
+		req.TTL = defaultTraceTTL
+	}
+	if req.TTL < 0 || req.TTL > maxTraceTTL {
+		return nil, errTraceTTL
+	}
+
+	t := &trace{
+		req:     *req,
+		created: time.Now(),
+		subs:    make(map[chan []byte]struct{}),
+	}
+	t.expires = t.created.Add(time.Duration(req.TTL) * time.Second)
+
+	if req.File != "" {
+		dir := g.Config().Admin.TraceDir
+		if dir == "" {
+			return nil, errTraceDir
+		}
+		// the api can't write outside of the trace dir
+		if filepath.Base(req.File) != req.File || req.File == "." || req.File == ".." {
+			return nil, errTraceFile
+		}
+
+		f, err := openFileSink(filepath.Join(dir, req.File), g.logger)
+		if err != nil {
+			return nil, err
+		}
+		t.file = f
+	}
+
+	ts := &g.traces
+	ts.Lock()
+	if ts.list == nil {
+		ts.list = make(map[int]*trace)
+	}
+	ts.lastID++
+	t.id = ts.lastID
+	// set under the lock, stopTrace may be called as soon as the trace is listed
+	t.timer = time.AfterFunc(time.Duration(req.TTL)*time.Second, func() {
+		g.logger.Info("trace expired", zap.Int("trace", t.id))
+		g.stopTrace(t.id)
+	})
+	ts.list[t.id] = t
+	ts.active.Store(int32(len(ts.list)))
+	ts.Unlock()
+
+	return t, nil
+}
+
+// stopTrace removes the trace, closes its file and ends its sse clients
+func (g *Gate) stopTrace(id int) *trace {
+	ts := &g.traces
+	ts.Lock()
+	t, ok := ts.list[id]
+	delete(ts.list, id)
+	ts.active.Store(int32(len(ts.list)))
+	ts.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	t.timer.Stop()
+	if t.file != nil {
+		t.file.close()
+	}
+
+	t.lock.Lock()
+	t.stopped = true
+	for ch := range t.subs {
+		close(ch)
+		delete(t.subs, ch)
+	}
+	t.lock.Unlock()
+
+	return t
+}
+
+func (g *Gate) traceList() []*trace {
+	g.traces.RLock()
+	defer g.traces.RUnlock()
+
+	list := make([]*trace, 0, len(g.traces.list))
+	for _, t := range g.traces.list {
+		list = append(list, t)
+	}
+	return list
+}
+
+func (g *Gate) getTrace(id int) *trace {
+	g.traces.RLock()
+	defer g.traces.RUnlock()
+	return g.traces.list[id]
+}
+
+// tracePacket records the packet if the connection is traced
+func (g *Gate) tracePacket(ci *connInfo, dir string, p proto.Packet) {
+	ts := &g.traces
+	if ts.active.Load() == 0 {
+		return
+	}
+
+	var rec []byte
+	ts.RLock()
+	for _, t := range ts.list {
+		if !t.match(ci) {
+			continue
+		}
+
+		// the record is encoded once for all the traces
+		if rec == nil {
+			b, err := json.Marshal(newTraceRecord(ci, dir, p))
+			if err != nil {
+				break
+			}
+			rec = b
+		}
+		t.write(rec)
+	}
+	ts.RUnlock()
+}
+
+func newTraceRecord(ci *connInfo, dir string, p proto.Packet) *traceRecord {
+	rec := &traceRecord{
+		Time:     time.Now(),
+		Dir:      dir,
+		ConnID:   ci.id,
+		IP:       ci.ip,
+		Type:     p.Name(),
+		ClientID: traceClientID(ci),
+		Username: traceUsername(ci),
+	}
+
+	if pp, ok := p.(*proto.PublishPacket); ok {
+		// the payload is kept out of the packet string, which prints it as a byte list
+		rec.Packet = fmt.Sprintf("Topic=%q, Packet ID=%d, QoS=%d, Retained=%t, Dup=%t, Payload Len=%d",
+			pp.Topic(), pp.PacketID(), pp.QoS(), pp.Retain(), pp.Dup(), len(pp.Payload()))
+		rec.Payload = payloadString(pp.Payload(), tracePayloadMax)
+	} else {
+		// the password is redacted by ConnectPacket.String
+		rec.Packet = fmt.Sprint(p)
+	}
+
+	return rec
+}
+
+func traceClientID(ci *connInfo) string {
+	if ci.cp == nil {
+		return ""
+	}
+	return string(ci.cp.ClientId())
+}
+
+// the username may be mapped by the authentication, it's taken from CONNECT before that
+func traceUsername(ci *connInfo) string {
+	if ci.cred != nil {
+		return ci.cred.Username
+	}
+	if ci.cp == nil {
+		return ""
+	}
+	return string(ci.cp.Username())
+}
+
+func (t *trace) match(ci *connInfo) bool {
+	return (t.req.ClientID == "" || t.req.ClientID == traceClientID(ci)) &&
+		(t.req.Username == "" || t.req.Username == traceUsername(ci)) &&
+		(t.req.IP == "" || t.req.IP == ci.ip)
+}
+
+// write never blocks the connection, the records are dropped for the full sse clients
+func (t *trace) write(rec []byte) {
+	t.packets.Inc()
+
+	if t.file != nil {
+		if err := t.file.write(rec); err != nil {
+			t.dropped.Inc()
+		}
+	}
+
+	t.lock.Lock()
+	for ch := range t.subs {
+		select {
+		case ch <- rec:
+		default:
+			t.dropped.Inc()
+		}
+	}
+	t.lock.Unlock()
+}
+
+// subscribe adds an sse client, nil is returned if the trace is stopped
+func (t *trace) subscribe() chan []byte {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.stopped {
+		return nil
+	}
+	ch := make(chan []byte, traceStreamBuffer)
+	t.subs[ch] = struct{}{}
+	return ch
+}
+
+func (t *trace) unsubscribe(ch chan []byte) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.subs[ch]; ok {
+		delete(t.subs, ch)
+		close(ch)
+	}
+}
+
+func (t *trace) info() traceInfo {
+	t.lock.Lock()
+	streams := len(t.subs)
+	t.lock.Unlock()
+
+	return traceInfo{
+		ID:       t.id,
+		ClientID: t.req.ClientID,
+		Username: t.req.Username,
+		IP:       t.req.IP,
+		File:     t.req.File,
+		Created:  t.created,
+		Expires:  t.expires,
+		Packets:  t.packets.Load(),
+		Dropped:  t.dropped.Load(),
+		Streams:  streams,
+	}
+}
+
+// findTrace returns the trace of the :id parameter
+func (g *Gate) findTrace(c echo.Context) (*trace, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid trace id")
+	}
+
+	t := g.getTrace(id)
+	if t == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "trace not found")
+	}
+	return t, nil
+}
+
+func (g *Gate) traceStart(c echo.Context) error {
+	req := &traceReq{}
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	t, err := g.startTrace(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	g.logger.Info("trace started", zap.Int("trace", t.id), zap.String("client_id", req.ClientID), zap.String("username", req.Username),
+		zap.String("ip", req.IP), zap.String("file", req.File), zap.Int("ttl", req.TTL), zap.String("from", c.RealIP()))
+
+	return c.JSON(http.StatusCreated, t.info())
+}
+
+func (g *Gate) tracesList(c echo.Context) error {
+	list := g.traceList()
+	infos := make([]traceInfo, 0, len(list))
+	for _, t := range list {
+		infos = append(infos, t.info())
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return c.JSON(http.StatusOK, infos)
+}
+
+func (g *Gate) traceStop(c echo.Context) error {
+	t, err := g.findTrace(c)
+	if err != nil {
+		return err
+	}
+
+	if t = g.stopTrace(t.id); t == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "trace not found")
+	}
+
+	g.logger.Info("trace stopped", zap.Int("trace", t.id), zap.String("from", c.RealIP()))
+	return c.JSON(http.StatusOK, t.info())
+}
+
+// traceStream sends the records as server-sent events until the trace ends or the client goes away
+func (g *Gate) traceStream(c echo.Context) error {
+	t, err := g.findTrace(c)
+	if err != nil {
+		return err
+	}
+
+	ch := t.subscribe()
+	if ch == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "trace not found")
+	}
+	defer t.unsubscribe(ch)
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	done := c.Request().Context().Done()
+	for {
+		select {
+		case rec, ok := <-ch:
+			if !ok {
+				fmt.Fprint(w, "event: end\ndata: trace ended\n\n")
+				w.Flush()
+				return nil
+			}
+			fmt.Fprintf(w, "data: %s\n\n", rec)
+			w.Flush()
+		case <-done:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package gate
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+func useTraceDir(t *testing.T) (*Gate, *Config) {
+	conf := &Config{}
+	conf.Admin.Token = "secret"
+	conf.Admin.TraceDir = t.TempDir()
+
+	g := testGate(t, conf)
+	t.Cleanup(func() {
+		for _, tr := range g.traceList() {
+			g.stopTrace(tr.id)
+		}
+	})
+	return g, conf
+}
+
+func Test_startTrace(t *testing.T) {
+	g, conf := useTraceDir(t)
+
+	tests := []struct {
+		name    string
+		req     traceReq
+		wantErr error
+	}{
+		{"no selector", traceReq{TTL: 10}, errTraceSelector},
+		{"ttl too long", traceReq{ClientID: "c1", TTL: maxTraceTTL + 1}, errTraceTTL},
+		{"file out of the dir", traceReq{ClientID: "c1", File: "../c1.log"}, errTraceFile},
+		{"ok", traceReq{IP: "1.2.3.4", File: "ip.log"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := g.startTrace(&tt.req)
+			if err != tt.wantErr {
+				t.Fatalf("g.startTrace() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && tr.expires.Sub(tr.created) != defaultTraceTTL*time.Second {
+				t.Errorf("ttl = %v, want the default", tr.expires.Sub(tr.created))
+			}
+		})
+	}
+
+	conf.Admin.TraceDir = ""
+	if _, err := g.startTrace(&traceReq{ClientID: "c1", File: "c1.log"}); err != errTraceDir {
+		t.Errorf("g.startTrace() without trace_dir error = %v, want %v", err, errTraceDir)
+	}
+}
+
+func Test_tracePacket(t *testing.T) {
+	g, conf := useTraceDir(t)
+	dir := conf.Admin.TraceDir
+
+	tr, err := g.startTrace(&traceReq{ClientID: "trace1", File: "trace1.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := proto.NewConnectPacket()
+	cp.SetClientId([]byte("trace1"))
+	cp.SetUsername([]byte("bob"))
+	cp.SetPassword([]byte("secretpw"))
+	ci := &connInfo{g: g, id: newCID(), cp: cp, ip: "1.2.3.4"}
+
+	p := proto.NewPublishPacket()
+	p.SetTopic([]byte("a/b"))
+	p.SetPayload([]byte("hello"))
+
+	g.tracePacket(ci, traceIn, cp)
+	g.tracePacket(ci, traceIn, p)
+	g.tracePacket(ci, traceOut, proto.NewPingrespPacket())
+
+	// another client isn't traced
+	other := &connInfo{g: g, id: newCID(), cp: proto.NewConnectPacket(), ip: "1.2.3.4"}
+	g.tracePacket(other, traceIn, p)
+
+	if n := tr.info().Packets; n != 3 {
+		t.Errorf("packets = %d, want 3", n)
+	}
+
+	// the packets after the trace stops aren't recorded
+	g.stopTrace(tr.id)
+	g.tracePacket(ci, traceIn, p)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "trace1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secretpw") {
+		t.Errorf("the password is recorded: %s", b)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("records = %q, want 3", lines)
+	}
+
+	var rec traceRecord
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Dir != traceIn || rec.Type != "PUBLISH" || rec.ClientID != "trace1" || rec.Username != "bob" || rec.Payload != "hello" || rec.Time.IsZero() {
+		t.Errorf("record = %+v, want the inbound PUBLISH of trace1", rec)
+	}
+}
+
+func Test_trace_expire(t *testing.T) {
+	g, _ := useTraceDir(t)
+
+	tr, err := g.startTrace(&traceReq{Username: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.timer.Reset(time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for g.getTrace(tr.id) != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if g.getTrace(tr.id) != nil || g.traces.active.Load() != 0 {
+		t.Errorf("the trace didn't expire")
+	}
+	if tr.subscribe() != nil {
+		t.Errorf("subscribe() to an expired trace succeeded")
+	}
+}
+
+func Test_trace_stopWhileStarting(t *testing.T) {
+	g, _ := useTraceDir(t)
+
+	// the traces are stopped as soon as they're listed, like DELETE /traces/:id or the shutdown
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; {
+			for _, tr := range g.traceList() {
+				if g.stopTrace(tr.id) != nil {
+					i++
+				}
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if _, err := g.startTrace(&traceReq{Username: "bob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func Test_adminTraces(t *testing.T) {
+	g, _ := useTraceDir(t)
+
+	rec := adminRequest(t, g, "POST", "/traces", "secret", `{"client_id":"trace2","ttl":60}`)
+	var info traceInfo
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &info) != nil || info.ClientID != "trace2" {
+		t.Fatalf("POST /traces = %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := adminRequest(t, g, "POST", "/traces", "secret", `{"ttl":60}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST /traces without a selector = %d, want 400", rec.Code)
+	}
+
+	var infos []traceInfo
+	rec = adminRequest(t, g, "GET", "/traces", "secret", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil || len(infos) != 1 {
+		t.Errorf("GET /traces = %s, want 1 trace", rec.Body.String())
+	}
+
+	// the records are streamed as server-sent events
+	srv := httptest.NewServer(g.newAdmin())
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/traces/"+strconv.Itoa(info.ID)+"/stream", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// wait for the stream to subscribe
+	deadline := time.Now().Add(time.Second)
+	for g.getTrace(info.ID).info().Streams == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ci := &connInfo{g: g, id: newCID(), cp: proto.NewConnectPacket(), ip: "1.2.3.4"}
+	ci.cp.SetClientId([]byte("trace2"))
+	g.tracePacket(ci, traceOut, proto.NewPingrespPacket())
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: ") || !strings.Contains(line, "PINGRESP") {
+		t.Fatalf("stream line = %q, %v, want the PINGRESP record", line, err)
+	}
+
+	if rec := adminRequest(t, g, "DELETE", "/traces/"+strconv.Itoa(info.ID), "secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("DELETE /traces/%d = %d", info.ID, rec.Code)
+	}
+
+	rest, _ := ioutil.ReadAll(r)
+	if !strings.Contains(string(rest), "event: end") {
+		t.Errorf("stream end = %q, want the end event", rest)
+	}
+}
@@ -1,5 +1,48 @@
 package gate
 
-func userValidate(u []byte, p []byte) bool {
-	return true
+import (
+	"net"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+// userValidate authenticates the client through the authenticator chain
+func userValidate(ci *connInfo) proto.ConnackCode {
+	cred := &Credential{
+		ClientID: string(ci.cp.ClientId()),
+		Username: string(ci.cp.Username()),
+		Password: ci.cp.Password(),
+		IP:       ci.ip,
+	}
+
+	// the handshake is done while reading CONNECT, the certificates are verified already
+	if certs := peerCerts(ci.c); len(certs) > 0 {
+		cred.CertCN = certs[0].Subject.CommonName
+		cred.CertVerified = true
+
+		// the identity of the certificate replaces the username
+		if id := certIdentity(certs[0], ci.l.getConf().TlsIdentity); id != "" {
+			cred.Username = id
+			ci.cp.SetUsername([]byte(id))
+		}
+	}
+
+	code := ci.l.authChain().Authenticate(cred)
+
+	// the password is useless after authentication
+	cred.Password = nil
+	ci.cred = cred
+
+	return code
+}
+
+// addrIP strips the port of the address
+func addrIP(addr net.Addr) string {
+	s := addr.String()
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return s
+	}
+
+	return host
 }
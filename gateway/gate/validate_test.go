@@ -1,22 +1,25 @@
 package gate
 
-import "testing"
+import (
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
 
 func Test_userValidate(t *testing.T) {
 	type args struct {
-		u []byte
-		p []byte
+		ci *connInfo
 	}
 	tests := []struct {
 		name string
 		args args
-		want bool
+		want proto.ConnackCode
 	}{
 	// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := userValidate(tt.args.u, tt.args.p); got != tt.want {
+			if got := userValidate(tt.args.ci); got != tt.want {
 				t.Errorf("userValidate() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,379 @@
+package gate
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/uber-go/atomic"
+	"github.com/uber-go/zap"
+)
+
+// the events posted to the webhooks
+const (
+	eventConnected    = "connected"
+	eventDisconnected = "disconnected"
+	eventSubscribed   = "subscribed"
+	eventPublished    = "published"
+)
+
+// the defaults of WebhookConf
+const (
+	defaultWebhookBatch   = 100
+	defaultWebhookWait    = 1000
+	defaultWebhookQueue   = 10000
+	defaultWebhookRetries = 3
+	defaultWebhookBackoff = 500
+	defaultWebhookTimeout = 5
+)
+
+// the header carrying the hex hmac-sha256 of the body
+const webhookSignature = "X-Gomqtt-Signature"
+
+// event is one item of the json array posted to the webhooks
+type event struct {
+	Event    string `json:"event"`
+	Time     int64  `json:"time"`
+	ConnID   int    `json:"conn_id"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+	Topic    string `json:"topic,omitempty"`
+	Qos      byte   `json:"qos"`
+	Retain   bool   `json:"retain,omitempty"`
+	// base64 in json
+	Payload []byte `json:"payload,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// the rule sending the message
+	Rule string `json:"rule,omitempty"`
+}
+
+func newEvent(name string, ci *connInfo) *event {
+	ev := &event{Event: name, Time: time.Now().UnixNano() / int64(time.Millisecond), ConnID: ci.id, IP: ci.ip}
+	if ci.cred != nil {
+		ev.ClientID = ci.cred.ClientID
+		ev.Username = ci.cred.Username
+	}
+	return ev
+}
+
+// webhook posts the events of one [[webhook]], the events are dropped when the queue is full,
+// so a slow endpoint never blocks the connections
+type webhook struct {
+	conf   *WebhookConf
+	logger zap.Logger
+	events map[string]bool
+	client *http.Client
+
+	queue chan *event
+	stop  chan struct{}
+	done  chan struct{}
+
+	queued  atomic.Int64
+	dropped atomic.Int64
+	sent    atomic.Int64
+	failed  atomic.Int64
+	retries atomic.Int64
+	lastErr atomic.String
+}
+
+// webhookInfo is the stats reported by the admin api
+type webhookInfo struct {
+	Name    string `json:"name"`
+	Url     string `json:"url"`
+	Queued  int64  `json:"queued"`
+	Pending int    `json:"pending"`
+	Dropped int64  `json:"dropped"`
+	Sent    int64  `json:"sent"`
+	Failed  int64  `json:"failed"`
+	Retries int64  `json:"retries"`
+	LastErr string `json:"last_error,omitempty"`
+}
+
+// webhookList is replaced as a whole on reload
+type webhookList struct {
+	sync.RWMutex
+	list []*webhook
+}
+
+func (g *Gate) getWebhooks() []*webhook {
+	g.webhooks.RLock()
+	defer g.webhooks.RUnlock()
+	return g.webhooks.list
+}
+
+// newWebhooks builds the webhooks, they're started by swapWebhooks
+func newWebhooks(confs []*WebhookConf, logger zap.Logger) ([]*webhook, error) {
+	var whs []*webhook
+	for _, wc := range confs {
+		wh, err := newWebhook(wc, logger)
+		if err != nil {
+			return nil, fmt.Errorf("init webhook %s: %v", wc.Name, err)
+		}
+		whs = append(whs, wh)
+	}
+	return whs, nil
+}
+
+// swapWebhooks starts the new webhooks, the old ones post their queued events and stop
+func (g *Gate) swapWebhooks(whs []*webhook) {
+	for _, wh := range whs {
+		go wh.run()
+	}
+
+	g.webhooks.Lock()
+	old := g.webhooks.list
+	g.webhooks.list = whs
+	g.webhooks.Unlock()
+
+	for _, wh := range old {
+		close(wh.stop)
+	}
+}
+
+// stopWebhooks posts the queued events and stops the webhooks, false is returned on timeout
+func (g *Gate) stopWebhooks(timeout time.Duration) bool {
+	g.webhooks.Lock()
+	old := g.webhooks.list
+	g.webhooks.list = nil
+	g.webhooks.Unlock()
+
+	deadline := time.After(timeout)
+	for _, wh := range old {
+		close(wh.stop)
+	}
+	for _, wh := range old {
+		select {
+		case <-wh.done:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+func newWebhook(wc *WebhookConf, logger zap.Logger) (*webhook, error) {
+	if wc.Url == "" {
+		return nil, fmt.Errorf("url is empty")
+	}
+
+	wh := &webhook{
+		conf:   wc,
+		logger: logger,
+		events: make(map[string]bool),
+		client: &http.Client{Timeout: time.Duration(orDefault(wc.Timeout, defaultWebhookTimeout)) * time.Second},
+		queue:  make(chan *event, orDefault(wc.QueueSize, defaultWebhookQueue)),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	for _, e := range wc.Events {
+		switch e {
+		case eventConnected, eventDisconnected, eventSubscribed, eventPublished:
+			wh.events[e] = true
+		default:
+			return nil, fmt.Errorf("invalid event %s", e)
+		}
+	}
+
+	return wh, nil
+}
+
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// emitEvent queues the event to the webhooks interested in it
+func (g *Gate) emitEvent(ev *event) {
+	for _, wh := range g.getWebhooks() {
+		if wh.match(ev) {
+			wh.push(ev)
+		}
+	}
+}
+
+// push queues the event without blocking, false is returned if it's dropped
+func (wh *webhook) push(ev *event) bool {
+	select {
+	case wh.queue <- ev:
+		wh.queued.Inc()
+		return true
+	default:
+		wh.dropped.Inc()
+		return false
+	}
+}
+
+// match filters by the event types, and the topic filters for the published and subscribed events
+func (wh *webhook) match(ev *event) bool {
+	if len(wh.events) > 0 && !wh.events[ev.Event] {
+		return false
+	}
+
+	if ev.Topic == "" || len(wh.conf.Topics) == 0 {
+		return true
+	}
+
+	for _, f := range wh.conf.Topics {
+		// a subscription matches if it can receive the topics of the filter
+		if (ev.Event == eventSubscribed && filterOverlaps(f, ev.Topic)) || proto.TopicMatch(f, ev.Topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// run posts the events in batches, a batch is sent when it's full or has waited for BatchWait
+func (wh *webhook) run() {
+	defer close(wh.done)
+
+	size := orDefault(wh.conf.BatchSize, defaultWebhookBatch)
+	wait := time.Duration(orDefault(wh.conf.BatchWait, defaultWebhookWait)) * time.Millisecond
+
+	batch := make([]*event, 0, size)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) > 0 {
+			wh.post(batch)
+			batch = make([]*event, 0, size)
+		}
+	}
+
+	for {
+		select {
+		case ev := <-wh.queue:
+			batch = append(batch, ev)
+			if len(batch) >= size {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+			timer.Reset(wait)
+		case <-wh.stop:
+			// the events queued before the reload are still posted
+			for {
+				select {
+				case ev := <-wh.queue:
+					batch = append(batch, ev)
+					if len(batch) >= size {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// post sends the batch, retrying with backoff
+func (wh *webhook) post(batch []*event) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		wh.fail(len(batch), err)
+		return
+	}
+
+	retries := wh.conf.Retries
+	switch {
+	case retries == 0:
+		retries = defaultWebhookRetries
+	case retries < 0:
+		retries = 0
+	}
+	backoff := time.Duration(orDefault(wh.conf.Backoff, defaultWebhookBackoff)) * time.Millisecond
+	for i := 0; ; i++ {
+		err = wh.send(body)
+		if err == nil {
+			wh.sent.Add(int64(len(batch)))
+			return
+		}
+
+		if i >= retries {
+			break
+		}
+		wh.retries.Inc()
+		// stopping doesn't wait for the backoff, the batch fails at once
+		if !wh.sleep(backoff << uint(i)) {
+			break
+		}
+	}
+
+	wh.fail(len(batch), err)
+}
+
+// sleep waits for the backoff, false is returned if the webhook is stopped
+func (wh *webhook) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-wh.stop:
+		return false
+	}
+}
+
+func (wh *webhook) fail(n int, err error) {
+	wh.failed.Add(int64(n))
+	wh.lastErr.Store(err.Error())
+
+	wh.logger.Warn("webhook post error", zap.String("name", wh.conf.Name), zap.Int("events", n), zap.Error(err))
+}
+
+func (wh *webhook) send(body []byte) error {
+	req, err := http.NewRequest("POST", wh.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if wh.conf.Secret != "" {
+		req.Header.Set(webhookSignature, signBody(wh.conf.Secret, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signBody returns the hex hmac-sha256 of the body
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *webhook) info() webhookInfo {
+	return webhookInfo{
+		Name:    wh.conf.Name,
+		Url:     wh.conf.Url,
+		Queued:  wh.queued.Load(),
+		Pending: len(wh.queue),
+		Dropped: wh.dropped.Load(),
+		Sent:    wh.sent.Load(),
+		Failed:  wh.failed.Load(),
+		Retries: wh.retries.Load(),
+		LastErr: wh.lastErr.Load(),
+	}
+}
@@ -0,0 +1,198 @@
+package gate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the posted events, the first fails requests fail with 500
+type webhookServer struct {
+	*httptest.Server
+
+	sync.Mutex
+	fails   int
+	batches [][]*event
+	sigs    []string
+}
+
+func newWebhookServer(t *testing.T, fails int) *webhookServer {
+	ws := &webhookServer{fails: fails}
+	ws.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.Lock()
+		defer ws.Unlock()
+
+		if ws.fails > 0 {
+			ws.fails--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		var batch []*event
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Errorf("invalid body %s", body)
+		}
+		if sig := r.Header.Get(webhookSignature); sig != "" && sig != signBody("secret", body) {
+			t.Errorf("signature = %s, want %s", sig, signBody("secret", body))
+		}
+
+		ws.batches = append(ws.batches, batch)
+		ws.sigs = append(ws.sigs, r.Header.Get(webhookSignature))
+	}))
+	t.Cleanup(ws.Close)
+	return ws
+}
+
+func (ws *webhookServer) events() int {
+	ws.Lock()
+	defer ws.Unlock()
+
+	n := 0
+	for _, b := range ws.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func useWebhooks(t *testing.T, g *Gate, wcs ...*WebhookConf) {
+	t.Cleanup(func() { g.stopWebhooks(time.Second) })
+
+	whs, err := newWebhooks(wcs, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.swapWebhooks(whs)
+}
+
+func Test_webhook_match(t *testing.T) {
+	wh, err := newWebhook(&WebhookConf{Url: "http://x", Events: []string{eventPublished, eventSubscribed}, Topics: []string{"a/#"}}, testLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ev   *event
+		want bool
+	}{
+		{&event{Event: eventPublished, Topic: "a/b"}, true},
+		{&event{Event: eventPublished, Topic: "b/c"}, false},
+		{&event{Event: eventSubscribed, Topic: "+/b"}, true},
+		{&event{Event: eventConnected}, false},
+	}
+	for _, tt := range tests {
+		if got := wh.match(tt.ev); got != tt.want {
+			t.Errorf("match(%v) = %v, want %v", tt.ev, got, tt.want)
+		}
+	}
+
+	if _, err := newWebhook(&WebhookConf{Url: "http://x", Events: []string{"nope"}}, testLogger); err == nil {
+		t.Errorf("newWebhook() with an invalid event succeeded")
+	}
+}
+
+func Test_webhook_post(t *testing.T) {
+	g := testGate(t, nil)
+	ws := newWebhookServer(t, 1)
+	useWebhooks(t, g, &WebhookConf{Name: "w1", Url: ws.URL, Secret: "secret", BatchSize: 2, BatchWait: 50, Backoff: 1})
+
+	ci := &connInfo{g: g, id: newCID(), ip: "1.2.3.4", cred: &Credential{ClientID: "c1", Username: "bob"}}
+	for i := 0; i < 3; i++ {
+		g.emitEvent(newEvent(eventConnected, ci))
+	}
+
+	// the full batch and the one waiting for BatchWait, sent is counted after the response
+	deadline := time.Now().Add(2 * time.Second)
+	for g.getWebhooks()[0].info().Sent < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ws.Lock()
+	if len(ws.batches) != 2 || len(ws.batches[0]) != 2 || ws.batches[0][0].ClientID != "c1" {
+		t.Errorf("batches = %v, want 2 batches of c1", ws.batches)
+	}
+	if len(ws.sigs) == 0 || ws.sigs[0] == "" {
+		t.Errorf("the batches aren't signed")
+	}
+	ws.Unlock()
+
+	info := g.getWebhooks()[0].info()
+	if info.Sent != 3 || info.Retries != 1 || info.Failed != 0 {
+		t.Errorf("info() = %+v, want 3 sent after 1 retry", info)
+	}
+}
+
+func Test_webhook_bounded(t *testing.T) {
+	g := testGate(t, nil)
+	// nobody listens, the posts fail without retrying
+	useWebhooks(t, g, &WebhookConf{Url: "http://127.0.0.1:1", QueueSize: 2, BatchWait: 10000, Retries: -1})
+
+	ci := &connInfo{g: g, id: newCID()}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			g.emitEvent(newEvent(eventConnected, ci))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("g.emitEvent() blocked on a full queue")
+	}
+
+	if info := g.getWebhooks()[0].info(); info.Dropped == 0 {
+		t.Errorf("info() = %+v, want dropped events", info)
+	}
+}
+
+func Test_stopWebhooks(t *testing.T) {
+	g := testGate(t, nil)
+	ws := newWebhookServer(t, 0)
+	useWebhooks(t, g, &WebhookConf{Url: ws.URL, BatchWait: 10000})
+
+	g.emitEvent(newEvent(eventDisconnected, &connInfo{g: g, id: newCID()}))
+	if !g.stopWebhooks(time.Second) {
+		t.Fatal("g.stopWebhooks() timeout")
+	}
+	if ws.events() != 1 {
+		t.Errorf("events = %d, want the queued one posted", ws.events())
+	}
+}
+
+func Test_stopWebhooks_backoff(t *testing.T) {
+	g := testGate(t, nil)
+	ws := newWebhookServer(t, 100)
+	useWebhooks(t, g, &WebhookConf{Url: ws.URL, BatchSize: 1, Retries: 5, Backoff: 10000})
+
+	g.emitEvent(newEvent(eventDisconnected, &connInfo{g: g, id: newCID()}))
+	deadline := time.Now().Add(time.Second)
+	for g.getWebhooks()[0].info().Retries == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	wh := g.getWebhooks()[0]
+
+	// the retries in backoff don't hold the stopping
+	if !g.stopWebhooks(time.Second) {
+		t.Fatal("g.stopWebhooks() timeout")
+	}
+	if info := wh.info(); info.Failed != 1 {
+		t.Errorf("info() = %+v, want the batch failed", info)
+	}
+}
+
+func Test_adminWebhooks(t *testing.T) {
+	g := testGate(t, nil)
+	useWebhooks(t, g, &WebhookConf{Name: "w1", Url: "http://x"})
+
+	rec := adminRequest(t, g, "GET", "/webhooks", "", "")
+	var infos []webhookInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil || len(infos) != 1 || infos[0].Name != "w1" {
+		t.Errorf("/webhooks = %s, want w1", rec.Body.String())
+	}
+}
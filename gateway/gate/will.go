@@ -0,0 +1,92 @@
+package gate
+
+import (
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/corego/tools"
+	"github.com/uber-go/zap"
+)
+
+// the reasons of connection closing
+const (
+	closeDisconnect   = "disconnect"
+	closeKeepalive    = "keepalive timeout"
+	closeReadError    = "read error"
+	closeTakeover     = "takeover"
+	closeProtocol     = "protocol error"
+	closeAclDenied    = "acl denied"
+	closeShutdown     = "shutdown"
+	closeKicked       = "kicked"
+	closeHook         = "closed by hook"
+	closeRouteFailed  = "route failed"
+	closeHookFailed   = "hook failed"
+	closeWriteTimeout = "write timeout"
+)
+
+// newWill builds the will message from the connect packet, nil will be returned if the will flag is not set
+func newWill(cp *proto.ConnectPacket) (*proto.PublishPacket, error) {
+	if !cp.WillFlag() {
+		return nil, nil
+	}
+
+	will := proto.NewPublishPacket()
+	if err := will.SetTopic(cp.WillTopic()); err != nil {
+		return nil, err
+	}
+
+	if err := will.SetQoS(cp.WillQos()); err != nil {
+		return nil, err
+	}
+
+	will.SetRetain(cp.WillRetain())
+	will.SetPayload(cp.WillMessage())
+
+	return will, nil
+}
+
+// discardWill drops the will message, called when the client disconnects gracefully
+func discardWill(ci *connInfo) {
+	if ci.will == nil {
+		return
+	}
+
+	ci.will = nil
+	stats.willDiscarded.Inc()
+	ci.g.logger.Debug("will discarded", zap.Int("cid", ci.id))
+}
+
+// publishWill routes the will message of an ungracefully closed connection
+func publishWill(ci *connInfo) {
+	if ci.will == nil {
+		return
+	}
+
+	// the client is moving to another room, it's not really offline
+	if ci.closeReason == closeShutdown {
+		discardWill(ci)
+		return
+	}
+
+	will, err := ci.g.getHooks().onWill(ci, ci.will)
+	ci.will = nil
+	if err != nil {
+		stats.willDiscarded.Inc()
+		ci.g.logger.Info("will discarded by hook", zap.Int("cid", ci.id), zap.Error(err))
+		return
+	}
+
+	if !ci.g.aclCheck(ci.cred, aclPub, tools.Bytes2String(will.Topic())) {
+		stats.willDiscarded.Inc()
+		ci.g.logger.Info("will denied", zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(will.Topic())))
+		return
+	}
+
+	if err := ci.g.pubToStream(ci, will); err != nil {
+		stats.willFailed.Inc()
+		ci.g.logger.Warn("publish will error", zap.Error(err), zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(will.Topic())))
+		return
+	}
+
+	stats.willPublished.Inc()
+	ci.g.logger.Info("will published", zap.Int("cid", ci.id), zap.String("topic", tools.Bytes2String(will.Topic())),
+		zap.Int("qos", int(will.QoS())), zap.Bool("retain", will.Retain()), zap.String("reason", ci.closeReason))
+}
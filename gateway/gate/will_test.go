@@ -0,0 +1,66 @@
+package gate
+
+import (
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+)
+
+func Test_newWill(t *testing.T) {
+	noWill := proto.NewConnectPacket()
+
+	withWill := proto.NewConnectPacket()
+	withWill.SetWillFlag(true)
+	withWill.SetWillTopic([]byte("devices/1/status"))
+	withWill.SetWillMessage([]byte("offline"))
+	withWill.SetWillQos(1)
+	withWill.SetWillRetain(true)
+
+	badTopic := proto.NewConnectPacket()
+	badTopic.SetWillFlag(true)
+	badTopic.SetWillTopic([]byte("devices/#"))
+	badTopic.SetWillMessage([]byte("offline"))
+
+	tests := []struct {
+		name       string
+		cp         *proto.ConnectPacket
+		wantNil    bool
+		wantErr    bool
+		wantQos    byte
+		wantRetain bool
+	}{
+		{"no will", noWill, true, false, 0, false},
+		{"will", withWill, false, false, 1, true},
+		{"wildcard topic", badTopic, true, true, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newWill(tt.cp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newWill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("newWill() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if got == nil {
+				return
+			}
+			if string(got.Topic()) != string(tt.cp.WillTopic()) || string(got.Payload()) != string(tt.cp.WillMessage()) {
+				t.Errorf("newWill() = %v, want topic %q payload %q", got, tt.cp.WillTopic(), tt.cp.WillMessage())
+			}
+			if got.QoS() != tt.wantQos || got.Retain() != tt.wantRetain {
+				t.Errorf("newWill() qos = %d retain = %v, want %d %v", got.QoS(), got.Retain(), tt.wantQos, tt.wantRetain)
+			}
+		})
+	}
+}
+
+func Test_connInfo_closing(t *testing.T) {
+	ci := &connInfo{}
+	ci.closing(closeTakeover)
+	ci.closing(closeReadError)
+
+	if ci.closeReason != closeTakeover {
+		t.Errorf("closeReason = %q, want %q", ci.closeReason, closeTakeover)
+	}
+}
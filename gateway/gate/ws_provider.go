@@ -1,13 +1,276 @@
 package gate
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/uber-go/zap"
+)
+
 /* Websocket Provider */
 type WsProvider struct {
+	l        *listener
+	ln       net.Listener
+	srv      *http.Server
+	upgrader websocket.Upgrader
 }
 
-func (tp *WsProvider) Start() {
-    Logger.Debug("websocket provider startted") 
-}
+// the subprotocol required by mqtt over websocket
+const wsSubprotocol = "mqtt"
+
+const (
+	// the upgrade request must arrive in time like CONNECT, so slow clients can't hold the sockets
+	wsHeaderTimeout = 10 * time.Second
+	// keep-alive http connections which never upgrade are closed after it
+	wsIdleTimeout = 30 * time.Second
+)
+
+var errWsTextFrame = errors.New("websocket text frame is not allowed")
+
+func (wp *WsProvider) Start() error {
+	logger := wp.l.g.logger
+	lc := wp.l.getConf()
+
+	path := lc.WsPath
+	if path == "" {
+		path = "/mqtt"
+	}
+
+	wp.upgrader = websocket.Upgrader{
+		Subprotocols: []string{wsSubprotocol},
+		CheckOrigin:  wp.checkOrigin,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, wp.wsHandler)
+
+	wp.srv = &http.Server{
+		Addr:              lc.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: wsHeaderTimeout,
+		IdleTimeout:       wsIdleTimeout,
+	}
+
+	if lc.Protocol == "wss" {
+		config, err := newTLSConfig(wp.l)
+		if err != nil {
+			return fmt.Errorf("wss load config: %v", err)
+		}
+		// websocket can't be upgraded over http/2, the empty TLSNextProto stops ServeTLS from offering h2
+		config.NextProtos = []string{"http/1.1"}
+		wp.srv.TLSConfig = config
+		wp.srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	ln, err := net.Listen("tcp", lc.Addr)
+	if err != nil {
+		return err
+	}
+	ln = wp.l.wrap(ln)
+	wp.ln = ln
+
+	go func() {
+		var err error
+		if lc.Protocol == "ws" { // start ws
+			logger.Debug("websocket provider startted", zap.String("addr", lc.Addr), zap.String("path", path))
+			err = wp.srv.Serve(ln)
+		} else { // start wss
+			logger.Debug("wss provider startted", zap.String("addr", lc.Addr), zap.String("path", path))
+			// the certificate is already in the config
+			err = wp.srv.ServeTLS(ln, "", "")
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("websocket serve error", zap.Error(err), zap.String("listener", wp.l.name))
+		}
+	}()
 
-func (tp *WsProvider) Close() error {
 	return nil
 }
+
+func (wp *WsProvider) Close() error {
+	if wp.srv == nil {
+		return nil
+	}
+	return wp.srv.Close()
+}
+
+func (wp *WsProvider) wsHandler(w http.ResponseWriter, r *http.Request) {
+	// the client must offer the mqtt subprotocol
+	if !hasSubprotocol(r, wsSubprotocol) {
+		wp.l.g.logger.Info("websocket subprotocol not supported", zap.String("ip", r.RemoteAddr), zap.Object("protocols", websocket.Subprotocols(r)))
+		http.Error(w, "mqtt subprotocol is required", http.StatusBadRequest)
+		return
+	}
+
+	ws, err := wp.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		wp.l.g.logger.Info("websocket upgrade error", zap.Error(err), zap.String("ip", r.RemoteAddr))
+		return
+	}
+
+	wc := newWsConn(ws, realAddr(r, xffHops(wp.l.getConf())))
+	if r.TLS != nil {
+		wc.certs = r.TLS.PeerCertificates
+	}
+
+	// the handler is running in its own goroutine, so serve directly
+	serve(wp.l, wc)
+}
+
+func hasSubprotocol(r *http.Request, proto string) bool {
+	for _, p := range websocket.Subprotocols(r) {
+		if p == proto {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkOrigin allows requests without Origin header(not from browsers) and the ones in the allowlist
+func (wp *WsProvider) checkOrigin(r *http.Request) bool {
+	origins := wp.l.getConf().WsOrigins
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(origins) == 0 {
+		return true
+	}
+
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	wp.l.g.logger.Info("websocket origin rejected", zap.String("origin", origin), zap.String("ip", r.RemoteAddr))
+	return false
+}
+
+// xffHops is the trusted proxies in front of the listener, 0 means X-Forwarded-For isn't trusted
+func xffHops(lc *ListenerConf) int {
+	if !lc.WsTrustXff {
+		return 0
+	}
+	if lc.WsXffHops <= 0 {
+		return 1
+	}
+	return lc.WsXffHops
+}
+
+// realAddr returns the client address, X-Forwarded-For is used when hops proxies are trusted.
+// The proxies append to the header, so the left ones are set by the client and can't be trusted,
+// the one appended by the outermost trusted proxy is the client.
+func realAddr(r *http.Request, hops int) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		addr = &net.TCPAddr{}
+	}
+
+	if hops <= 0 {
+		return addr
+	}
+
+	var ips []string
+	for _, xff := range r.Header["X-Forwarded-For"] {
+		ips = append(ips, strings.Split(xff, ",")...)
+	}
+	if len(ips) == 0 {
+		return addr
+	}
+
+	// fewer entries than the proxies, all of them are appended by the trusted ones
+	i := len(ips) - hops
+	if i < 0 {
+		i = 0
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(ips[i]))
+	if ip == nil {
+		return addr
+	}
+
+	return &net.TCPAddr{IP: ip}
+}
+
+// wsConn adapts a websocket connection to net.Conn, mqtt packets can span the binary frames
+type wsConn struct {
+	*websocket.Conn
+
+	// reader of the current frame
+	r      io.Reader
+	remote net.Addr
+	// client certificates of wss
+	certs []*x509.Certificate
+
+	// websocket connections support one concurrent writer
+	wmu sync.Mutex
+}
+
+func newWsConn(ws *websocket.Conn, remote net.Addr) *wsConn {
+	return &wsConn{
+		Conn:   ws,
+		remote: remote,
+	}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for {
+		if c.r == nil {
+			mt, r, err := c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			if mt != websocket.BinaryMessage {
+				return 0, errWsTextFrame
+			}
+			c.r = r
+		}
+
+		n, err := c.r.Read(b)
+		if err == io.EOF {
+			// current frame is drained, move to the next one
+			c.r = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	err := c.WriteMessage(websocket.BinaryMessage, b)
+	c.wmu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
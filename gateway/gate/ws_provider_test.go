@@ -0,0 +1,148 @@
+package gate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	proto "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/mqtt/service"
+	"github.com/gorilla/websocket"
+)
+
+func Test_wsConn_Read(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{wsSubprotocol}}
+
+	got := make(chan proto.Packet, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		pt, _, _, err := service.ReadPacket(newWsConn(ws, realAddr(r, 0)))
+		if err != nil {
+			t.Error(err)
+		}
+		got <- pt
+	}))
+	defer srv.Close()
+
+	d := websocket.Dialer{Subprotocols: []string{wsSubprotocol}}
+	ws, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if ws.Subprotocol() != wsSubprotocol {
+		t.Fatalf("subprotocol = %q, want %q", ws.Subprotocol(), wsSubprotocol)
+	}
+
+	cp := proto.NewConnectPacket()
+	cp.SetVersion(0x4)
+	cp.SetClientId([]byte("browser1"))
+	cp.SetKeepAlive(60)
+	_, buf, err := cp.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// split the packet into two frames
+	ws.WriteMessage(websocket.BinaryMessage, buf[:3])
+	ws.WriteMessage(websocket.BinaryMessage, buf[3:])
+
+	p, ok := (<-got).(*proto.ConnectPacket)
+	if !ok {
+		t.Fatalf("got %T, want *proto.ConnectPacket", p)
+	}
+	if string(p.ClientId()) != "browser1" {
+		t.Errorf("client id = %q, want %q", p.ClientId(), "browser1")
+	}
+}
+
+func Test_realAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		hops int
+		xff  []string
+		want string
+	}{
+		{"untrusted", 0, []string{"1.2.3.4"}, "10.0.0.1"},
+		{"no header", 1, nil, "10.0.0.1"},
+		{"one proxy", 1, []string{"1.2.3.4"}, "1.2.3.4"},
+		// the left one is set by the client
+		{"spoofed", 1, []string{"6.6.6.6, 1.2.3.4"}, "1.2.3.4"},
+		{"two proxies", 2, []string{"6.6.6.6, 1.2.3.4", "10.0.0.2"}, "1.2.3.4"},
+		{"fewer entries than proxies", 2, []string{"1.2.3.4"}, "1.2.3.4"},
+		{"invalid", 1, []string{"unknown"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/mqtt", nil)
+			r.RemoteAddr = "10.0.0.1:5555"
+			for _, xff := range tt.xff {
+				r.Header.Add("X-Forwarded-For", xff)
+			}
+
+			addr := realAddr(r, tt.hops).(*net.TCPAddr)
+			if addr.IP.String() != tt.want {
+				t.Errorf("realAddr() = %v, want %v", addr.IP, tt.want)
+			}
+		})
+	}
+}
+
+func TestWsProvider_Start_wss(t *testing.T) {
+	dir := t.TempDir()
+	server := newTestCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "gateway"},
+		DNSNames:     []string{"gateway"},
+	}, nil)
+	keyDer, err := x509.MarshalECPrivateKey(server.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePem(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", server.cert.Raw)
+	writePem(t, filepath.Join(dir, "key.pem"), "EC PRIVATE KEY", keyDer)
+
+	l, err := newListener(testGate(t, nil), &ListenerConf{
+		Protocol: "wss",
+		Addr:     "127.0.0.1:0",
+		TlsCert:  filepath.Join(dir, "cert.pem"),
+		TlsKey:   filepath.Join(dir, "key.pem"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wp := l.p.(*WsProvider)
+	if err := wp.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer wp.Close()
+
+	// slow clients can't hold the sockets
+	if wp.srv.ReadHeaderTimeout <= 0 || wp.srv.IdleTimeout <= 0 {
+		t.Errorf("ReadHeaderTimeout = %v, IdleTimeout = %v, want both set", wp.srv.ReadHeaderTimeout, wp.srv.IdleTimeout)
+	}
+
+	// h2 isn't negotiated, websocket can't upgrade over it
+	c, err := tls.Dial("tcp", wp.ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2", "http/1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.ConnectionState().NegotiatedProtocol; got != "http/1.1" {
+		t.Errorf("NegotiatedProtocol = %q, want %q", got, "http/1.1")
+	}
+}
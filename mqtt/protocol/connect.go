@@ -59,10 +59,18 @@ func (cp ConnectPacket) String() string {
 		cp.WillTopic(),
 		cp.WillMessage(),
 		cp.Username(),
-		cp.Password(),
+		redactedPassword(cp.Password()),
 	)
 }
 
+// 打印时隐藏密码，避免密码出现在日志中
+func redactedPassword(pw []byte) string {
+	if len(pw) == 0 {
+		return ""
+	}
+	return "******"
+}
+
 // 返回客户端连接服务器时选择的版本号，mqtt3.1.1的协议版本号是4
 func (cp *ConnectPacket) Version() byte {
 	return cp.version
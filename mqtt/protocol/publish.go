@@ -140,10 +140,7 @@ func (pp *PublishPacket) Encode() (int, []byte, error) {
 		return 0, nil, fmt.Errorf("publish/Encode: Topic name is empty.")
 	}
 
-	if len(pp.payload) == 0 {
-		return 0, nil, fmt.Errorf("publish/Encode: Payload is empty.")
-	}
-
+	// payload可以为空, 空的retain消息用来清除保留消息
 	ml := pp.msglen()
 
 	if err := pp.SetRemainingLength(int32(ml)); err != nil {
@@ -0,0 +1,28 @@
+// publish模块单元测试
+package protocol
+
+import (
+	"testing"
+)
+
+func Test_PublishEmptyPayload(t *testing.T) {
+	p := NewPublishPacket()
+	p.SetTopic([]byte("a/b"))
+	p.SetQoS(1)
+	p.SetPacketID(7)
+	p.SetRetain(true)
+
+	// 空的retain消息用来清除保留消息
+	n, buf, err := p.Encode()
+	if err != nil {
+		t.Fatalf("encode publish with empty payload failed: %v", err)
+	}
+
+	d := NewPublishPacket()
+	if _, err := d.Decode(buf[:n]); err != nil {
+		t.Fatalf("decode publish with empty payload failed: %v", err)
+	}
+	if string(d.Topic()) != "a/b" || d.PacketID() != 7 || !d.Retain() || len(d.Payload()) != 0 {
+		t.Errorf("test publish empty payload failed, got: %v", d)
+	}
+}
@@ -0,0 +1,52 @@
+package protocol
+
+import "strings"
+
+// TopicMatch 判断topic是否匹配订阅的filter, gateway和stream共用.
+// 第一层的通配符不匹配以'$'开头的topic, 例如"#"不匹配"$SYS/uptime"
+func TopicMatch(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+
+		if i >= len(ts) {
+			return false
+		}
+
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+
+	return len(fs) == len(ts)
+}
+
+// ValidFilter 验证订阅的filter, '#'只能单独作为最后一层, '+'只能单独作为一层
+func ValidFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch {
+		case l == "#":
+			if i != len(levels)-1 {
+				return false
+			}
+		case l == "+":
+		case strings.ContainsAny(l, "+#"):
+			return false
+		}
+	}
+
+	return true
+}
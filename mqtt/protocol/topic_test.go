@@ -0,0 +1,57 @@
+// topic模块单元测试
+package protocol
+
+import (
+	"testing"
+)
+
+func Test_TopicMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/b/c", "a/b", false},
+		{"a/b", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/#", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "b/c", false},
+		{"#", "a/b", true},
+		{"+/b", "/b", true},
+		{"#", "$SYS/clients", false},
+		{"+/clients", "$SYS/clients", false},
+		{"$SYS/#", "$SYS/clients", true},
+	}
+	for _, tt := range tests {
+		if got := TopicMatch(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("TopicMatch(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func Test_ValidFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"a/b", true},
+		{"a/+/c", true},
+		{"a/#", true},
+		{"#", true},
+		{"+", true},
+		{"/", true},
+		{"", false},
+		{"a/#/b", false},
+		{"a/b#", false},
+		{"a/+b", false},
+		{"##", false},
+	}
+	for _, tt := range tests {
+		if got := ValidFilter(tt.filter); got != tt.want {
+			t.Errorf("ValidFilter(%q) = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
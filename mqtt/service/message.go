@@ -2,14 +2,29 @@ package service
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 
 	proto "github.com/aiyun/gomqtt/mqtt/protocol"
 )
 
+// ErrPacketTooLarge is returned by ReadPacketLimit, the payload of the packet has been skipped
+var ErrPacketTooLarge = errors.New("packet too large")
+
+var errMalformedPublish = errors.New("malformed publish packet")
+
 // ReadPacket read one packet from conn
 func ReadPacket(conn net.Conn) (proto.Packet, []byte, int, error) {
+	return ReadPacketLimit(conn, 0)
+}
+
+// ReadPacketLimit reads one packet like ReadPacket, but the payload of a PUBLISH whose remaining length is larger
+// than max is skipped without buffering it. The PUBLISH is returned without payload with ErrPacketTooLarge,
+// so the sender can still be acked. 0 means no limit
+func ReadPacketLimit(conn net.Conn, max int) (proto.Packet, []byte, int, error) {
 	var (
 		// buf for head
 		b = make([]byte, 5)
@@ -39,6 +54,14 @@ func ReadPacket(conn net.Conn) (proto.Packet, []byte, int, error) {
 	remLen, _ := binary.Uvarint(b[1 : n+1])
 	mtype := proto.PacketType(b[0] >> 4)
 
+	if max > 0 && mtype == proto.PUBLISH && remLen > uint64(max) {
+		p, err := skipPublish(conn, b[0], remLen)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		return p, nil, 0, ErrPacketTooLarge
+	}
+
 	buf := make([]byte, n+1+int(remLen))
 	copy(buf, b[:n+1])
 
@@ -52,7 +75,8 @@ func ReadPacket(conn net.Conn) (proto.Packet, []byte, int, error) {
 		return msg, nil, dn, nil
 	}
 
-	_, err := conn.Read(buf[n+1:]) //[len(b)+1:]
+	// the body may arrive in several segments(or websocket frames), so read until it's full
+	_, err := io.ReadFull(conn, buf[n+1:])
 	if err != nil {
 		return nil, buf, 0, err
 	}
@@ -66,6 +90,44 @@ func ReadPacket(conn net.Conn) (proto.Packet, []byte, int, error) {
 	return msg, nil, dn, nil
 }
 
+// skipPublish reads the variable header(topic and packet id) of a PUBLISH and discards its payload
+func skipPublish(conn net.Conn, flags byte, remLen uint64) (*proto.PublishPacket, error) {
+	qos := (flags >> 1) & 0x03
+	// topic length, and the packet id of qos 1 and 2
+	hl := uint64(2)
+	if qos > 0 {
+		hl += 2
+	}
+
+	var tl [2]byte
+	if _, err := io.ReadFull(conn, tl[:]); err != nil {
+		return nil, err
+	}
+	topicLen := uint64(binary.BigEndian.Uint16(tl[:]))
+	if qos > 2 || topicLen+hl > remLen {
+		return nil, errMalformedPublish
+	}
+
+	vh := make([]byte, topicLen+hl-2)
+	if _, err := io.ReadFull(conn, vh); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, conn, int64(remLen-topicLen-hl)); err != nil {
+		return nil, err
+	}
+
+	p := proto.NewPublishPacket()
+	// an invalid topic is left empty, the message is dropped anyway
+	p.SetTopic(vh[:topicLen])
+	p.SetQoS(qos)
+	p.SetRetain(flags&0x01 == 1)
+	if qos > 0 {
+		p.SetPacketID(binary.BigEndian.Uint16(vh[topicLen:]))
+	}
+	return p, nil
+}
+
 // Read a raw message from conn
 func Read(conn net.Conn) ([]byte, error) {
 	var (
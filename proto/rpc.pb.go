@@ -15,7 +15,13 @@ It has these top-level messages:
 	GChatMsg
 	AccMsg
 	TcMsg
+	PubMsg
+	Delivery
+	DeliverBatch
+	DeliverAck
 	Reply
+	AuthMsg
+	AuthReply
 */
 package proto
 
@@ -92,6 +98,13 @@ func (*AccMsg) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 // 主题消息
 type TcMsg struct {
+	An    string `protobuf:"bytes,1,opt,name=an" json:"an,omitempty"`
+	Cid   string `protobuf:"bytes,2,opt,name=cid" json:"cid,omitempty"`
+	Tp    []byte `protobuf:"bytes,3,opt,name=tp,proto3" json:"tp,omitempty"`
+	Qos   int32  `protobuf:"varint,4,opt,name=qos" json:"qos,omitempty"`
+	Gip   string `protobuf:"bytes,5,opt,name=gip" json:"gip,omitempty"`
+	ConId int64  `protobuf:"varint,6,opt,name=conId" json:"conId,omitempty"`
+	Group string `protobuf:"bytes,7,opt,name=group" json:"group,omitempty"`
 }
 
 func (m *TcMsg) Reset()                    { *m = TcMsg{} }
@@ -99,6 +112,64 @@ func (m *TcMsg) String() string            { return proto1.CompactTextString(m)
 func (*TcMsg) ProtoMessage()               {}
 func (*TcMsg) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
+// 发布消息
+type PubMsg struct {
+	An     string `protobuf:"bytes,1,opt,name=an" json:"an,omitempty"`
+	Cid    string `protobuf:"bytes,2,opt,name=cid" json:"cid,omitempty"`
+	Tp     []byte `protobuf:"bytes,3,opt,name=tp,proto3" json:"tp,omitempty"`
+	Pl     []byte `protobuf:"bytes,4,opt,name=pl,proto3" json:"pl,omitempty"`
+	Qos    int32  `protobuf:"varint,5,opt,name=qos" json:"qos,omitempty"`
+	Retain bool   `protobuf:"varint,6,opt,name=retain" json:"retain,omitempty"`
+	Gip    string `protobuf:"bytes,7,opt,name=gip" json:"gip,omitempty"`
+}
+
+func (m *PubMsg) Reset()                    { *m = PubMsg{} }
+func (m *PubMsg) String() string            { return proto1.CompactTextString(m) }
+func (*PubMsg) ProtoMessage()               {}
+func (*PubMsg) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+// 下行投递的消息，按连接ID或客户端ID寻址
+type Delivery struct {
+	Mid    uint64 `protobuf:"varint,1,opt,name=mid" json:"mid,omitempty"`
+	ConId  int64  `protobuf:"varint,2,opt,name=conId" json:"conId,omitempty"`
+	Cid    string `protobuf:"bytes,3,opt,name=cid" json:"cid,omitempty"`
+	Tp     []byte `protobuf:"bytes,4,opt,name=tp,proto3" json:"tp,omitempty"`
+	Pl     []byte `protobuf:"bytes,5,opt,name=pl,proto3" json:"pl,omitempty"`
+	Qos    int32  `protobuf:"varint,6,opt,name=qos" json:"qos,omitempty"`
+	Retain bool   `protobuf:"varint,7,opt,name=retain" json:"retain,omitempty"`
+}
+
+func (m *Delivery) Reset()                    { *m = Delivery{} }
+func (m *Delivery) String() string            { return proto1.CompactTextString(m) }
+func (*Delivery) ProtoMessage()               {}
+func (*Delivery) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type DeliverBatch struct {
+	Msgs []*Delivery `protobuf:"bytes,1,rep,name=msgs" json:"msgs,omitempty"`
+}
+
+func (m *DeliverBatch) Reset()                    { *m = DeliverBatch{} }
+func (m *DeliverBatch) String() string            { return proto1.CompactTextString(m) }
+func (*DeliverBatch) ProtoMessage()               {}
+func (*DeliverBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *DeliverBatch) GetMsgs() []*Delivery {
+	if m != nil {
+		return m.Msgs
+	}
+	return nil
+}
+
+type DeliverAck struct {
+	Mid  uint64 `protobuf:"varint,1,opt,name=mid" json:"mid,omitempty"`
+	Code int32  `protobuf:"varint,2,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *DeliverAck) Reset()                    { *m = DeliverAck{} }
+func (m *DeliverAck) String() string            { return proto1.CompactTextString(m) }
+func (*DeliverAck) ProtoMessage()               {}
+func (*DeliverAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
 type Reply struct {
 	Msg string `protobuf:"bytes,1,opt,name=msg" json:"msg,omitempty"`
 }
@@ -106,7 +177,30 @@ type Reply struct {
 func (m *Reply) Reset()                    { *m = Reply{} }
 func (m *Reply) String() string            { return proto1.CompactTextString(m) }
 func (*Reply) ProtoMessage()               {}
-func (*Reply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+func (*Reply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+// 连接鉴权
+type AuthMsg struct {
+	Cid string `protobuf:"bytes,1,opt,name=cid" json:"cid,omitempty"`
+	Un  string `protobuf:"bytes,2,opt,name=un" json:"un,omitempty"`
+	Pw  []byte `protobuf:"bytes,3,opt,name=pw,proto3" json:"pw,omitempty"`
+	Ip  string `protobuf:"bytes,4,opt,name=ip" json:"ip,omitempty"`
+}
+
+func (m *AuthMsg) Reset()                    { *m = AuthMsg{} }
+func (m *AuthMsg) String() string            { return proto1.CompactTextString(m) }
+func (*AuthMsg) ProtoMessage()               {}
+func (*AuthMsg) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+type AuthReply struct {
+	Code   int32 `protobuf:"varint,1,opt,name=code" json:"code,omitempty"`
+	Ignore bool  `protobuf:"varint,2,opt,name=ignore" json:"ignore,omitempty"`
+}
+
+func (m *AuthReply) Reset()                    { *m = AuthReply{} }
+func (m *AuthReply) String() string            { return proto1.CompactTextString(m) }
+func (*AuthReply) ProtoMessage()               {}
+func (*AuthReply) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
 
 func init() {
 	proto1.RegisterType((*BPushMsg)(nil), "proto.BPushMsg")
@@ -115,7 +209,13 @@ func init() {
 	proto1.RegisterType((*GChatMsg)(nil), "proto.GChatMsg")
 	proto1.RegisterType((*AccMsg)(nil), "proto.AccMsg")
 	proto1.RegisterType((*TcMsg)(nil), "proto.TcMsg")
+	proto1.RegisterType((*PubMsg)(nil), "proto.PubMsg")
+	proto1.RegisterType((*Delivery)(nil), "proto.Delivery")
+	proto1.RegisterType((*DeliverBatch)(nil), "proto.DeliverBatch")
+	proto1.RegisterType((*DeliverAck)(nil), "proto.DeliverAck")
 	proto1.RegisterType((*Reply)(nil), "proto.Reply")
+	proto1.RegisterType((*AuthMsg)(nil), "proto.AuthMsg")
+	proto1.RegisterType((*AuthReply)(nil), "proto.AuthReply")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -140,6 +240,8 @@ type RpcClient interface {
 	// 用户订阅相关
 	Subscribe(ctx context.Context, in *TcMsg, opts ...grpc.CallOption) (*Reply, error)
 	UnSubscribe(ctx context.Context, in *TcMsg, opts ...grpc.CallOption) (*Reply, error)
+	// 客户端发布的消息
+	Publish(ctx context.Context, in *PubMsg, opts ...grpc.CallOption) (*Reply, error)
 }
 
 type rpcClient struct {
@@ -222,6 +324,15 @@ func (c *rpcClient) UnSubscribe(ctx context.Context, in *TcMsg, opts ...grpc.Cal
 	return out, nil
 }
 
+func (c *rpcClient) Publish(ctx context.Context, in *PubMsg, opts ...grpc.CallOption) (*Reply, error) {
+	out := new(Reply)
+	err := grpc.Invoke(ctx, "/proto.Rpc/Publish", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Rpc service
 
 type RpcServer interface {
@@ -236,6 +347,8 @@ type RpcServer interface {
 	// 用户订阅相关
 	Subscribe(context.Context, *TcMsg) (*Reply, error)
 	UnSubscribe(context.Context, *TcMsg) (*Reply, error)
+	// 客户端发布的消息
+	Publish(context.Context, *PubMsg) (*Reply, error)
 }
 
 func RegisterRpcServer(s *grpc.Server, srv RpcServer) {
@@ -386,6 +499,24 @@ func _Rpc_UnSubscribe_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Rpc_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PubMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RpcServer).Publish(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Rpc/Publish",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RpcServer).Publish(ctx, req.(*PubMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Rpc_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "proto.Rpc",
 	HandlerType: (*RpcServer)(nil),
@@ -422,29 +553,214 @@ var _Rpc_serviceDesc = grpc.ServiceDesc{
 			MethodName: "UnSubscribe",
 			Handler:    _Rpc_UnSubscribe_Handler,
 		},
+		{
+			MethodName: "Publish",
+			Handler:    _Rpc_Publish_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{},
+}
+
+// Client API for Gateway service
+
+type GatewayClient interface {
+	// 每个stream节点一条双向流，stream发送投递批次，gateway逐条回复ack
+	Deliver(ctx context.Context, opts ...grpc.CallOption) (Gateway_DeliverClient, error)
+}
+
+type gatewayClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewGatewayClient(cc *grpc.ClientConn) GatewayClient {
+	return &gatewayClient{cc}
+}
+
+func (c *gatewayClient) Deliver(ctx context.Context, opts ...grpc.CallOption) (Gateway_DeliverClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Gateway_serviceDesc.Streams[0], c.cc, "/proto.Gateway/Deliver", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gatewayDeliverClient{stream}
+	return x, nil
+}
+
+type Gateway_DeliverClient interface {
+	Send(*DeliverBatch) error
+	Recv() (*DeliverAck, error)
+	grpc.ClientStream
+}
+
+type gatewayDeliverClient struct {
+	grpc.ClientStream
+}
+
+func (x *gatewayDeliverClient) Send(m *DeliverBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *gatewayDeliverClient) Recv() (*DeliverAck, error) {
+	m := new(DeliverAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for Gateway service
+
+type GatewayServer interface {
+	// 每个stream节点一条双向流，stream发送投递批次，gateway逐条回复ack
+	Deliver(Gateway_DeliverServer) error
+}
+
+func RegisterGatewayServer(s *grpc.Server, srv GatewayServer) {
+	s.RegisterService(&_Gateway_serviceDesc, srv)
+}
+
+func _Gateway_Deliver_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(GatewayServer).Deliver(&gatewayDeliverServer{stream})
+}
+
+type Gateway_DeliverServer interface {
+	Send(*DeliverAck) error
+	Recv() (*DeliverBatch, error)
+	grpc.ServerStream
+}
+
+type gatewayDeliverServer struct {
+	grpc.ServerStream
+}
+
+func (x *gatewayDeliverServer) Send(m *DeliverAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *gatewayDeliverServer) Recv() (*DeliverBatch, error) {
+	m := new(DeliverBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _Gateway_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Gateway",
+	HandlerType: (*GatewayServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Deliver",
+			Handler:       _Gateway_Deliver_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+// Client API for Center service
+
+type CenterClient interface {
+	// 连接鉴权
+	Auth(ctx context.Context, in *AuthMsg, opts ...grpc.CallOption) (*AuthReply, error)
+}
+
+type centerClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCenterClient(cc *grpc.ClientConn) CenterClient {
+	return &centerClient{cc}
+}
+
+func (c *centerClient) Auth(ctx context.Context, in *AuthMsg, opts ...grpc.CallOption) (*AuthReply, error) {
+	out := new(AuthReply)
+	err := grpc.Invoke(ctx, "/proto.Center/Auth", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Center service
+
+type CenterServer interface {
+	// 连接鉴权
+	Auth(context.Context, *AuthMsg) (*AuthReply, error)
+}
+
+func RegisterCenterServer(s *grpc.Server, srv CenterServer) {
+	s.RegisterService(&_Center_serviceDesc, srv)
+}
+
+func _Center_Auth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthMsg)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CenterServer).Auth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Center/Auth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CenterServer).Auth(ctx, req.(*AuthMsg))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Center_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Center",
+	HandlerType: (*CenterServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Auth",
+			Handler:    _Center_Auth_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
 
 var fileDescriptor0 = []byte{
-	// 291 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x8c, 0x90, 0xbd, 0x4e, 0xc3, 0x30,
-	0x14, 0x85, 0x69, 0x83, 0xd3, 0xe6, 0x52, 0x7e, 0xe4, 0x01, 0x05, 0x26, 0x94, 0x01, 0x82, 0x90,
-	0x3a, 0xc0, 0xca, 0x02, 0x0c, 0x11, 0x12, 0xa8, 0x95, 0x13, 0xd8, 0x1b, 0x13, 0xa5, 0x91, 0xc0,
-	0xb1, 0xe2, 0x78, 0xe0, 0x45, 0x79, 0x1e, 0x7c, 0x5d, 0x1b, 0xa9, 0xa2, 0xfc, 0x4c, 0x39, 0xc7,
-	0xf7, 0xd3, 0x27, 0x9d, 0x40, 0xd4, 0x49, 0x3e, 0x95, 0x5d, 0xdb, 0xb7, 0x94, 0xd8, 0x4f, 0x02,
-	0x30, 0xbe, 0x9d, 0x6b, 0xb5, 0x7c, 0x54, 0x75, 0x72, 0x0d, 0xe3, 0xdc, 0x65, 0x1a, 0xc3, 0xa8,
-	0x2f, 0x5a, 0xd9, 0x70, 0x15, 0x0f, 0x4e, 0x82, 0x74, 0xc2, 0x7c, 0xa5, 0x87, 0x10, 0xf6, 0x33,
-	0xd9, 0xbc, 0xa8, 0x78, 0x68, 0x0f, 0xae, 0xa1, 0x69, 0x7e, 0xb7, 0x5c, 0xf4, 0x68, 0x32, 0x39,
-	0xf3, 0x99, 0x41, 0x78, 0xc3, 0x39, 0x3a, 0xf7, 0x60, 0xb8, 0x10, 0x46, 0x37, 0x48, 0x23, 0x66,
-	0x12, 0x76, 0x2d, 0x8c, 0xc5, 0x76, 0x2d, 0xd0, 0xcc, 0x5b, 0xf1, 0x5c, 0x75, 0x71, 0x60, 0xde,
-	0x08, 0x73, 0x8d, 0x1e, 0x40, 0x50, 0x37, 0x32, 0xde, 0xb6, 0x20, 0xc6, 0x64, 0x04, 0xa4, 0x40,
-	0x65, 0x72, 0x04, 0x84, 0x55, 0xf2, 0xf5, 0x1d, 0x99, 0x37, 0x55, 0x3b, 0x39, 0xc6, 0xcb, 0x8f,
-	0x21, 0x04, 0x4c, 0x72, 0x9a, 0x02, 0xb1, 0x0b, 0xe9, 0xfe, 0x6a, 0xf9, 0xd4, 0xef, 0x3d, 0x9e,
-	0xb8, 0x07, 0x6b, 0x48, 0xb6, 0x90, 0xcc, 0xd7, 0xc8, 0xfc, 0x17, 0xd2, 0x6e, 0xfd, 0x22, 0xfd,
-	0xf2, 0x4d, 0x64, 0xb6, 0x46, 0x66, 0x3f, 0x91, 0xa7, 0x40, 0x1e, 0xda, 0xfa, 0x5e, 0xd0, 0x5d,
-	0x77, 0x58, 0xfd, 0xb5, 0x6f, 0xdc, 0x19, 0x84, 0x86, 0x9b, 0xe9, 0xfe, 0x2f, 0xf0, 0x1c, 0xa2,
-	0x5c, 0x97, 0x8a, 0x77, 0x4d, 0x59, 0x51, 0x7f, 0x2c, 0x36, 0xa2, 0x17, 0xb0, 0xf3, 0x24, 0xfe,
-	0x09, 0x97, 0xa1, 0xad, 0x57, 0x9f, 0x01, 0x00, 0x00, 0xff, 0xff, 0x9d, 0xb3, 0xf3, 0xba, 0x4d,
-	0x02, 0x00, 0x00,
+	// 601 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x9c, 0x53, 0xcf, 0x6f, 0xd3, 0x4c,
+	0x14, 0xec, 0xda, 0x5e, 0xdb, 0x79, 0xcd, 0xd7, 0xaf, 0x2c, 0xa8, 0x32, 0x3d, 0x45, 0x46, 0x02,
+	0x03, 0x52, 0x85, 0x52, 0xa4, 0x5e, 0xb8, 0x24, 0x45, 0x8a, 0x2a, 0x8a, 0x1a, 0x6d, 0x02, 0x77,
+	0x67, 0x63, 0x39, 0x56, 0x53, 0x7b, 0xb1, 0xd7, 0x44, 0xbd, 0x71, 0x42, 0xe2, 0xc2, 0xdf, 0x8c,
+	0x76, 0xbd, 0x9b, 0xc4, 0x21, 0xfc, 0x10, 0x27, 0xbf, 0xd9, 0x1d, 0x8d, 0x67, 0xec, 0x79, 0xd0,
+	0x29, 0x39, 0x3b, 0xe3, 0x65, 0x21, 0x0a, 0x82, 0xd5, 0x23, 0x04, 0xf0, 0x87, 0xe3, 0xba, 0x5a,
+	0xbc, 0xaf, 0xd2, 0xf0, 0x0d, 0xf8, 0x13, 0x3d, 0x93, 0x00, 0x3c, 0x31, 0x2d, 0x78, 0xc6, 0xaa,
+	0x00, 0xf5, 0xec, 0xa8, 0x4b, 0x0d, 0x24, 0x27, 0xe0, 0x8a, 0x1b, 0x9e, 0xcd, 0xab, 0xc0, 0x52,
+	0x17, 0x1a, 0x49, 0xa5, 0xf1, 0xe5, 0x22, 0x16, 0x52, 0x09, 0xc0, 0x1f, 0x99, 0x99, 0x82, 0x3b,
+	0x60, 0x4c, 0x6a, 0x1e, 0x81, 0x15, 0xe7, 0x01, 0xea, 0xa1, 0xa8, 0x43, 0xad, 0x38, 0x97, 0xb8,
+	0xce, 0x03, 0xab, 0xc1, 0x75, 0x2e, 0x95, 0x59, 0x91, 0x7f, 0x4c, 0xca, 0xc0, 0xee, 0xa1, 0x08,
+	0x53, 0x8d, 0xc8, 0x31, 0xd8, 0x69, 0xc6, 0x03, 0x47, 0x11, 0xe5, 0x18, 0x7e, 0x43, 0x80, 0xa7,
+	0x7b, 0x35, 0x8f, 0xc1, 0x66, 0xd9, 0x5c, 0x8b, 0xca, 0x51, 0x32, 0x04, 0x57, 0x8a, 0x5d, 0x6a,
+	0x09, 0x2e, 0x19, 0x9f, 0x8a, 0x4a, 0xa9, 0x61, 0x2a, 0x47, 0xa3, 0x8f, 0xd7, 0xfa, 0xe4, 0x11,
+	0x60, 0x56, 0xe4, 0x57, 0xf3, 0xc0, 0xed, 0xa1, 0xc8, 0xa6, 0x0d, 0x90, 0xa7, 0x69, 0x59, 0xd4,
+	0x3c, 0xf0, 0x14, 0xb3, 0x01, 0xe1, 0x57, 0x04, 0xee, 0xb8, 0x9e, 0xfd, 0x9b, 0x99, 0x23, 0xb0,
+	0xf8, 0x52, 0x79, 0xe9, 0x52, 0x8b, 0x2f, 0x8d, 0x39, 0xbc, 0x31, 0x77, 0x02, 0x6e, 0x99, 0x88,
+	0x38, 0xcb, 0x95, 0x17, 0x9f, 0x6a, 0x64, 0x4c, 0x7b, 0x9b, 0x8f, 0xf2, 0x1d, 0x81, 0xff, 0x36,
+	0x59, 0x66, 0x9f, 0x93, 0xf2, 0x5e, 0x5e, 0xdf, 0x65, 0x73, 0xe5, 0xc5, 0xa1, 0x72, 0xdc, 0x64,
+	0xb2, 0xb6, 0x33, 0x69, 0x8b, 0xf6, 0xae, 0x45, 0x67, 0xc7, 0x22, 0xde, 0xb5, 0xe8, 0xee, 0xb3,
+	0xe8, 0x6d, 0x5b, 0x0c, 0xcf, 0xa1, 0xab, 0xfd, 0x0c, 0x63, 0xc1, 0x16, 0xe4, 0x09, 0x38, 0x77,
+	0x55, 0xda, 0x14, 0xea, 0xb0, 0xff, 0x7f, 0x53, 0xc4, 0x33, 0x63, 0x99, 0xaa, 0xcb, 0xb0, 0x0f,
+	0xa0, 0x4f, 0x06, 0xec, 0x76, 0x4f, 0x0c, 0x02, 0x0e, 0x2b, 0xe6, 0x89, 0x4a, 0x81, 0xa9, 0x9a,
+	0xc3, 0xc7, 0x80, 0x69, 0xc2, 0x97, 0x4d, 0xea, 0x2a, 0xd5, 0x7f, 0x40, 0x8e, 0xe1, 0x3b, 0xf0,
+	0x06, 0xb5, 0x50, 0x95, 0xd6, 0x51, 0x51, 0x2b, 0x6a, 0xab, 0x80, 0x32, 0xea, 0xca, 0xfc, 0x1d,
+	0xbe, 0x92, 0x78, 0xdd, 0x3b, 0x2b, 0xe3, 0xe1, 0x05, 0x74, 0xa4, 0x58, 0xf3, 0x2e, 0x63, 0x04,
+	0x6d, 0x8c, 0xc8, 0x2f, 0x91, 0xa5, 0x79, 0x51, 0x36, 0xf6, 0x7c, 0xaa, 0x51, 0xff, 0x8b, 0x0d,
+	0x36, 0xe5, 0x8c, 0x44, 0x80, 0xd5, 0xb6, 0x11, 0x13, 0xde, 0xec, 0xde, 0x69, 0x57, 0x1f, 0x28,
+	0xed, 0xf0, 0x40, 0x32, 0x27, 0x2d, 0xe6, 0xe4, 0x37, 0x4c, 0xb5, 0x77, 0x6b, 0xa6, 0xd9, 0xc2,
+	0x7d, 0xcc, 0x51, 0x8b, 0x39, 0xfa, 0x15, 0xf3, 0x29, 0xe0, 0xeb, 0x22, 0xbd, 0xca, 0xc9, 0x7f,
+	0xfa, 0xa2, 0xd9, 0xe0, 0x9f, 0x78, 0xcf, 0xc0, 0xbd, 0x2e, 0xd2, 0x9b, 0x5a, 0xfc, 0x89, 0xf8,
+	0x1c, 0x3a, 0x93, 0x7a, 0x56, 0xb1, 0x32, 0x9b, 0x25, 0xc4, 0x5c, 0x4e, 0xf7, 0x52, 0x5f, 0xc2,
+	0xe1, 0x87, 0xfc, 0x6f, 0xc9, 0x11, 0x78, 0xe3, 0x7a, 0xb6, 0xcc, 0xaa, 0xc5, 0xda, 0x41, 0xb3,
+	0x8b, 0xbb, 0xcc, 0xfe, 0x10, 0xbc, 0x51, 0x2c, 0x92, 0x55, 0x7c, 0x4f, 0x2e, 0xc0, 0xd3, 0x15,
+	0x23, 0x0f, 0xdb, 0x25, 0x54, 0x3d, 0x3d, 0x7d, 0xd0, 0x3e, 0x1c, 0xb0, 0xdb, 0xf0, 0x20, 0x42,
+	0xaf, 0x50, 0xff, 0x35, 0xb8, 0x97, 0x49, 0x2e, 0x92, 0x92, 0xbc, 0x00, 0x47, 0x36, 0x81, 0x1c,
+	0x99, 0xd8, 0x4d, 0xc7, 0x4e, 0x8f, 0xb7, 0xb0, 0x7e, 0xf3, 0xcc, 0x55, 0x47, 0xe7, 0x3f, 0x06,
+	0x00, 0xaf, 0xd1, 0x58, 0x24, 0x7d, 0x05, 0x00, 0x00,
 }
@@ -0,0 +1,242 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/uber-go/zap"
+)
+
+var errRegistered = errors.New("registry: the instance is already registered")
+
+// the wait before registering or watching again after etcd fails
+const etcdRetry = time.Second
+
+// Etcd keeps each instance as a key with a lease, the lease is kept alive until Deregister or Close
+type Etcd struct {
+	cli    *clientv3.Client
+	ttl    int64
+	logger zap.Logger
+
+	sync.Mutex
+	regs map[string]*etcdReg
+}
+
+// etcdReg is a registration renewed in the background
+type etcdReg struct {
+	cancel context.CancelFunc
+	// closed when the renewal ends, lease can be read after it
+	done  chan struct{}
+	lease clientv3.LeaseID
+}
+
+// NewEtcd connects to etcd, ttl is DefaultTTL if 0
+func NewEtcd(addrs []string, dialTimeout, ttl time.Duration, logger zap.Logger) (*Etcd, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   addrs,
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Etcd{
+		cli:    cli,
+		ttl:    int64(ttl / time.Second),
+		logger: logger,
+		regs:   make(map[string]*etcdReg),
+	}, nil
+}
+
+func (e *Etcd) Register(ctx context.Context, s *Service) error {
+	key := s.path()
+
+	e.Lock()
+	defer e.Unlock()
+	if _, ok := e.regs[key]; ok {
+		return errRegistered
+	}
+
+	// the keepalive outlives ctx
+	kctx, cancel := context.WithCancel(context.Background())
+	lease, ch, err := e.grant(ctx, kctx, s)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	r := &etcdReg{cancel: cancel, done: make(chan struct{}), lease: lease}
+	e.regs[key] = r
+	go e.keepAlive(kctx, s, r, ch)
+
+	return nil
+}
+
+// grant puts the instance with a new lease and keeps the lease alive until kctx is done
+func (e *Etcd) grant(ctx, kctx context.Context, s *Service) (clientv3.LeaseID, <-chan *clientv3.LeaseKeepAliveResponse, error) {
+	lease, err := e.cli.Grant(ctx, e.ttl)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := e.cli.Put(ctx, s.path(), s.Addr, clientv3.WithLease(lease.ID)); err != nil {
+		return 0, nil, err
+	}
+
+	ch, err := e.cli.KeepAlive(kctx, lease.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return lease.ID, ch, nil
+}
+
+// keepAlive registers the instance again when the lease is lost, e.g. etcd was unreachable longer than the ttl
+func (e *Etcd) keepAlive(ctx context.Context, s *Service, r *etcdReg, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	defer close(r.done)
+
+	for {
+		// the responses must be read, the channel is closed when the lease is lost or ctx is done
+		for range ch {
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		e.logger.Warn("registry lease lost, register again", zap.String("key", s.path()))
+
+		for {
+			select {
+			case <-time.After(etcdRetry):
+			case <-ctx.Done():
+				return
+			}
+
+			lease, kch, err := e.grant(ctx, ctx, s)
+			if err == nil {
+				r.lease, ch = lease, kch
+				break
+			}
+			e.logger.Warn("registry register error", zap.Error(err), zap.String("key", s.path()))
+		}
+	}
+}
+
+func (e *Etcd) Deregister(ctx context.Context, s *Service) error {
+	key := s.path()
+
+	e.Lock()
+	r := e.regs[key]
+	delete(e.regs, key)
+	e.Unlock()
+
+	// not registered by this process
+	if r == nil {
+		_, err := e.cli.Delete(ctx, key)
+		return err
+	}
+
+	r.cancel()
+	<-r.done
+
+	// the key is deleted with its lease
+	_, err := e.cli.Revoke(ctx, r.lease)
+	return err
+}
+
+func (e *Etcd) Watch(ctx context.Context, name string) (<-chan Event, error) {
+	resp, err := e.cli.Get(ctx, dir(name), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event)
+	go e.watch(ctx, name, resp, ch)
+	return ch, nil
+}
+
+// watch follows the changes after the listed revision, the instances are listed again if the watch breaks
+func (e *Etcd) watch(ctx context.Context, name string, resp *clientv3.GetResponse, ch chan Event) {
+	defer close(ch)
+
+	prefix := dir(name)
+	known := make(map[string]string)
+	for {
+		cur := make(map[string]string)
+		for _, kv := range resp.Kvs {
+			cur[strings.TrimPrefix(string(kv.Key), prefix)] = string(kv.Value)
+		}
+		if !diff(ctx, ch, name, known, cur) {
+			return
+		}
+
+		wch := e.cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+		for wresp := range wch {
+			if err := wresp.Err(); err != nil {
+				e.logger.Warn("registry watch error", zap.Error(err), zap.String("name", name))
+				break
+			}
+
+			for _, ev := range wresp.Events {
+				k := strings.TrimPrefix(string(ev.Kv.Key), prefix)
+				addr, ok := known[k]
+
+				var event Event
+				if ev.Type == mvccpb.PUT {
+					if ok && addr == string(ev.Kv.Value) {
+						continue
+					}
+					known[k] = string(ev.Kv.Value)
+					event = Event{EventAdd, Service{Name: name, Key: k, Addr: string(ev.Kv.Value)}}
+				} else {
+					if !ok {
+						continue
+					}
+					delete(known, k)
+					event = Event{EventRemove, Service{Name: name, Key: k, Addr: addr}}
+				}
+
+				if !send(ctx, ch, event) {
+					return
+				}
+			}
+		}
+
+		// the watch is canceled, e.g. the revision is compacted
+		for {
+			select {
+			case <-time.After(etcdRetry):
+			case <-ctx.Done():
+				return
+			}
+
+			var err error
+			if resp, err = e.cli.Get(ctx, prefix, clientv3.WithPrefix()); err == nil {
+				break
+			}
+			e.logger.Warn("registry list error", zap.Error(err), zap.String("name", name))
+		}
+	}
+}
+
+func (e *Etcd) Close() error {
+	e.Lock()
+	regs := e.regs
+	e.regs = make(map[string]*etcdReg)
+	e.Unlock()
+
+	for _, r := range regs {
+		r.cancel()
+		<-r.done
+	}
+
+	return e.cli.Close()
+}
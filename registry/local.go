@@ -0,0 +1,282 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the longest wait of the file watchers
+const maxLocalPoll = time.Second
+
+// Local keeps the instances in the process, or in a json file shared by the processes on one node.
+// The file is polled by the watchers, the instances of this process are renewed every ttl/3
+// and the ones of a dead process expire after the ttl. Two processes writing at once may lose
+// an update, it's repaired by the next renewal.
+type Local struct {
+	// empty keeps the instances in memory
+	path string
+	ttl  time.Duration
+	poll time.Duration
+
+	sync.Mutex
+	// the instances of this process
+	own map[string]string
+	// closed and replaced when the instances of this process change
+	changed chan struct{}
+	stop    chan struct{}
+	closed  bool
+}
+
+// localEntry is an instance in the file
+type localEntry struct {
+	Addr string `json:"addr"`
+	// unix milliseconds
+	Expires int64 `json:"expires"`
+}
+
+// NewMemory keeps the instances in the process, e.g. for the tests
+func NewMemory() *Local {
+	return &Local{
+		own:     make(map[string]string),
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+// NewFile shares the instances through the file, ttl is DefaultTTL if 0
+func NewFile(path string, ttl time.Duration) *Local {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	r := NewMemory()
+	r.path = path
+	r.ttl = ttl
+	r.poll = ttl / 3
+	if r.poll > maxLocalPoll {
+		r.poll = maxLocalPoll
+	}
+
+	go r.renew()
+	return r
+}
+
+func (r *Local) Register(ctx context.Context, s *Service) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.own[s.path()]; ok {
+		return errRegistered
+	}
+
+	r.own[s.path()] = s.Addr
+	if err := r.writeLocked(""); err != nil {
+		delete(r.own, s.path())
+		return err
+	}
+
+	r.notifyLocked()
+	return nil
+}
+
+func (r *Local) Deregister(ctx context.Context, s *Service) error {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.own, s.path())
+	if err := r.writeLocked(s.path()); err != nil {
+		return err
+	}
+
+	r.notifyLocked()
+	return nil
+}
+
+func (r *Local) Watch(ctx context.Context, name string) (<-chan Event, error) {
+	// the file is checked before watching
+	if _, err := r.list(); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Event)
+	go r.watch(ctx, name, ch)
+	return ch, nil
+}
+
+func (r *Local) watch(ctx context.Context, name string, ch chan Event) {
+	defer close(ch)
+
+	var tick <-chan time.Time
+	if r.path != "" {
+		t := time.NewTicker(r.poll)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	prefix := dir(name)
+	known := make(map[string]string)
+	for {
+		r.Lock()
+		changed := r.changed
+		r.Unlock()
+
+		// a broken file is read again on the next tick
+		if all, err := r.list(); err == nil {
+			cur := make(map[string]string)
+			for k, addr := range all {
+				if strings.HasPrefix(k, prefix) {
+					cur[strings.TrimPrefix(k, prefix)] = addr
+				}
+			}
+			if !diff(ctx, ch, name, known, cur) {
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-tick:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// list returns the instances not expired
+func (r *Local) list() (map[string]string, error) {
+	if r.path == "" {
+		r.Lock()
+		defer r.Unlock()
+
+		all := make(map[string]string, len(r.own))
+		for k, addr := range r.own {
+			all[k] = addr
+		}
+		return all, nil
+	}
+
+	entries, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make(map[string]string, len(entries))
+	now := unixMilli(time.Now())
+	for k, e := range entries {
+		if e.Expires > now {
+			all[k] = e.Addr
+		}
+	}
+	return all, nil
+}
+
+func (r *Local) read() (map[string]localEntry, error) {
+	entries := make(map[string]localEntry)
+
+	b, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// writeLocked renews the instances of this process in the file and removes the expired ones and del,
+// the file is replaced by renaming, so the readers never see it half written
+func (r *Local) writeLocked(del string) error {
+	if r.path == "" {
+		return nil
+	}
+
+	entries, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for k, e := range entries {
+		if e.Expires <= unixMilli(now) {
+			delete(entries, k)
+		}
+	}
+	delete(entries, del)
+
+	expires := unixMilli(now.Add(r.ttl))
+	for k, addr := range r.own {
+		entries[k] = localEntry{Addr: addr, Expires: expires}
+	}
+
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// notifyLocked wakes up the watchers of this process
+func (r *Local) notifyLocked() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// renew keeps the instances of this process in the file until Close
+func (r *Local) renew() {
+	for {
+		select {
+		case <-time.After(r.ttl / 3):
+		case <-r.stop:
+			return
+		}
+
+		r.Lock()
+		if len(r.own) > 0 {
+			// the instances expire if it keeps failing
+			r.writeLocked("")
+		}
+		r.Unlock()
+	}
+}
+
+func (r *Local) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		close(r.stop)
+	}
+	return nil
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
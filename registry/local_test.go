@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("the watch channel is closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+func TestLocal_memory(t *testing.T) {
+	r := NewMemory()
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s1 := &Service{Name: "/gomqtt/stream", Key: "s1", Addr: "127.0.0.1:8991"}
+	if err := r.Register(ctx, s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(ctx, s1); err != errRegistered {
+		t.Errorf("Register() again error = %v, want %v", err, errRegistered)
+	}
+	// another service isn't watched
+	r.Register(ctx, &Service{Name: "/gomqtt/rooms", Key: "r1", Addr: "127.0.0.1"})
+
+	ch, err := r.Watch(ctx, "/gomqtt/stream/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		do   func()
+		want Event
+	}{
+		{"registered before watching", func() {}, Event{EventAdd, *s1}},
+		{"registered", func() {
+			r.Register(ctx, &Service{Name: "/gomqtt/stream", Key: "s2", Addr: "127.0.0.1:8992"})
+		}, Event{EventAdd, Service{Name: "/gomqtt/stream/", Key: "s2", Addr: "127.0.0.1:8992"}}},
+		{"deregistered", func() {
+			r.Deregister(ctx, s1)
+		}, Event{EventRemove, Service{Name: "/gomqtt/stream/", Key: "s1", Addr: "127.0.0.1:8991"}}},
+	}
+	for _, tt := range tests {
+		tt.do()
+		ev := nextEvent(t, ch)
+		// the name is the watched one
+		tt.want.Name = "/gomqtt/stream/"
+		if ev != tt.want {
+			t.Errorf("%s: event = %+v, want %+v", tt.name, ev, tt.want)
+		}
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestLocal_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	ttl := 300 * time.Millisecond
+
+	// two processes sharing the file
+	r1 := NewFile(path, ttl)
+	r2 := NewFile(path, ttl)
+	defer r2.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := r2.Watch(ctx, "/gomqtt/rooms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Service{Name: "/gomqtt/rooms", Key: "room1", Addr: "10.0.0.1"}
+	if err := r1.Register(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, ch); ev.Type != EventAdd || ev.Key != "room1" || ev.Addr != "10.0.0.1" {
+		t.Fatalf("event = %+v, want the add of room1", ev)
+	}
+
+	// the instance is renewed while the process runs
+	time.Sleep(2 * ttl)
+	if all, _ := r2.list(); all["/gomqtt/rooms/room1"] != "10.0.0.1" {
+		t.Fatalf("instances = %v, want room1 renewed", all)
+	}
+
+	// and expires when it dies without deregistering
+	r1.Close()
+	if ev := nextEvent(t, ch); ev.Type != EventRemove || ev.Key != "room1" {
+		t.Fatalf("event = %+v, want the remove of room1", ev)
+	}
+}
@@ -0,0 +1,106 @@
+// Package registry registers the instances of the services, e.g. the streams and the gateway rooms,
+// and watches the instances of the others. Etcd is used in the clusters, Local in the tests and on a single node.
+package registry
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// the lease of the registrations, an instance is removed this long after its process dies
+const DefaultTTL = 30 * time.Second
+
+// Service is an instance of a service
+type Service struct {
+	// the dir of the service, e.g. /gomqtt/stream
+	Name string
+	// unique in the dir, e.g. the host name
+	Key string
+	// the address the instance is reached at
+	Addr string
+}
+
+// path is the key of the instance in the registry
+func (s *Service) path() string {
+	return dir(s.Name) + s.Key
+}
+
+// dir is the prefix of the instances of the service
+func dir(name string) string {
+	if strings.HasSuffix(name, "/") {
+		return name
+	}
+	return name + "/"
+}
+
+type EventType int
+
+const (
+	// the instance is registered, or its address is changed
+	EventAdd EventType = iota
+	// the instance is deregistered, or its lease expired
+	EventRemove
+)
+
+func (t EventType) String() string {
+	if t == EventAdd {
+		return "add"
+	}
+	return "remove"
+}
+
+// Event is a change of the instances of a watched service
+type Event struct {
+	Type EventType
+	Service
+}
+
+// Registry keeps the instances of the services
+type Registry interface {
+	// Register adds the instance and keeps it until Deregister, it's removed when the lease expires
+	// if the process dies. ctx only bounds the first registration, it's renewed in the background.
+	Register(ctx context.Context, s *Service) error
+	// Deregister removes the instance at once, so the others stop using it
+	Deregister(ctx context.Context, s *Service) error
+	// Watch sends the instances of the service as add events, then their changes,
+	// the channel is closed when ctx is done
+	Watch(ctx context.Context, name string) (<-chan Event, error)
+	// Close stops the renewals, the instances not deregistered expire
+	Close() error
+}
+
+// send returns false if ctx is done before the event is received
+func send(ctx context.Context, ch chan<- Event, ev Event) bool {
+	select {
+	case ch <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// diff sends the events changing old to cur, old becomes cur, false is returned if ctx is done
+func diff(ctx context.Context, ch chan<- Event, name string, old, cur map[string]string) bool {
+	for k, addr := range cur {
+		if o, ok := old[k]; ok && o == addr {
+			continue
+		}
+		old[k] = addr
+		if !send(ctx, ch, Event{EventAdd, Service{Name: name, Key: k, Addr: addr}}) {
+			return false
+		}
+	}
+
+	for k, addr := range old {
+		if _, ok := cur[k]; ok {
+			continue
+		}
+		delete(old, k)
+		if !send(ctx, ch, Event{EventRemove, Service{Name: name, Key: k, Addr: addr}}) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -71,7 +71,10 @@ func initConfig() {
 func start(cmd *cobra.Command, args []string) {
 	isStatic, _ := cmd.Flags().GetBool("static_config")
 	stream := service.New()
-	stream.Start(isStatic)
+	if err := stream.Start(isStatic); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
 
 	// 等待服务器停止信号
 	chSig := make(chan os.Signal)
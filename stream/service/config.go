@@ -13,6 +13,7 @@ type Config struct {
 	CommonC *CommonConfig
 	EtcdC   *EtcdConfig
 	GrpcC   *GrpcConfig
+	ShareC  *ShareConfig
 
 	StreamAddrs map[string]string
 }
@@ -29,18 +30,28 @@ type CommonConfig struct {
 }
 
 type EtcdConfig struct {
-	Addrs      []string
-	Dltimeout  int
-	Rqtimeout  int
+	Addrs     []string
+	Dltimeout int
+	Rqtimeout int
+	// 不再使用, 注册由lease的keepalive续期, 保留以兼容旧的配置
 	ReportTime int64
 	Reportdir  string
-	TTL        int64
+	// 秒, stream宕机后它的注册保留这么久
+	TTL int64
+	// 单机时多个服务共享的json文件, addrs为空时使用
+	File string
 }
 
 type GrpcConfig struct {
 	Addr string
 }
 
+// 共享订阅
+type ShareConfig struct {
+	// round_robin, random or hash(by the publisher's client id)
+	Strategy string
+}
+
 var Conf = &Config{}
 
 func initConf() {
@@ -48,6 +59,7 @@ func initConf() {
 		CommonC: &CommonConfig{},
 		EtcdC:   &EtcdConfig{},
 		GrpcC:   &GrpcConfig{},
+		ShareC:  &ShareConfig{Strategy: shareRoundRobin},
 	}
 }
 
@@ -84,6 +96,9 @@ func loadConfig(staticConf bool) {
 	// 解析grpc
 	parseGrpc(tbl)
 
+	// 解析共享订阅
+	parseShare(tbl)
+
 	Conf.Show()
 
 }
@@ -129,3 +144,21 @@ func parseGrpc(tbl *ast.Table) {
 		}
 	}
 }
+
+func parseShare(tbl *ast.Table) {
+	if val, ok := tbl.Fields["share"]; ok {
+		subTbl, ok := val.(*ast.Table)
+		if !ok {
+			log.Fatalln("[FATAL] parse share config: ", subTbl)
+		}
+
+		err := toml.UnmarshalTable(subTbl, Conf.ShareC)
+		if err != nil {
+			log.Fatalln("[FATAL] parseShare: ", err, subTbl)
+		}
+	}
+
+	if !validStrategy(Conf.ShareC.Strategy) {
+		log.Fatalln("[FATAL] parseShare: invalid strategy ", Conf.ShareC.Strategy)
+	}
+}
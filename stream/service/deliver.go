@@ -0,0 +1,148 @@
+package service
+
+import (
+	"sync"
+
+	context "golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/aiyun/gomqtt/proto"
+	"github.com/uber-go/zap"
+)
+
+// the ack codes of the gateway delivery service
+const (
+	ackDelivered   = 0
+	ackNotFound    = 1
+	ackQueueFull   = 2
+	ackInvalid     = 3
+	ackUndelivered = 4
+)
+
+// deliverTo sends the message to the gateway, done is called with the ack code.
+// It's replaced in the tests.
+var deliverTo = func(gip string, d *proto.Delivery, done func(code int32)) error {
+	return gates.deliver(gip, d, done)
+}
+
+// gateClients keeps one delivery stream to each gateway
+type gateClients struct {
+	sync.Mutex
+	clients map[string]*gateClient
+}
+
+var gates = &gateClients{clients: make(map[string]*gateClient)}
+
+func (gc *gateClients) deliver(gip string, d *proto.Delivery, done func(code int32)) error {
+	gc.Lock()
+	c, ok := gc.clients[gip]
+	if !ok {
+		c = &gateClient{addr: gip, waits: make(map[uint64]func(int32))}
+		gc.clients[gip] = c
+	}
+	gc.Unlock()
+
+	return c.send(d, done)
+}
+
+// gateClient is the delivery stream to one gateway, it's reopened on the next send after failing
+type gateClient struct {
+	// guards conn and stream, held while sending
+	sync.Mutex
+	addr   string
+	conn   *grpc.ClientConn
+	stream proto.Gateway_DeliverClient
+
+	// guards mid and waits, the acks are received without waiting for a blocked send
+	wmu   sync.Mutex
+	mid   uint64
+	waits map[uint64]func(int32)
+}
+
+func (c *gateClient) send(d *proto.Delivery, done func(code int32)) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.stream == nil {
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+
+	c.wmu.Lock()
+	c.mid++
+	d.Mid = c.mid
+	c.waits[d.Mid] = done
+	c.wmu.Unlock()
+
+	if err := c.stream.Send(&proto.DeliverBatch{Msgs: []*proto.Delivery{d}}); err != nil {
+		c.wmu.Lock()
+		delete(c.waits, d.Mid)
+		c.wmu.Unlock()
+		c.reset(c.stream)
+		return err
+	}
+
+	return nil
+}
+
+// open is called with the lock held
+func (c *gateClient) open() error {
+	if c.conn == nil {
+		conn, err := grpc.Dial(c.addr, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+
+	stream, err := proto.NewGatewayClient(c.conn).Deliver(context.Background())
+	if err != nil {
+		return err
+	}
+	c.stream = stream
+
+	go c.recv(stream)
+	return nil
+}
+
+func (c *gateClient) recv(stream proto.Gateway_DeliverClient) {
+	for {
+		ack, err := stream.Recv()
+		if err != nil {
+			Logger.Warn("gateway delivery stream closed", zap.String("addr", c.addr), zap.Error(err))
+
+			c.Lock()
+			c.reset(stream)
+			c.Unlock()
+			return
+		}
+
+		c.wmu.Lock()
+		done, ok := c.waits[ack.Mid]
+		delete(c.waits, ack.Mid)
+		c.wmu.Unlock()
+
+		// called without the lock, done may deliver again
+		if ok {
+			done(ack.Code)
+		}
+	}
+}
+
+// reset drops the broken stream, the waiting messages are undelivered. It's called with the lock held.
+func (c *gateClient) reset(stream proto.Gateway_DeliverClient) {
+	if c.stream != stream {
+		return
+	}
+	c.stream = nil
+	stream.CloseSend()
+
+	c.wmu.Lock()
+	waits := c.waits
+	c.waits = make(map[uint64]func(int32))
+	c.wmu.Unlock()
+	for _, done := range waits {
+		go done(ackUndelivered)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"path"
+	"time"
+
+	context "golang.org/x/net/context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// rpcDuration is the latency of the grpc calls by method and status code
+var rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gomqtt",
+	Subsystem: "stream",
+	Name:      "rpc_duration_seconds",
+	Help:      "Latency of the rpc calls by method and code.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"method", "code"})
+
+func init() {
+	prometheus.MustRegister(rpcDuration)
+}
+
+// rpcMetrics is the grpc interceptor observing rpcDuration
+func rpcMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	// /proto.Rpc/LogIn -> LogIn
+	rpcDuration.WithLabelValues(path.Base(info.FullMethod), grpc.Code(err).String()).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
@@ -6,110 +6,124 @@ import (
 	"os"
 	"time"
 
-	"github.com/coreos/etcd/clientv3"
+	"github.com/aiyun/gomqtt/registry"
 	"github.com/uber-go/zap"
 )
 
-// UpdateAddr struct
+// UpdateAddr 注册本台stream的grpc地址, 并关注其他stream的地址
 type UpdateAddr struct {
-	Client    *clientv3.Client
-	Grant     *clientv3.LeaseGrantResponse
-	ReportKey string
+	reg    registry.Registry
+	svc    *registry.Service
+	cancel context.CancelFunc
 }
 
 // Init init UpdateAddr
-func (upa *UpdateAddr) Init() {
+func (upa *UpdateAddr) Init() error {
 	reportKey, err := GetRegisterKey()
 	if err != nil {
-		Logger.Panic("Init", zap.Error(err))
+		return err
 	}
-	keylen := len(Conf.EtcdC.Reportdir)
-	if keylen > 0 && Conf.EtcdC.Reportdir[keylen-1] != '/' {
-		upa.ReportKey = Conf.EtcdC.Reportdir + "/" + reportKey
-	} else {
-		upa.ReportKey = Conf.EtcdC.Reportdir + reportKey
+	upa.svc = &registry.Service{
+		Name: Conf.EtcdC.Reportdir,
+		Key:  reportKey,
+		Addr: Conf.GrpcC.Addr,
 	}
 
-	Logger.Info("Init", zap.String("@Key", upa.ReportKey), zap.String("addr", Conf.GrpcC.Addr))
+	Logger.Info("Init", zap.String("@Key", reportKey), zap.String("addr", Conf.GrpcC.Addr))
 
-	cfg := clientv3.Config{
-		Endpoints:   Conf.EtcdC.Addrs,
-		DialTimeout: time.Duration(Conf.EtcdC.Dltimeout) * time.Second,
+	ttl := time.Duration(Conf.EtcdC.TTL) * time.Second
+	// 单机时不用etcd
+	if len(Conf.EtcdC.Addrs) == 0 && Conf.EtcdC.File != "" {
+		upa.reg = registry.NewFile(Conf.EtcdC.File, ttl)
+		return nil
 	}
 
-	client, err := clientv3.New(cfg)
+	reg, err := registry.NewEtcd(Conf.EtcdC.Addrs, time.Duration(Conf.EtcdC.Dltimeout)*time.Second, ttl, Logger)
 	if err != nil {
-		Logger.Panic("Etcd", zap.Error(err))
+		return fmt.Errorf("etcd: %v", err)
 	}
+	upa.reg = reg
+	return nil
+}
 
-	upa.Client = client
+// Start 注册本台stream, 注册由registry在后台续期
+func (upa *UpdateAddr) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	upa.cancel = cancel
 
-	return
-}
+	ch, err := upa.reg.Watch(ctx, Conf.EtcdC.Reportdir)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("watch streams: %v", err)
+	}
+	go upa.WatchWork(ch)
 
-func (upa *UpdateAddr) Start() {
-	go upa.WathchWork()
-	go upa.RegisterWork()
+	rctx, rcancel := context.WithTimeout(ctx, upa.timeout())
+	defer rcancel()
+	if err := upa.reg.Register(rctx, upa.svc); err != nil {
+		cancel()
+		return fmt.Errorf("register %s: %v", upa.svc.Key, err)
+	}
+	return nil
 }
 
+// Close 注销本台stream, gateway立即停止使用它, 不用等lease过期
 func (upa *UpdateAddr) Close() error {
-	return upa.Client.Close()
-}
+	ctx, cancel := context.WithTimeout(context.Background(), upa.timeout())
+	defer cancel()
+	if err := upa.reg.Deregister(ctx, upa.svc); err != nil {
+		Logger.Warn("Deregister", zap.Error(err), zap.String("key", upa.svc.Key))
+	}
 
-func (upa *UpdateAddr) WathchWork() {
-	for {
-		rch := upa.Client.Watch(context.Background(), Conf.EtcdC.Reportdir, clientv3.WithPrefix())
-		for wresp := range rch {
-			for _, ev := range wresp.Events {
-				comput := false
-				// PUT
-				if ev.Type == 0 {
-					exist := gStream.cache.Sas.Add(string(ev.Kv.Key), string(ev.Kv.Value))
-					if !exist {
-						comput = true
-						// hast add grpc addr
-						gStream.hash.Add(string(ev.Kv.Value))
-						// new stream, 重新计算客户端落在哪台一台stream
-						Logger.Info("Watch Insert", zap.String("key", string(ev.Kv.Key)), zap.String("value", string(ev.Kv.Value)))
-					}
-				} else {
-					// Delete
-					if keyip, ok := gStream.cache.Sas.Get(string(ev.Kv.Key)); ok {
-						gStream.hash.Remove(keyip)
-						gStream.cache.Sas.Del(string(ev.Kv.Key))
-						Logger.Info("Watch Delete", zap.String("key", string(ev.Kv.Key)), zap.String("value", string(ev.Kv.Value)))
-						comput = true
-					}
-				}
-				// 重新计算在线用户是否需要保存在本台stream上
-				if comput {
-					Logger.Debug("需要重新计算用户是否落在本机上")
-				}
-			}
-			Logger.Debug("get new stream addrs", zap.Object("addrs", gStream.cache.Sas))
-		}
+	if upa.cancel != nil {
+		upa.cancel()
 	}
+	return upa.reg.Close()
 }
 
-// RegisterWork register stream addr
-func (upa *UpdateAddr) RegisterWork() {
-	for {
-		time.Sleep(time.Duration(Conf.EtcdC.ReportTime) * time.Second)
-		upa.Put(upa.ReportKey, Conf.GrpcC.Addr, Conf.EtcdC.TTL)
+func (upa *UpdateAddr) timeout() time.Duration {
+	if Conf.EtcdC.Rqtimeout > 0 {
+		return time.Duration(Conf.EtcdC.Rqtimeout) * time.Second
 	}
+	return 5 * time.Second
 }
 
-func (upa *UpdateAddr) Put(key, value string, ttl int64) error {
-	Grant, err := upa.Client.Grant(context.TODO(), ttl)
-	if err != nil {
-		Logger.Panic("Etcd", zap.Error(err), zap.Int64("@ReportTime", ttl))
-	}
-	_, err = upa.Client.Put(context.TODO(), key, value, clientv3.WithLease(Grant.ID))
-	if err != nil {
-		Logger.Error("Put", zap.String("@key", key), zap.String("@value", value), zap.Error(err))
-		return err
+// WatchWork 先收到已注册的stream, 然后是它们的变化
+func (upa *UpdateAddr) WatchWork(ch <-chan registry.Event) {
+	for ev := range ch {
+		comput := false
+		if ev.Type == registry.EventAdd {
+			old, exist := gStream.cache.Sas.Get(ev.Key)
+			if !exist || old != ev.Addr {
+				comput = true
+				// 地址变化, 替换hash中的旧地址
+				if exist {
+					gStream.hash.Remove(old)
+					gStream.cache.Sas.Del(ev.Key)
+					Logger.Info("Watch Update", zap.String("key", ev.Key), zap.String("old", old), zap.String("value", ev.Addr))
+				} else {
+					// new stream, 重新计算客户端落在哪台一台stream
+					Logger.Info("Watch Insert", zap.String("key", ev.Key), zap.String("value", ev.Addr))
+				}
+				gStream.cache.Sas.Add(ev.Key, ev.Addr)
+				// hast add grpc addr
+				gStream.hash.Add(ev.Addr)
+			}
+		} else {
+			// Delete
+			if keyip, ok := gStream.cache.Sas.Get(ev.Key); ok {
+				gStream.hash.Remove(keyip)
+				gStream.cache.Sas.Del(ev.Key)
+				Logger.Info("Watch Delete", zap.String("key", ev.Key), zap.String("value", ev.Addr))
+				comput = true
+			}
+		}
+		// 重新计算在线用户是否需要保存在本台stream上
+		if comput {
+			Logger.Debug("需要重新计算用户是否落在本机上")
+		}
+		Logger.Debug("get new stream addrs", zap.Object("addrs", gStream.cache.Sas))
 	}
-	return nil
 }
 
 // NewUpdateAddr new UpdateAddr
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/aiyun/gomqtt/registry"
+)
+
+func TestUpdateAddr_WatchWork(t *testing.T) {
+	gStream = &Stream{cache: NewCache(), hash: NewHash()}
+
+	ch := make(chan registry.Event, 4)
+	ch <- registry.Event{Type: registry.EventAdd, Service: registry.Service{Key: "s1", Addr: "10.0.0.1:8991"}}
+	// the address of s1 is changed
+	ch <- registry.Event{Type: registry.EventAdd, Service: registry.Service{Key: "s1", Addr: "10.0.0.2:8991"}}
+	close(ch)
+	(&UpdateAddr{}).WatchWork(ch)
+
+	if addr, _ := gStream.cache.Sas.Get("s1"); addr != "10.0.0.2:8991" {
+		t.Errorf("addr of s1 = %q, want the new one", addr)
+	}
+	// the old address is removed from the hash
+	if addr, err := gStream.hash.Get("user"); err != nil || addr != "10.0.0.2:8991" {
+		t.Errorf("hash.Get() = %q, %v, want the new address", addr, err)
+	}
+
+	ch = make(chan registry.Event, 1)
+	ch <- registry.Event{Type: registry.EventRemove, Service: registry.Service{Key: "s1", Addr: "10.0.0.2:8991"}}
+	close(ch)
+	(&UpdateAddr{}).WatchWork(ch)
+
+	if _, err := gStream.hash.Get("user"); err == nil {
+		t.Errorf("hash.Get() after removing s1 succeeded")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	context "golang.org/x/net/context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/aiyun/gomqtt/proto"
 	"github.com/uber-go/zap"
@@ -29,9 +31,12 @@ func (rpc *Rpc) Start() {
 	if err != nil {
 		Logger.Panic("Init", zap.Error(err))
 	}
-	rpc.gs = grpc.NewServer()
+	rpc.gs = grpc.NewServer(grpc.UnaryInterceptor(rpcMetrics))
 
 	proto.RegisterRpcServer(rpc.gs, &Rpc{})
+
+	// the gateways check it before routing to this stream
+	healthpb.RegisterHealthServer(rpc.gs, health.NewServer())
 	go rpc.gs.Serve(l)
 }
 
@@ -98,6 +103,7 @@ func (rpc *Rpc) LogIn(ctx context.Context, am *proto.AccMsg) (*proto.Reply, erro
 
 // LogOut 登出
 func (rpc *Rpc) LogOut(ctx context.Context, am *proto.AccMsg) (*proto.Reply, error) {
+	// 共享订阅由gateway逐个退订，这里无需处理
 
 	return &proto.Reply{Msg: "帅帅帅帅"}, nil
 }
@@ -106,14 +112,29 @@ func (rpc *Rpc) LogOut(ctx context.Context, am *proto.AccMsg) (*proto.Reply, err
 
 // Subscribe 订阅
 func (rpc *Rpc) Subscribe(ctx context.Context, tm *proto.TcMsg) (*proto.Reply, error) {
+	if tm.Group != "" {
+		shares.add(tm)
+	}
 	return &proto.Reply{Msg: "帅帅帅帅"}, nil
 }
 
 // UnSubscribe 取消订阅
 func (rpc *Rpc) UnSubscribe(ctx context.Context, tm *proto.TcMsg) (*proto.Reply, error) {
+	if tm.Group != "" {
+		shares.remove(tm)
+	}
 	return &proto.Reply{Msg: "帅帅帅帅"}, nil
 }
 
+// ---------------- 发布相关接口  ----------------
+
+// Publish 客户端发布的消息
+func (rpc *Rpc) Publish(ctx context.Context, pm *proto.PubMsg) (*proto.Reply, error) {
+	// 每个匹配的共享订阅组投递给其中一个成员
+	shares.publish(pm)
+	return &proto.Reply{}, nil
+}
+
 // BPull 拉取广播推送
 func (rpc *Rpc) BPull(ctx context.Context, bm *proto.BPushMsg) (*proto.Reply, error) {
 
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	mqtt "github.com/aiyun/gomqtt/mqtt/protocol"
+	"github.com/aiyun/gomqtt/proto"
+	"github.com/uber-go/zap"
+)
+
+// strategies of picking the member of a shared subscription group
+const (
+	shareRoundRobin = "round_robin"
+	shareRandom     = "random"
+	// the messages of one publisher go to the same member
+	shareHash = "hash"
+)
+
+// shareMember is a connection subscribed in the group, it can be on any gateway
+type shareMember struct {
+	gip   string
+	conId int64
+	cid   string
+	qos   int32
+}
+
+func (m *shareMember) key() string {
+	return m.gip + "/" + strconv.FormatInt(m.conId, 10)
+}
+
+type shareGroup struct {
+	filter  string
+	members []*shareMember
+	next    int
+}
+
+// shareGroups keeps the shared subscriptions owned by this stream, the key is group/filter
+type shareGroups struct {
+	sync.Mutex
+	groups map[string]*shareGroup
+}
+
+var shares = newShareGroups()
+
+func newShareGroups() *shareGroups {
+	return &shareGroups{groups: make(map[string]*shareGroup)}
+}
+
+func shareStrategy() string {
+	if Conf.ShareC == nil {
+		return shareRoundRobin
+	}
+	return Conf.ShareC.Strategy
+}
+
+func validStrategy(s string) bool {
+	switch s {
+	case shareRoundRobin, shareRandom, shareHash:
+		return true
+	}
+	return false
+}
+
+// add joins the group, subscribing again updates the qos
+func (s *shareGroups) add(tm *proto.TcMsg) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := tm.Group + "/" + string(tm.Tp)
+	g, ok := s.groups[key]
+	if !ok {
+		g = &shareGroup{filter: string(tm.Tp)}
+		s.groups[key] = g
+	}
+
+	m := &shareMember{gip: tm.Gip, conId: tm.ConId, cid: tm.Cid, qos: tm.Qos}
+	for i, old := range g.members {
+		if old.key() == m.key() {
+			g.members[i] = m
+			return
+		}
+	}
+	g.members = append(g.members, m)
+}
+
+func (s *shareGroups) remove(tm *proto.TcMsg) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removeMember(tm.Group+"/"+string(tm.Tp), tm.Gip+"/"+strconv.FormatInt(tm.ConId, 10))
+}
+
+// removeMember is called with the lock held, the empty group is dropped
+func (s *shareGroups) removeMember(key, member string) {
+	g, ok := s.groups[key]
+	if !ok {
+		return
+	}
+
+	for i, m := range g.members {
+		if m.key() == member {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+
+	if len(g.members) == 0 {
+		delete(s.groups, key)
+	}
+}
+
+// matching returns the keys of the groups whose filter matches the topic
+func (s *shareGroups) matching(topic string) []string {
+	s.Lock()
+	defer s.Unlock()
+
+	var keys []string
+	for key, g := range s.groups {
+		if mqtt.TopicMatch(g.filter, topic) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// pick chooses a member not in tried, nil is returned if there is none
+func (s *shareGroups) pick(key, publisher string, tried map[string]bool) *shareMember {
+	s.Lock()
+	defer s.Unlock()
+
+	g, ok := s.groups[key]
+	if !ok {
+		return nil
+	}
+
+	var cands []*shareMember
+	for _, m := range g.members {
+		if !tried[m.key()] {
+			cands = append(cands, m)
+		}
+	}
+	if len(cands) == 0 {
+		return nil
+	}
+
+	switch shareStrategy() {
+	case shareRandom:
+		return cands[rand.Intn(len(cands))]
+	case shareHash:
+		h := fnv.New32a()
+		h.Write([]byte(publisher))
+		return cands[h.Sum32()%uint32(len(cands))]
+	}
+
+	g.next++
+	return cands[g.next%len(cands)]
+}
+
+// publish delivers the message to one member of each matching group
+func (s *shareGroups) publish(pm *proto.PubMsg) {
+	for _, key := range s.matching(string(pm.Tp)) {
+		s.dispatch(key, pm, make(map[string]bool))
+	}
+}
+
+// dispatch delivers the message to a member, qos1 messages go to another member until one acks
+func (s *shareGroups) dispatch(key string, pm *proto.PubMsg, tried map[string]bool) {
+	m := s.pick(key, pm.Cid, tried)
+	if m == nil {
+		Logger.Warn("shared message dropped, no member left", zap.String("group", key), zap.String("topic", string(pm.Tp)))
+		return
+	}
+
+	qos := pm.Qos
+	if m.qos < qos {
+		qos = m.qos
+	}
+
+	d := &proto.Delivery{ConId: m.conId, Cid: m.cid, Tp: pm.Tp, Pl: pm.Pl, Qos: qos}
+	done := func(code int32) {
+		switch code {
+		case ackDelivered, ackInvalid:
+			return
+		case ackNotFound:
+			// the connection is gone without leaving the group
+			s.Lock()
+			s.removeMember(key, m.key())
+			s.Unlock()
+		}
+
+		if qos == 0 {
+			return
+		}
+
+		tried[m.key()] = true
+		s.dispatch(key, pm, tried)
+	}
+
+	if err := deliverTo(m.gip, d, done); err != nil {
+		Logger.Warn("shared message delivery error", zap.String("gip", m.gip), zap.Error(err))
+		done(ackUndelivered)
+	}
+}
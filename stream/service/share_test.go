@@ -0,0 +1,130 @@
+package service
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aiyun/gomqtt/proto"
+	"github.com/uber-go/zap"
+)
+
+func TestMain(m *testing.M) {
+	Logger = zap.New(zap.NewJSONEncoder(), zap.DiscardOutput)
+	os.Exit(m.Run())
+}
+
+// fakeDeliver records the deliveries and acks them with the codes of the members
+func fakeDeliver(t *testing.T, codes map[int64]int32) *[]int64 {
+	var got []int64
+	old := deliverTo
+	t.Cleanup(func() { deliverTo = old })
+
+	deliverTo = func(gip string, d *proto.Delivery, done func(int32)) error {
+		got = append(got, d.ConId)
+		done(codes[d.ConId])
+		return nil
+	}
+	return &got
+}
+
+func testShares(strategy string, members ...int64) *shareGroups {
+	Conf = &Config{ShareC: &ShareConfig{Strategy: strategy}}
+
+	s := newShareGroups()
+	for _, id := range members {
+		s.add(&proto.TcMsg{Group: "g1", Tp: []byte("a/#"), Qos: 1, Gip: "gw1", ConId: id, Cid: "c" + strconv.FormatInt(id, 10)})
+	}
+	return s
+}
+
+func Test_shareGroups_pick(t *testing.T) {
+	old := Conf
+	defer func() { Conf = old }()
+
+	tests := []struct {
+		name     string
+		strategy string
+		pubs     []string
+		want     []int64
+	}{
+		{"round robin", shareRoundRobin, []string{"p1", "p1", "p1", "p1"}, []int64{2, 3, 1, 2}},
+		{"hash", shareHash, []string{"p1", "p1", "p1"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testShares(tt.strategy, 1, 2, 3)
+
+			var got []int64
+			for _, p := range tt.pubs {
+				got = append(got, s.pick("g1/a/#", p, nil).conId)
+			}
+
+			if tt.want == nil {
+				// the same publisher always gets the same member
+				for _, id := range got {
+					if id != got[0] {
+						t.Fatalf("pick() = %v, want the same member", got)
+					}
+				}
+				return
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("pick() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	s := testShares(shareRandom, 1, 2)
+	if m := s.pick("g1/a/#", "p1", map[string]bool{"gw1/1": true}); m == nil || m.conId != 2 {
+		t.Errorf("pick() = %v, want the untried member", m)
+	}
+	if m := s.pick("g1/a/#", "p1", map[string]bool{"gw1/1": true, "gw1/2": true}); m != nil {
+		t.Errorf("pick() = %v, want nil when all are tried", m)
+	}
+}
+
+func Test_shareGroups_publish(t *testing.T) {
+	old := Conf
+	defer func() { Conf = old }()
+
+	tests := []struct {
+		name  string
+		qos   int32
+		topic string
+		codes map[int64]int32
+		want  int
+	}{
+		{"delivered", 1, "a/b", nil, 1},
+		{"not matched", 1, "b/c", nil, 0},
+		{"qos1 redelivered", 1, "a/b", map[int64]int32{2: ackUndelivered, 1: ackNotFound}, 3},
+		{"qos0 not redelivered", 0, "a/b", map[int64]int32{2: ackUndelivered}, 1},
+		{"invalid not redelivered", 1, "a/b", map[int64]int32{2: ackInvalid}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testShares(shareRoundRobin, 1, 2, 3)
+			got := fakeDeliver(t, tt.codes)
+
+			s.publish(&proto.PubMsg{Tp: []byte(tt.topic), Pl: []byte("x"), Qos: tt.qos, Cid: "p1"})
+			if len(*got) != tt.want {
+				t.Errorf("deliveries = %v, want %d", *got, tt.want)
+			}
+		})
+	}
+
+	// the member whose connection is gone leaves the group
+	s := testShares(shareRoundRobin, 1, 2)
+	fakeDeliver(t, map[int64]int32{2: ackNotFound})
+	s.publish(&proto.PubMsg{Tp: []byte("a/b"), Pl: []byte("x"), Qos: 1})
+	if n := len(s.groups["g1/a/#"].members); n != 1 {
+		t.Errorf("members = %d, want 1", n)
+	}
+
+	s.remove(&proto.TcMsg{Group: "g1", Tp: []byte("a/#"), Gip: "gw1", ConId: 1})
+	if len(s.groups) != 0 {
+		t.Errorf("groups = %v, want the empty group dropped", s.groups)
+	}
+}
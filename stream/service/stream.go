@@ -1,6 +1,9 @@
 package service
 
-import "github.com/labstack/echo"
+import (
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
 
 type Stream struct {
 	upa   *UpdateAddr
@@ -16,11 +19,13 @@ func New() *Stream {
 	return stream
 }
 
-func (s *Stream) Init() {
+func (s *Stream) Init() error {
 
 	// init etcd
 	upa := NewUpdateAddr()
-	upa.Init()
+	if err := upa.Init(); err != nil {
+		return err
+	}
 
 	// init cache
 	cache := NewCache()
@@ -40,22 +45,29 @@ func (s *Stream) Init() {
 	s.hash = hash
 
 	gStream = s
+	return nil
 }
 
-func (s *Stream) Start(isStatic bool) {
+func (s *Stream) Start(isStatic bool) error {
 
 	loadConfig(isStatic)
 
 	// stream 初始化所有功能服务
-	s.Init()
+	if err := s.Init(); err != nil {
+		return err
+	}
 
 	// rpc start
 	s.rpc.Start()
 
 	// upa start
-	s.upa.Start()
+	if err := s.upa.Start(); err != nil {
+		s.rpc.Close()
+		return err
+	}
 
 	go httpStart()
+	return nil
 }
 
 func (s *Stream) Close() error {
@@ -71,6 +83,9 @@ func httpStart() {
 	// 启动配置热更新
 	e.GET("/reload", reload)
 
+	// prometheus metrics
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+
 	// e.Run(standard.New(":8907"))
 
 	err := e.Start(":8907")